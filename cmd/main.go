@@ -6,11 +6,19 @@ import (
 	"log"
 	"os"
 
-	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"
 
 	"catcam_go/internal/db"
 	"catcam_go/internal/server"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/events"
+	"catcam_go/internal/store/lockouts"
+	"catcam_go/internal/store/recordings"
+	"catcam_go/internal/store/sessions"
+	"catcam_go/internal/store/sharelinks"
+	"catcam_go/internal/store/timelapses"
+	"catcam_go/internal/store/twofactor"
 	"catcam_go/internal/store/users"
 
 	_ "github.com/joho/godotenv/autoload" // Automatically load .env file
@@ -21,28 +29,48 @@ func main() {
 
 	port := 9001
 
-	dbPool, err := sql.Open("sqlite", "db.sqlite")
+	// Foreign keys are off by default in SQLite, and must be turned on for each connection
+	dbPool, err := sql.Open("sqlite", "db.sqlite?_pragma=foreign_keys(1)")
 	if err != nil {
 		logger.Fatalf("Error when opening database: %s", err)
 	}
 
-	log.Println("Initializing database...")
-	if err := db.GenSchema(dbPool); err != nil {
-		log.Fatal(err)
+	logger.Print("Migrating database...")
+	if err := db.Migrate(context.Background(), dbPool, logger); err != nil {
+		logger.Fatalf("Error when migrating database: %s", err)
 	}
 
 	logger.Print("Creating users store..")
 	userStore := users.NewUserStore(db.New(dbPool), logger)
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
-	if err != nil {
-		logger.Fatalf("Error when hashing password: %s", err)
-	}
-	userStore.AddUser(context.Background(), db.AddUserParams{
-		Username:     "saltytaro",
-		PasswordHash: string(passwordHash),
-	})
 
-	srv, err := server.NewServer(logger, port, userStore)
+	logger.Print("Creating recordings store..")
+	recordingStore := recordings.NewRecordingStore(db.New(dbPool), logger)
+
+	logger.Print("Creating motion events store..")
+	eventStore := events.NewEventStore(db.New(dbPool), logger)
+
+	logger.Print("Creating timelapses store..")
+	timelapseStore := timelapses.NewTimelapseStore(db.New(dbPool), logger)
+
+	logger.Print("Creating sessions store..")
+	sessionStore := sessions.NewSessionStore(db.New(dbPool), logger)
+
+	logger.Print("Creating lockouts store..")
+	lockoutStore := lockouts.NewLockoutStore(db.New(dbPool), logger)
+
+	logger.Print("Creating two-factor store..")
+	twoFactorStore := twofactor.NewTwoFactorStore(db.New(dbPool), logger)
+
+	logger.Print("Creating API token store..")
+	apiTokenStore := apitokens.NewAPITokenStore(db.New(dbPool), logger)
+
+	logger.Print("Creating share link store..")
+	shareLinkStore := sharelinks.NewShareLinkStore(db.New(dbPool), logger)
+
+	logger.Print("Creating audit store..")
+	auditStore := audit.NewAuditStore(db.New(dbPool), logger)
+
+	srv, err := server.NewServer(logger, port, userStore, recordingStore, eventStore, timelapseStore, sessionStore, lockoutStore, twoFactorStore, apiTokenStore, shareLinkStore, auditStore)
 	if err != nil {
 		logger.Fatalf("Error when creating server: %s", err)
 		os.Exit(1)
@@ -5,7 +5,6 @@ go 1.23.5
 require modernc.org/sqlite v1.34.5
 
 require (
-	github.com/gorilla/securecookie v1.1.2 // indirect
 	golang.org/x/exp v0.0.0-20230315142452-642cacee5cc0 // indirect
 )
 
@@ -13,6 +12,7 @@ require (
 	github.com/a-h/templ v0.3.833
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/securecookie v1.1.2
 	github.com/gorilla/sessions v1.4.0
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-isatty v0.0.20 // indirect
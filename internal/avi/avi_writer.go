@@ -0,0 +1,222 @@
+// Package avi writes Motion JPEG AVI files, which almost every video player can play, without
+// needing ffmpeg or re-encoding the camera's frames
+package avi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Offsets of the header fields that can only be filled in once every frame has been written
+const (
+	offsetRiffSize       = 4
+	offsetMaxBytesPerSec = 36
+	offsetTotalFrames    = 48
+	offsetAvihBufferSize = 60
+	offsetStreamLength   = 140
+	offsetStrhBufferSize = 144
+	offsetMoviSize       = 216
+	offsetMoviFourCC     = 220
+	headerSize           = 224
+)
+
+const (
+	maxFileSize            = 1<<32 - 1 // Plain (not OpenDML) AVI files use 32 bit sizes
+	avifHasIndex           = 0x10
+	aviifKeyFrame          = 0x10
+	frameChunkHeaderLength = 8
+)
+
+type indexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+// Writer writes JPEG frames into an AVI file. The output must be seekable since the headers can only
+// be completed in Close
+type Writer struct {
+	w            io.WriteSeeker
+	fps          int
+	pos          int64
+	index        []indexEntry
+	maxFrameSize uint32
+	closed       bool
+}
+
+// NewWriter writes the AVI headers for a video of the given frame size and rate, to be followed by
+// calls to WriteFrame
+func NewWriter(w io.WriteSeeker, width, height, fps int) (*Writer, error) {
+	if width <= 0 || height <= 0 || fps <= 0 {
+		return nil, fmt.Errorf("invalid video dimensions %dx%d at %d fps", width, height, fps)
+	}
+
+	h := make([]byte, 0, headerSize)
+	u32 := func(v uint32) { h = binary.LittleEndian.AppendUint32(h, v) }
+	u16 := func(v uint16) { h = binary.LittleEndian.AppendUint16(h, v) }
+	fourCC := func(s string) { h = append(h, s...) }
+
+	fourCC("RIFF")
+	u32(0) // File size, filled in by Close
+	fourCC("AVI ")
+
+	fourCC("LIST")
+	u32(offsetMoviSize - 4 - 20) // Size of the hdrl list
+	fourCC("hdrl")
+
+	// Main AVI header
+	fourCC("avih")
+	u32(56)
+	u32(uint32(1000000 / fps)) // Microseconds per frame
+	u32(0)                     // Max bytes per second, filled in by Close
+	u32(0)                     // Padding granularity
+	u32(avifHasIndex)          // Flags
+	u32(0)                     // Total frames, filled in by Close
+	u32(0)                     // Initial frames
+	u32(1)                     // Number of streams
+	u32(0)                     // Suggested buffer size, filled in by Close
+	u32(uint32(width))
+	u32(uint32(height))
+	u32(0) // Reserved
+	u32(0)
+	u32(0)
+	u32(0)
+
+	fourCC("LIST")
+	u32(offsetMoviSize - 4 - 96) // Size of the strl list
+	fourCC("strl")
+
+	// Stream header
+	fourCC("strh")
+	u32(56)
+	fourCC("vids")
+	fourCC("MJPG")
+	u32(0) // Flags
+	u16(0) // Priority
+	u16(0) // Language
+	u32(0) // Initial frames
+	u32(1) // Scale
+	u32(uint32(fps))
+	u32(0)          // Start
+	u32(0)          // Length in frames, filled in by Close
+	u32(0)          // Suggested buffer size, filled in by Close
+	u32(0xFFFFFFFF) // Quality (default)
+	u32(0)          // Sample size (varies)
+	u16(0)          // Frame rectangle
+	u16(0)
+	u16(uint16(width))
+	u16(uint16(height))
+
+	// Stream format (BITMAPINFOHEADER)
+	fourCC("strf")
+	u32(40)
+	u32(40)
+	u32(uint32(width))
+	u32(uint32(height))
+	u16(1)  // Planes
+	u16(24) // Bits per pixel
+	fourCC("MJPG")
+	u32(uint32(width * height * 3))
+	u32(0) // Horizontal pixels per metre
+	u32(0) // Vertical pixels per metre
+	u32(0) // Colours used
+	u32(0) // Important colours
+
+	fourCC("LIST")
+	u32(0) // Size of the movi list, filled in by Close
+	fourCC("movi")
+
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, fps: fps, pos: headerSize}, nil
+}
+
+// WriteFrame appends a JPEG frame to the video
+func (aw *Writer) WriteFrame(frame []byte) error {
+	if aw.closed {
+		return fmt.Errorf("write to closed AVI writer")
+	}
+
+	size := int64(len(frame))
+	padded := size + size%2
+	indexSize := int64(len(aw.index)+1) * 16
+	if aw.pos+frameChunkHeaderLength+padded+8+indexSize > maxFileSize {
+		return fmt.Errorf("AVI file would exceed 4GB")
+	}
+
+	chunk := make([]byte, 0, frameChunkHeaderLength)
+	chunk = append(chunk, "00dc"...)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(size))
+	if _, err := aw.w.Write(chunk); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(frame); err != nil {
+		return err
+	}
+	if size%2 != 0 {
+		// Chunks are word aligned
+		if _, err := aw.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	aw.index = append(aw.index, indexEntry{offset: uint32(aw.pos - offsetMoviFourCC), size: uint32(size)})
+	aw.maxFrameSize = max(aw.maxFrameSize, uint32(size))
+	aw.pos += frameChunkHeaderLength + padded
+	return nil
+}
+
+// Close writes the index and completes the headers. It does not close the underlying writer
+func (aw *Writer) Close() error {
+	if aw.closed {
+		return nil
+	}
+	aw.closed = true
+
+	moviSize := aw.pos - offsetMoviSize - 4
+
+	idx := make([]byte, 0, 8+16*len(aw.index))
+	idx = append(idx, "idx1"...)
+	idx = binary.LittleEndian.AppendUint32(idx, uint32(16*len(aw.index)))
+	for _, entry := range aw.index {
+		idx = append(idx, "00dc"...)
+		idx = binary.LittleEndian.AppendUint32(idx, aviifKeyFrame)
+		idx = binary.LittleEndian.AppendUint32(idx, entry.offset)
+		idx = binary.LittleEndian.AppendUint32(idx, entry.size)
+	}
+	if _, err := aw.w.Write(idx); err != nil {
+		return err
+	}
+	fileSize := aw.pos + int64(len(idx))
+
+	frames := uint32(len(aw.index))
+	bufferSize := aw.maxFrameSize + frameChunkHeaderLength
+	for _, field := range []struct {
+		offset int64
+		value  uint32
+	}{
+		{offsetRiffSize, uint32(fileSize - 8)},
+		{offsetMaxBytesPerSec, aw.maxFrameSize * uint32(aw.fps)},
+		{offsetTotalFrames, frames},
+		{offsetAvihBufferSize, bufferSize},
+		{offsetStreamLength, frames},
+		{offsetStrhBufferSize, bufferSize},
+		{offsetMoviSize, uint32(moviSize)},
+	} {
+		if _, err := aw.w.Seek(field.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(aw.w, binary.LittleEndian, field.value); err != nil {
+			return err
+		}
+	}
+
+	_, err := aw.w.Seek(fileSize, io.SeekStart)
+	return err
+}
+
+// FrameCount is the number of frames written so far
+func (aw *Writer) FrameCount() int {
+	return len(aw.index)
+}
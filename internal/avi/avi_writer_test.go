@@ -0,0 +1,140 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Walk the chunks in data, which must fill it exactly, calling visit with each one's FourCC and body
+func walkChunks(t *testing.T, data []byte, visit func(fourCC string, body []byte)) {
+	t.Helper()
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("%d bytes left over after the last chunk", len(data))
+		}
+		fourCC, size := string(data[:4]), int(binary.LittleEndian.Uint32(data[4:8]))
+		if 8+size > len(data) {
+			t.Fatalf("%s chunk of %d bytes runs past the end of its list", fourCC, size)
+		}
+		visit(fourCC, data[8:8+size])
+		data = data[min(8+size+size%2, len(data)):]
+	}
+}
+
+func TestWriterWritesPlayableAVI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.avi")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// Odd sizes have to be padded to keep the chunks word aligned
+	frames := [][]byte{[]byte("first frame"), []byte("second"), []byte("the third frame")}
+	video, err := NewWriter(file, 640, 480, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		if err := video.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if video.FrameCount() != len(frames) {
+		t.Errorf("FrameCount() = %d, want %d", video.FrameCount(), len(frames))
+	}
+	if err := video.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := video.WriteFrame(frames[0]); err == nil {
+		t.Error("wrote a frame after closing")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("file starts %q, want a RIFF AVI", data[:12])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+		t.Errorf("RIFF size %d, want %d", size, len(data)-8)
+	}
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(data[offset:]) }
+	if got := u32(offsetTotalFrames); got != uint32(len(frames)) {
+		t.Errorf("avih total frames %d, want %d", got, len(frames))
+	}
+	if got := u32(offsetStreamLength); got != uint32(len(frames)) {
+		t.Errorf("strh length %d, want %d", got, len(frames))
+	}
+	if width, height := u32(64), u32(68); width != 640 || height != 480 {
+		t.Errorf("avih size %dx%d, want 640x480", width, height)
+	}
+
+	var lists []string
+	var written [][]byte
+	var index []byte
+	walkChunks(t, data[12:], func(fourCC string, body []byte) {
+		switch fourCC {
+		case "LIST":
+			lists = append(lists, string(body[:4]))
+			if string(body[:4]) == "movi" {
+				walkChunks(t, body[4:], func(fourCC string, frame []byte) {
+					if fourCC != "00dc" {
+						t.Errorf("movi list has a %s chunk, want only 00dc", fourCC)
+					}
+					written = append(written, frame)
+				})
+			}
+		case "idx1":
+			index = body
+		default:
+			t.Errorf("unexpected %s chunk", fourCC)
+		}
+	})
+	if len(lists) != 2 || lists[0] != "hdrl" || lists[1] != "movi" {
+		t.Errorf("lists %v, want hdrl then movi", lists)
+	}
+	if len(written) != len(frames) {
+		t.Fatalf("movi list has %d frames, want %d", len(written), len(frames))
+	}
+	for i := range frames {
+		if !bytes.Equal(written[i], frames[i]) {
+			t.Errorf("frame %d is %q, want %q", i, written[i], frames[i])
+		}
+	}
+
+	// Each index entry points at its frame's chunk, counting from the movi FourCC
+	if len(index) != 16*len(frames) {
+		t.Fatalf("idx1 is %d bytes, want 16 per frame", len(index))
+	}
+	for i := range frames {
+		entry := index[16*i:]
+		offset, size := int(binary.LittleEndian.Uint32(entry[8:])), int(binary.LittleEndian.Uint32(entry[12:]))
+		chunk := data[offsetMoviFourCC+offset:]
+		if string(entry[:4]) != "00dc" || string(chunk[:4]) != "00dc" || !bytes.Equal(chunk[8:8+size], frames[i]) {
+			t.Errorf("index entry %d (offset %d, size %d) doesn't point at frame %d", i, offset, size, i)
+		}
+	}
+}
+
+func TestWriterRejectsInvalidDimensions(t *testing.T) {
+	for _, test := range []struct{ width, height, fps int }{
+		{0, 480, 30},
+		{640, 0, 30},
+		{640, 480, 0},
+		{-640, 480, 30},
+	} {
+		file, err := os.Create(filepath.Join(t.TempDir(), "test.avi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewWriter(file, test.width, test.height, test.fps); err == nil {
+			t.Errorf("NewWriter(%d, %d, %d) succeeded, want an error", test.width, test.height, test.fps)
+		}
+		file.Close()
+	}
+}
@@ -1,8 +1,8 @@
 /* === CONTACTS === */
 
 -- name: AddUser :one
-INSERT INTO users (username, password_hash) 
-VALUES (?, ?)
+INSERT INTO users (username, password_hash, role) 
+VALUES (?, ?, ?)
 RETURNING *;
 
 -- name: GetUserById :one
@@ -33,3 +33,303 @@ UPDATE users
 SET last_login = datetime()
 WHERE id = ?;
 
+-- name: SetUserRole :exec
+UPDATE users
+SET role = ?
+WHERE id = ?;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = ?;
+
+-- name: SetUserPassword :execrows
+UPDATE users
+SET password_hash = ?, must_change_password = ?
+WHERE id = ?;
+
+
+/* === RECORDINGS === */
+
+-- name: AddRecording :one
+INSERT INTO recordings (path, started_at)
+VALUES (?, ?)
+RETURNING *;
+
+-- name: FinishRecording :exec
+UPDATE recordings
+SET ended_at = ?, frame_count = ?, size_bytes = ?
+WHERE id = ?;
+
+-- name: GetRecordings :many
+SELECT *
+FROM recordings
+ORDER BY started_at DESC;
+
+-- name: GetRecordingsOldestFirst :many
+SELECT *
+FROM recordings
+WHERE ended_at IS NOT NULL
+ORDER BY started_at ASC;
+
+-- name: GetRecordingsStartedBefore :many
+SELECT *
+FROM recordings
+WHERE started_at < ? AND ended_at IS NOT NULL
+ORDER BY started_at ASC;
+
+-- name: GetRecordingsTotalSize :one
+SELECT CAST(COALESCE(SUM(size_bytes), 0) AS INTEGER) AS total_size
+FROM recordings;
+
+-- name: DeleteRecording :one
+DELETE FROM recordings
+WHERE id = ?
+RETURNING *;
+
+/* === MOTION EVENTS === */
+
+-- name: AddMotionEvent :one
+INSERT INTO motion_events (started_at, clip_path, clip_started_at)
+VALUES (?, ?, ?)
+RETURNING *;
+
+-- name: FinishMotionEvent :exec
+UPDATE motion_events
+SET ended_at = ?, peak_score = ?, frame_count = ?, size_bytes = ?, thumbnail = ?
+WHERE id = ?;
+
+-- name: GetMotionEventById :one
+SELECT *
+FROM motion_events
+WHERE id = ?;
+
+-- name: GetMotionEvents :many
+SELECT *
+FROM motion_events
+ORDER BY started_at DESC;
+
+-- name: GetMotionEventsBetween :many
+SELECT *
+FROM motion_events
+WHERE started_at >= sqlc.arg(start_time) AND started_at < sqlc.arg(end_time)
+ORDER BY started_at DESC;
+
+/* === TIMELAPSES === */
+
+-- name: AddTimelapse :one
+INSERT INTO timelapses (day, path, frame_count, size_bytes, created_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (day) DO UPDATE
+SET path = excluded.path, frame_count = excluded.frame_count, size_bytes = excluded.size_bytes, created_at = excluded.created_at
+RETURNING *;
+
+-- name: GetTimelapseById :one
+SELECT *
+FROM timelapses
+WHERE id = ?;
+
+-- name: GetTimelapses :many
+SELECT *
+FROM timelapses
+ORDER BY day DESC;
+
+/* === SESSIONS === */
+
+-- name: AddSession :one
+INSERT INTO sessions (token_hash, user_id, data, ip, user_agent, created_at, last_seen, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: UpdateSession :exec
+UPDATE sessions
+SET user_id = ?, data = ?, ip = ?, user_agent = ?, last_seen = ?, expires_at = ?
+WHERE id = ?;
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET ip = ?, user_agent = ?, last_seen = ?
+WHERE id = ?;
+
+-- name: GetSessionByTokenHash :one
+SELECT *
+FROM sessions
+WHERE token_hash = ?;
+
+-- name: GetSessionsForUser :many
+SELECT *
+FROM sessions
+WHERE user_id = ? AND expires_at > ?
+ORDER BY last_seen DESC;
+
+-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE id = ?;
+
+-- name: DeleteSessionForUser :execrows
+DELETE FROM sessions
+WHERE id = ? AND user_id = ?;
+
+-- name: DeleteSessionsForUser :exec
+DELETE FROM sessions
+WHERE user_id = ?;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions
+WHERE expires_at <= ?;
+
+/* === LOCKOUTS === */
+
+-- name: GetLockout :one
+SELECT *
+FROM lockouts
+WHERE kind = ? AND subject = ?;
+
+-- name: GetLockouts :many
+SELECT *
+FROM lockouts
+ORDER BY last_failure DESC;
+
+-- name: SetLockout :one
+INSERT INTO lockouts (kind, subject, failures, last_failure, locked_until)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (kind, subject) DO UPDATE
+SET failures = excluded.failures, last_failure = excluded.last_failure, locked_until = excluded.locked_until
+RETURNING *;
+
+-- name: ClearLockout :exec
+DELETE FROM lockouts
+WHERE kind = ? AND subject = ?;
+
+-- name: DeleteLockout :execrows
+DELETE FROM lockouts
+WHERE id = ?;
+
+-- name: DeleteLockoutsLastFailedBefore :exec
+DELETE FROM lockouts
+WHERE last_failure < ?;
+
+
+/* === TWO-FACTOR === */
+
+-- name: GetTotp :one
+SELECT *
+FROM totp
+WHERE user_id = ?;
+
+-- name: SetTotp :exec
+INSERT INTO totp (user_id, secret, last_step, enabled_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = excluded.secret, last_step = excluded.last_step, enabled_at = excluded.enabled_at;
+
+-- name: SetTotpLastStep :execrows
+UPDATE totp
+SET last_step = ?
+WHERE user_id = ? AND last_step < ?;
+
+-- name: DeleteTotp :exec
+DELETE FROM totp
+WHERE user_id = ?;
+
+-- name: AddRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES (?, ?);
+
+-- name: CountRecoveryCodes :one
+SELECT COUNT(*)
+FROM recovery_codes
+WHERE user_id = ?;
+
+-- name: UseRecoveryCode :execrows
+DELETE FROM recovery_codes
+WHERE user_id = ? AND code_hash = ?;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = ?;
+
+
+/* === API TOKENS === */
+
+-- name: AddApiToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetApiTokenByTokenHash :one
+SELECT *
+FROM api_tokens
+WHERE token_hash = ?;
+
+-- name: GetApiTokensForUser :many
+SELECT *
+FROM api_tokens
+WHERE user_id = ?
+ORDER BY created_at DESC;
+
+-- name: TouchApiToken :exec
+UPDATE api_tokens
+SET last_used = ?
+WHERE id = ?;
+
+-- name: DeleteApiTokenForUser :execrows
+DELETE FROM api_tokens
+WHERE id = ? AND user_id = ?;
+
+-- name: DeleteApiTokensForUser :exec
+DELETE FROM api_tokens
+WHERE user_id = ?;
+
+
+/* === SHARE LINKS === */
+
+-- name: AddShareLink :one
+INSERT INTO share_links (name, created_by, created_at, expires_at, max_viewers, allow_light)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetShareLink :one
+SELECT *
+FROM share_links
+WHERE id = ?;
+
+-- name: GetShareLinks :many
+SELECT *
+FROM share_links
+ORDER BY created_at DESC;
+
+-- name: DeleteShareLink :execrows
+DELETE FROM share_links
+WHERE id = ?;
+
+-- name: DeleteShareLinksExpiredBefore :exec
+DELETE FROM share_links
+WHERE expires_at < ?;
+
+
+/* === AUDIT LOG === */
+
+-- name: AddAuditEntry :one
+INSERT INTO audit_log (created_at, action, user_id, username, ip, detail)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetAuditEntries :many
+SELECT *
+FROM audit_log
+WHERE (sqlc.arg(action) = '' OR action = sqlc.arg(action))
+  AND (sqlc.arg(username) = '' OR username = sqlc.arg(username))
+  AND created_at >= sqlc.arg(start_time) AND created_at < sqlc.arg(end_time)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(max_entries);
+
+-- name: GetAuditUsernames :many
+SELECT DISTINCT username
+FROM audit_log
+WHERE username != ''
+ORDER BY username;
+
+-- name: DeleteAuditEntriesBefore :exec
+DELETE FROM audit_log
+WHERE created_at < ?;
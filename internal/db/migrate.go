@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Embed the migrations, named NNNN_description.sql and applied in order of their number. Once a
+// migration has been released it must never be edited; make a new one instead
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+type ErrSchemaTooNew struct {
+	Version      int
+	KnownVersion int
+}
+
+func (e ErrSchemaTooNew) Error() string {
+	return fmt.Sprintf("database schema is at version %d but this build only knows up to version %d, refusing to run against it", e.Version, e.KnownVersion)
+}
+
+// Read the embedded migrations, making sure they are numbered 1, 2, 3... with no gaps or repeats
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, p := range paths {
+		name := strings.TrimSuffix(path.Base(p), ".sql")
+		number, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s must start with its version number", p)
+		}
+		contents, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("expected migration %d but found %s", i+1, m.name)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate brings the database schema up to date, applying each migration it hasn't seen yet in its
+// own transaction. It refuses to touch a database whose schema is newer than this build knows about
+func Migrate(ctx context.Context, dbPool *sql.DB, logger *log.Logger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("error loading migrations: %w", err)
+	}
+
+	_, err = dbPool.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_version table: %w", err)
+	}
+
+	var current int
+	if err := dbPool.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	if current > len(migrations) {
+		return ErrSchemaTooNew{Version: current, KnownVersion: len(migrations)}
+	}
+
+	for _, m := range migrations[current:] {
+		logger.Printf("Applying database migration %s", m.name)
+		if err := applyMigration(ctx, dbPool, m); err != nil {
+			return fmt.Errorf("error applying migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, dbPool *sql.DB, m migration) error {
+	tx, err := dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbPool, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { dbPool.Close() })
+	return dbPool
+}
+
+func schemaVersion(t *testing.T, dbPool *sql.DB) (version int, applied int) {
+	t.Helper()
+	err := dbPool.QueryRow("SELECT COALESCE(MAX(version), 0), COUNT(*) FROM schema_version").Scan(&version, &applied)
+	if err != nil {
+		t.Fatalf("reading schema version: %v", err)
+	}
+	return version, applied
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	logger := log.New(io.Discard, "", 0)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loading migrations: %v", err)
+	}
+	latest := len(migrations)
+	dbPool := openTestDB(t)
+
+	// A fresh database gets every migration
+	if err := Migrate(ctx, dbPool, logger); err != nil {
+		t.Fatalf("migrating a fresh database: %v", err)
+	}
+	if version, applied := schemaVersion(t, dbPool); version != latest || applied != latest {
+		t.Fatalf("fresh database at version %d with %d migrations applied, want %d", version, applied, latest)
+	}
+	// The schema works with the queries
+	if _, err := New(dbPool).GetUsers(ctx); err != nil {
+		t.Errorf("querying the migrated database: %v", err)
+	}
+
+	// Running again changes nothing
+	if err := Migrate(ctx, dbPool, logger); err != nil {
+		t.Fatalf("migrating again: %v", err)
+	}
+	if version, applied := schemaVersion(t, dbPool); version != latest || applied != latest {
+		t.Errorf("after migrating again, at version %d with %d migrations applied, want %d", version, applied, latest)
+	}
+
+	// A database from a newer build is left alone
+	_, err = dbPool.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, 'from_the_future', CURRENT_TIMESTAMP)", latest+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Migrate(ctx, dbPool, logger)
+	var tooNew ErrSchemaTooNew
+	if !errors.As(err, &tooNew) || tooNew.Version != latest+1 || tooNew.KnownVersion != latest {
+		t.Errorf("migrating a newer schema: error %v, want ErrSchemaTooNew for version %d", err, latest+1)
+	}
+}
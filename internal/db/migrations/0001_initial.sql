@@ -0,0 +1,40 @@
+-- The schema as it was before versioned migrations. IF NOT EXISTS lets databases created back then
+-- pick up from here
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recordings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    path TEXT NOT NULL UNIQUE,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    frame_count INTEGER NOT NULL DEFAULT 0,
+    size_bytes INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS motion_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    peak_score REAL NOT NULL DEFAULT 0,
+    clip_path TEXT NOT NULL,
+    clip_started_at TIMESTAMP NOT NULL,
+    frame_count INTEGER NOT NULL DEFAULT 0,
+    size_bytes INTEGER NOT NULL DEFAULT 0,
+    thumbnail BLOB
+);
+
+CREATE TABLE IF NOT EXISTS timelapses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    day TEXT NOT NULL UNIQUE,
+    path TEXT NOT NULL,
+    frame_count INTEGER NOT NULL DEFAULT 0,
+    size_bytes INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Users are admins (can do anything), operators (can also control the light) or viewers (can only
+-- watch)
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer' CHECK (role IN ('admin', 'operator', 'viewer'));
+
+-- Everyone could do everything before there were roles
+UPDATE users SET role = 'admin';
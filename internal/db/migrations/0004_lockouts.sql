@@ -0,0 +1,11 @@
+-- Recent failed logins, counted separately for each username and each client IP address, so that
+-- password guessing gets slower and slower until it is locked out altogether
+CREATE TABLE lockouts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('username', 'ip')),
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP,
+    UNIQUE (kind, subject)
+);
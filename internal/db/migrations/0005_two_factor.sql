@@ -0,0 +1,17 @@
+-- Users can add a second step to logging in, a code from an authenticator app (RFC 6238 TOTP). Only
+-- users who have finished enrolling have a row here. last_step is the time step of the last code
+-- accepted, so each code can only be used once
+CREATE TABLE totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    enabled_at TIMESTAMP NOT NULL
+);
+
+-- One-time codes for logging in without the authenticator app. Only hashes of the codes are stored
+CREATE TABLE recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,3 @@
+-- Set when an admin resets a user's password, so that the user has to choose a new one as soon as
+-- they log in
+ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
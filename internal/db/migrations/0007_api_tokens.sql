@@ -0,0 +1,14 @@
+-- Personal API tokens let scripts use CatCam without logging in. As with sessions, only a hash of
+-- each token is stored. scope limits what a token can be used for, on top of its user's role
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scope TEXT NOT NULL CHECK (scope IN ('all', 'feed', 'light')),
+    created_at TIMESTAMP NOT NULL,
+    last_used TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX api_tokens_user_id ON api_tokens (user_id);
@@ -0,0 +1,11 @@
+-- Links that let guests watch the feed without an account until they expire. The links themselves
+-- are signed with the session key rather than stored, so only their settings are kept here
+CREATE TABLE share_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    max_viewers INTEGER NOT NULL DEFAULT 0,
+    allow_light BOOLEAN NOT NULL DEFAULT FALSE
+);
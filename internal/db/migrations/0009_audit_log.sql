@@ -0,0 +1,14 @@
+-- A record of security and control actions: who did what, from where and when. The username is kept
+-- alongside user_id so entries still say who it was after the user is deleted, and is also used for
+-- guests and failed logins that don't have a user
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    action TEXT NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    username TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_created_at ON audit_log (created_at);
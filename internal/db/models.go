@@ -6,12 +6,110 @@ package db
 
 import (
 	"database/sql"
+	"time"
 )
 
+type ApiToken struct {
+	ID        int64
+	UserID    int64
+	Name      string
+	TokenHash string
+	Scope     string
+	CreatedAt time.Time
+	LastUsed  sql.NullTime
+	ExpiresAt sql.NullTime
+}
+
+type AuditLog struct {
+	ID        int64
+	CreatedAt time.Time
+	Action    string
+	UserID    sql.NullInt64
+	Username  string
+	Ip        string
+	Detail    string
+}
+
+type Lockout struct {
+	ID          int64
+	Kind        string
+	Subject     string
+	Failures    int64
+	LastFailure time.Time
+	LockedUntil sql.NullTime
+}
+
+type MotionEvent struct {
+	ID            int64
+	StartedAt     time.Time
+	EndedAt       sql.NullTime
+	PeakScore     float64
+	ClipPath      string
+	ClipStartedAt time.Time
+	FrameCount    int64
+	SizeBytes     int64
+	Thumbnail     []byte
+}
+
+type Recording struct {
+	ID         int64
+	Path       string
+	StartedAt  time.Time
+	EndedAt    sql.NullTime
+	FrameCount int64
+	SizeBytes  int64
+}
+
+type RecoveryCode struct {
+	ID       int64
+	UserID   int64
+	CodeHash string
+}
+
+type Session struct {
+	ID        int64
+	TokenHash string
+	UserID    sql.NullInt64
+	Data      []byte
+	Ip        string
+	UserAgent string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+type ShareLink struct {
+	ID         int64
+	Name       string
+	CreatedBy  int64
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	MaxViewers int64
+	AllowLight bool
+}
+
+type Timelapse struct {
+	ID         int64
+	Day        string
+	Path       string
+	FrameCount int64
+	SizeBytes  int64
+	CreatedAt  time.Time
+}
+
+type Totp struct {
+	UserID    int64
+	Secret    string
+	LastStep  int64
+	EnabledAt time.Time
+}
+
 type User struct {
-	ID           int64
-	Username     string
-	PasswordHash string
-	CreatedAt    sql.NullTime
-	LastLogin    sql.NullTime
+	ID                 int64
+	Username           string
+	PasswordHash       string
+	CreatedAt          sql.NullTime
+	LastLogin          sql.NullTime
+	Role               string
+	MustChangePassword bool
 }
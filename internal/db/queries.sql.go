@@ -7,67 +7,1189 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"time"
 )
 
+const addApiToken = `-- name: AddApiToken :one
+INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, user_id, name, token_hash, scope, created_at, last_used, expires_at
+`
+
+type AddApiTokenParams struct {
+	UserID    int64
+	Name      string
+	TokenHash string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt sql.NullTime
+}
+
+func (q *Queries) AddApiToken(ctx context.Context, arg AddApiTokenParams) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, addApiToken, arg.UserID, arg.Name, arg.TokenHash, arg.Scope, arg.CreatedAt, arg.ExpiresAt)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scope,
+		&i.CreatedAt,
+		&i.LastUsed,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const addAuditEntry = `-- name: AddAuditEntry :one
+INSERT INTO audit_log (created_at, action, user_id, username, ip, detail)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, created_at, action, user_id, username, ip, detail
+`
+
+type AddAuditEntryParams struct {
+	CreatedAt time.Time
+	Action    string
+	UserID    sql.NullInt64
+	Username  string
+	Ip        string
+	Detail    string
+}
+
+func (q *Queries) AddAuditEntry(ctx context.Context, arg AddAuditEntryParams) (AuditLog, error) {
+	row := q.db.QueryRowContext(ctx, addAuditEntry, arg.CreatedAt, arg.Action, arg.UserID, arg.Username, arg.Ip, arg.Detail)
+	var i AuditLog
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.Action,
+		&i.UserID,
+		&i.Username,
+		&i.Ip,
+		&i.Detail,
+	)
+	return i, err
+}
+
+const addMotionEvent = `-- name: AddMotionEvent :one
+
+INSERT INTO motion_events (started_at, clip_path, clip_started_at)
+VALUES (?, ?, ?)
+RETURNING id, started_at, ended_at, peak_score, clip_path, clip_started_at, frame_count, size_bytes, thumbnail
+`
+
+type AddMotionEventParams struct {
+	StartedAt     time.Time
+	ClipPath      string
+	ClipStartedAt time.Time
+}
+
+// === MOTION EVENTS ===
+func (q *Queries) AddMotionEvent(ctx context.Context, arg AddMotionEventParams) (MotionEvent, error) {
+	row := q.db.QueryRowContext(ctx, addMotionEvent, arg.StartedAt, arg.ClipPath, arg.ClipStartedAt)
+	var i MotionEvent
+	err := row.Scan(
+		&i.ID,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.PeakScore,
+		&i.ClipPath,
+		&i.ClipStartedAt,
+		&i.FrameCount,
+		&i.SizeBytes,
+		&i.Thumbnail,
+	)
+	return i, err
+}
+
+const addRecording = `-- name: AddRecording :one
+
+INSERT INTO recordings (path, started_at)
+VALUES (?, ?)
+RETURNING id, path, started_at, ended_at, frame_count, size_bytes
+`
+
+type AddRecordingParams struct {
+	Path      string
+	StartedAt time.Time
+}
+
+// === RECORDINGS ===
+func (q *Queries) AddRecording(ctx context.Context, arg AddRecordingParams) (Recording, error) {
+	row := q.db.QueryRowContext(ctx, addRecording, arg.Path, arg.StartedAt)
+	var i Recording
+	err := row.Scan(
+		&i.ID,
+		&i.Path,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.FrameCount,
+		&i.SizeBytes,
+	)
+	return i, err
+}
+
+const addRecoveryCode = `-- name: AddRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES (?, ?)
+`
+
+type AddRecoveryCodeParams struct {
+	UserID   int64
+	CodeHash string
+}
+
+func (q *Queries) AddRecoveryCode(ctx context.Context, arg AddRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, addRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
+const addSession = `-- name: AddSession :one
+
+INSERT INTO sessions (token_hash, user_id, data, ip, user_agent, created_at, last_seen, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, token_hash, user_id, data, ip, user_agent, created_at, last_seen, expires_at
+`
+
+type AddSessionParams struct {
+	TokenHash string
+	UserID    sql.NullInt64
+	Data      []byte
+	Ip        string
+	UserAgent string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+// === SESSIONS ===
+func (q *Queries) AddSession(ctx context.Context, arg AddSessionParams) (Session, error) {
+	row := q.db.QueryRowContext(ctx, addSession, arg.TokenHash, arg.UserID, arg.Data, arg.Ip, arg.UserAgent, arg.CreatedAt, arg.LastSeen, arg.ExpiresAt)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.TokenHash,
+		&i.UserID,
+		&i.Data,
+		&i.Ip,
+		&i.UserAgent,
+		&i.CreatedAt,
+		&i.LastSeen,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const addShareLink = `-- name: AddShareLink :one
+INSERT INTO share_links (name, created_by, created_at, expires_at, max_viewers, allow_light)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING id, name, created_by, created_at, expires_at, max_viewers, allow_light
+`
+
+type AddShareLinkParams struct {
+	Name       string
+	CreatedBy  int64
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	MaxViewers int64
+	AllowLight bool
+}
+
+func (q *Queries) AddShareLink(ctx context.Context, arg AddShareLinkParams) (ShareLink, error) {
+	row := q.db.QueryRowContext(ctx, addShareLink, arg.Name, arg.CreatedBy, arg.CreatedAt, arg.ExpiresAt, arg.MaxViewers, arg.AllowLight)
+	var i ShareLink
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.MaxViewers,
+		&i.AllowLight,
+	)
+	return i, err
+}
+
+const addTimelapse = `-- name: AddTimelapse :one
+
+INSERT INTO timelapses (day, path, frame_count, size_bytes, created_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (day) DO UPDATE
+SET path = excluded.path, frame_count = excluded.frame_count, size_bytes = excluded.size_bytes, created_at = excluded.created_at
+RETURNING id, day, path, frame_count, size_bytes, created_at
+`
+
+type AddTimelapseParams struct {
+	Day        string
+	Path       string
+	FrameCount int64
+	SizeBytes  int64
+	CreatedAt  time.Time
+}
+
+// === TIMELAPSES ===
+func (q *Queries) AddTimelapse(ctx context.Context, arg AddTimelapseParams) (Timelapse, error) {
+	row := q.db.QueryRowContext(ctx, addTimelapse, arg.Day, arg.Path, arg.FrameCount, arg.SizeBytes, arg.CreatedAt)
+	var i Timelapse
+	err := row.Scan(
+		&i.ID,
+		&i.Day,
+		&i.Path,
+		&i.FrameCount,
+		&i.SizeBytes,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const addUser = `-- name: AddUser :one
 
-INSERT INTO users (username, password_hash) 
-VALUES (?, ?)
-RETURNING id, username, password_hash, created_at, last_login
+INSERT INTO users (username, password_hash, role) 
+VALUES (?, ?, ?)
+RETURNING id, username, password_hash, created_at, last_login, role, must_change_password
+`
+
+type AddUserParams struct {
+	Username     string
+	PasswordHash string
+	Role         string
+}
+
+// === CONTACTS ===
+func (q *Queries) AddUser(ctx context.Context, arg AddUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, addUser, arg.Username, arg.PasswordHash, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.CreatedAt,
+		&i.LastLogin,
+		&i.Role,
+		&i.MustChangePassword,
+	)
+	return i, err
+}
+
+const clearLockout = `-- name: ClearLockout :exec
+DELETE FROM lockouts
+WHERE kind = ? AND subject = ?
+`
+
+type ClearLockoutParams struct {
+	Kind    string
+	Subject string
+}
+
+func (q *Queries) ClearLockout(ctx context.Context, arg ClearLockoutParams) error {
+	_, err := q.db.ExecContext(ctx, clearLockout, arg.Kind, arg.Subject)
+	return err
+}
+
+const countRecoveryCodes = `-- name: CountRecoveryCodes :one
+SELECT COUNT(*)
+FROM recovery_codes
+WHERE user_id = ?
+`
+
+func (q *Queries) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*)
+FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*)
+FROM users
+WHERE role = ?
+`
+
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteApiTokenForUser = `-- name: DeleteApiTokenForUser :execrows
+DELETE FROM api_tokens
+WHERE id = ? AND user_id = ?
+`
+
+type DeleteApiTokenForUserParams struct {
+	ID     int64
+	UserID int64
+}
+
+func (q *Queries) DeleteApiTokenForUser(ctx context.Context, arg DeleteApiTokenForUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteApiTokenForUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteApiTokensForUser = `-- name: DeleteApiTokensForUser :exec
+DELETE FROM api_tokens
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteApiTokensForUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteApiTokensForUser, userID)
+	return err
+}
+
+const deleteAuditEntriesBefore = `-- name: DeleteAuditEntriesBefore :exec
+DELETE FROM audit_log
+WHERE created_at < ?
+`
+
+func (q *Queries) DeleteAuditEntriesBefore(ctx context.Context, createdAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteAuditEntriesBefore, createdAt)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions
+WHERE expires_at <= ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredSessions, expiresAt)
+	return err
+}
+
+const deleteLockout = `-- name: DeleteLockout :execrows
+DELETE FROM lockouts
+WHERE id = ?
+`
+
+func (q *Queries) DeleteLockout(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteLockout, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteLockoutsLastFailedBefore = `-- name: DeleteLockoutsLastFailedBefore :exec
+DELETE FROM lockouts
+WHERE last_failure < ?
+`
+
+func (q *Queries) DeleteLockoutsLastFailedBefore(ctx context.Context, lastFailure time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteLockoutsLastFailedBefore, lastFailure)
+	return err
+}
+
+const deleteRecording = `-- name: DeleteRecording :one
+DELETE FROM recordings
+WHERE id = ?
+RETURNING id, path, started_at, ended_at, frame_count, size_bytes
+`
+
+func (q *Queries) DeleteRecording(ctx context.Context, id int64) (Recording, error) {
+	row := q.db.QueryRowContext(ctx, deleteRecording, id)
+	var i Recording
+	err := row.Scan(
+		&i.ID,
+		&i.Path,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.FrameCount,
+		&i.SizeBytes,
+	)
+	return i, err
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM sessions
+WHERE id = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, id)
+	return err
+}
+
+const deleteSessionForUser = `-- name: DeleteSessionForUser :execrows
+DELETE FROM sessions
+WHERE id = ? AND user_id = ?
+`
+
+type DeleteSessionForUserParams struct {
+	ID     int64
+	UserID sql.NullInt64
+}
+
+func (q *Queries) DeleteSessionForUser(ctx context.Context, arg DeleteSessionForUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteSessionForUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteSessionsForUser = `-- name: DeleteSessionsForUser :exec
+DELETE FROM sessions
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteSessionsForUser(ctx context.Context, userID sql.NullInt64) error {
+	_, err := q.db.ExecContext(ctx, deleteSessionsForUser, userID)
+	return err
+}
+
+const deleteShareLink = `-- name: DeleteShareLink :execrows
+DELETE FROM share_links
+WHERE id = ?
+`
+
+func (q *Queries) DeleteShareLink(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteShareLink, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteShareLinksExpiredBefore = `-- name: DeleteShareLinksExpiredBefore :exec
+DELETE FROM share_links
+WHERE expires_at < ?
+`
+
+func (q *Queries) DeleteShareLinksExpiredBefore(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteShareLinksExpiredBefore, expiresAt)
+	return err
+}
+
+const deleteTotp = `-- name: DeleteTotp :exec
+DELETE FROM totp
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteTotp(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteTotp, userID)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :one
+DELETE FROM users
+WHERE id = ?
+RETURNING id, username, password_hash, created_at, last_login, role, must_change_password
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, deleteUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.CreatedAt,
+		&i.LastLogin,
+		&i.Role,
+		&i.MustChangePassword,
+	)
+	return i, err
+}
+
+const finishMotionEvent = `-- name: FinishMotionEvent :exec
+UPDATE motion_events
+SET ended_at = ?, peak_score = ?, frame_count = ?, size_bytes = ?, thumbnail = ?
+WHERE id = ?
+`
+
+type FinishMotionEventParams struct {
+	EndedAt    sql.NullTime
+	PeakScore  float64
+	FrameCount int64
+	SizeBytes  int64
+	Thumbnail  []byte
+	ID         int64
+}
+
+func (q *Queries) FinishMotionEvent(ctx context.Context, arg FinishMotionEventParams) error {
+	_, err := q.db.ExecContext(ctx, finishMotionEvent, arg.EndedAt, arg.PeakScore, arg.FrameCount, arg.SizeBytes, arg.Thumbnail, arg.ID)
+	return err
+}
+
+const finishRecording = `-- name: FinishRecording :exec
+UPDATE recordings
+SET ended_at = ?, frame_count = ?, size_bytes = ?
+WHERE id = ?
+`
+
+type FinishRecordingParams struct {
+	EndedAt    sql.NullTime
+	FrameCount int64
+	SizeBytes  int64
+	ID         int64
+}
+
+func (q *Queries) FinishRecording(ctx context.Context, arg FinishRecordingParams) error {
+	_, err := q.db.ExecContext(ctx, finishRecording, arg.EndedAt, arg.FrameCount, arg.SizeBytes, arg.ID)
+	return err
+}
+
+const getApiTokenByTokenHash = `-- name: GetApiTokenByTokenHash :one
+SELECT id, user_id, name, token_hash, scope, created_at, last_used, expires_at
+FROM api_tokens
+WHERE token_hash = ?
+`
+
+func (q *Queries) GetApiTokenByTokenHash(ctx context.Context, tokenHash string) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, getApiTokenByTokenHash, tokenHash)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Scope,
+		&i.CreatedAt,
+		&i.LastUsed,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getApiTokensForUser = `-- name: GetApiTokensForUser :many
+SELECT id, user_id, name, token_hash, scope, created_at, last_used, expires_at
+FROM api_tokens
+WHERE user_id = ?
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetApiTokensForUser(ctx context.Context, userID int64) ([]ApiToken, error) {
+	rows, err := q.db.QueryContext(ctx, getApiTokensForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiToken
+	for rows.Next() {
+		var i ApiToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			&i.Scope,
+			&i.CreatedAt,
+			&i.LastUsed,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuditEntries = `-- name: GetAuditEntries :many
+SELECT id, created_at, action, user_id, username, ip, detail
+FROM audit_log
+WHERE (?1 = '' OR action = ?1)
+  AND (?2 = '' OR username = ?2)
+  AND created_at >= ?3 AND created_at < ?4
+ORDER BY created_at DESC, id DESC
+LIMIT ?5
+`
+
+type GetAuditEntriesParams struct {
+	Action     string
+	Username   string
+	StartTime  time.Time
+	EndTime    time.Time
+	MaxEntries int64
+}
+
+func (q *Queries) GetAuditEntries(ctx context.Context, arg GetAuditEntriesParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, getAuditEntries, arg.Action, arg.Username, arg.StartTime, arg.EndTime, arg.MaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Action,
+			&i.UserID,
+			&i.Username,
+			&i.Ip,
+			&i.Detail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuditUsernames = `-- name: GetAuditUsernames :many
+SELECT DISTINCT username
+FROM audit_log
+WHERE username != ''
+ORDER BY username
+`
+
+func (q *Queries) GetAuditUsernames(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getAuditUsernames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		items = append(items, username)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLockout = `-- name: GetLockout :one
+
+SELECT id, kind, subject, failures, last_failure, locked_until
+FROM lockouts
+WHERE kind = ? AND subject = ?
+`
+
+type GetLockoutParams struct {
+	Kind    string
+	Subject string
+}
+
+// === LOCKOUTS ===
+func (q *Queries) GetLockout(ctx context.Context, arg GetLockoutParams) (Lockout, error) {
+	row := q.db.QueryRowContext(ctx, getLockout, arg.Kind, arg.Subject)
+	var i Lockout
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Subject,
+		&i.Failures,
+		&i.LastFailure,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const getLockouts = `-- name: GetLockouts :many
+SELECT id, kind, subject, failures, last_failure, locked_until
+FROM lockouts
+ORDER BY last_failure DESC
+`
+
+func (q *Queries) GetLockouts(ctx context.Context) ([]Lockout, error) {
+	rows, err := q.db.QueryContext(ctx, getLockouts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Lockout
+	for rows.Next() {
+		var i Lockout
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Subject,
+			&i.Failures,
+			&i.LastFailure,
+			&i.LockedUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMotionEventById = `-- name: GetMotionEventById :one
+SELECT id, started_at, ended_at, peak_score, clip_path, clip_started_at, frame_count, size_bytes, thumbnail
+FROM motion_events
+WHERE id = ?
+`
+
+func (q *Queries) GetMotionEventById(ctx context.Context, id int64) (MotionEvent, error) {
+	row := q.db.QueryRowContext(ctx, getMotionEventById, id)
+	var i MotionEvent
+	err := row.Scan(
+		&i.ID,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.PeakScore,
+		&i.ClipPath,
+		&i.ClipStartedAt,
+		&i.FrameCount,
+		&i.SizeBytes,
+		&i.Thumbnail,
+	)
+	return i, err
+}
+
+const getMotionEvents = `-- name: GetMotionEvents :many
+SELECT id, started_at, ended_at, peak_score, clip_path, clip_started_at, frame_count, size_bytes, thumbnail
+FROM motion_events
+ORDER BY started_at DESC
+`
+
+func (q *Queries) GetMotionEvents(ctx context.Context) ([]MotionEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getMotionEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MotionEvent
+	for rows.Next() {
+		var i MotionEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.PeakScore,
+			&i.ClipPath,
+			&i.ClipStartedAt,
+			&i.FrameCount,
+			&i.SizeBytes,
+			&i.Thumbnail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMotionEventsBetween = `-- name: GetMotionEventsBetween :many
+SELECT id, started_at, ended_at, peak_score, clip_path, clip_started_at, frame_count, size_bytes, thumbnail
+FROM motion_events
+WHERE started_at >= ? AND started_at < ?
+ORDER BY started_at DESC
+`
+
+type GetMotionEventsBetweenParams struct {
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (q *Queries) GetMotionEventsBetween(ctx context.Context, arg GetMotionEventsBetweenParams) ([]MotionEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getMotionEventsBetween, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MotionEvent
+	for rows.Next() {
+		var i MotionEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.PeakScore,
+			&i.ClipPath,
+			&i.ClipStartedAt,
+			&i.FrameCount,
+			&i.SizeBytes,
+			&i.Thumbnail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordings = `-- name: GetRecordings :many
+SELECT id, path, started_at, ended_at, frame_count, size_bytes
+FROM recordings
+ORDER BY started_at DESC
+`
+
+func (q *Queries) GetRecordings(ctx context.Context) ([]Recording, error) {
+	rows, err := q.db.QueryContext(ctx, getRecordings)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Recording
+	for rows.Next() {
+		var i Recording
+		if err := rows.Scan(
+			&i.ID,
+			&i.Path,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.FrameCount,
+			&i.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordingsOldestFirst = `-- name: GetRecordingsOldestFirst :many
+SELECT id, path, started_at, ended_at, frame_count, size_bytes
+FROM recordings
+WHERE ended_at IS NOT NULL
+ORDER BY started_at ASC
 `
 
-type AddUserParams struct {
-	Username     string
-	PasswordHash string
+func (q *Queries) GetRecordingsOldestFirst(ctx context.Context) ([]Recording, error) {
+	rows, err := q.db.QueryContext(ctx, getRecordingsOldestFirst)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Recording
+	for rows.Next() {
+		var i Recording
+		if err := rows.Scan(
+			&i.ID,
+			&i.Path,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.FrameCount,
+			&i.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-// === CONTACTS ===
-func (q *Queries) AddUser(ctx context.Context, arg AddUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, addUser, arg.Username, arg.PasswordHash)
-	var i User
+const getRecordingsStartedBefore = `-- name: GetRecordingsStartedBefore :many
+SELECT id, path, started_at, ended_at, frame_count, size_bytes
+FROM recordings
+WHERE started_at < ? AND ended_at IS NOT NULL
+ORDER BY started_at ASC
+`
+
+func (q *Queries) GetRecordingsStartedBefore(ctx context.Context, startedAt time.Time) ([]Recording, error) {
+	rows, err := q.db.QueryContext(ctx, getRecordingsStartedBefore, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Recording
+	for rows.Next() {
+		var i Recording
+		if err := rows.Scan(
+			&i.ID,
+			&i.Path,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.FrameCount,
+			&i.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecordingsTotalSize = `-- name: GetRecordingsTotalSize :one
+SELECT CAST(COALESCE(SUM(size_bytes), 0) AS INTEGER) AS total_size
+FROM recordings
+`
+
+func (q *Queries) GetRecordingsTotalSize(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getRecordingsTotalSize)
+	var total_size int64
+	err := row.Scan(&total_size)
+	return total_size, err
+}
+
+const getSessionByTokenHash = `-- name: GetSessionByTokenHash :one
+SELECT id, token_hash, user_id, data, ip, user_agent, created_at, last_seen, expires_at
+FROM sessions
+WHERE token_hash = ?
+`
+
+func (q *Queries) GetSessionByTokenHash(ctx context.Context, tokenHash string) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getSessionByTokenHash, tokenHash)
+	var i Session
 	err := row.Scan(
 		&i.ID,
-		&i.Username,
-		&i.PasswordHash,
+		&i.TokenHash,
+		&i.UserID,
+		&i.Data,
+		&i.Ip,
+		&i.UserAgent,
 		&i.CreatedAt,
-		&i.LastLogin,
+		&i.LastSeen,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const countUsers = `-- name: CountUsers :one
-SELECT COUNT(*)
-FROM users
+const getSessionsForUser = `-- name: GetSessionsForUser :many
+SELECT id, token_hash, user_id, data, ip, user_agent, created_at, last_seen, expires_at
+FROM sessions
+WHERE user_id = ? AND expires_at > ?
+ORDER BY last_seen DESC
 `
 
-func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
-	row := q.db.QueryRowContext(ctx, countUsers)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
+type GetSessionsForUserParams struct {
+	UserID    sql.NullInt64
+	ExpiresAt time.Time
 }
 
-const deleteUser = `-- name: DeleteUser :one
-DELETE FROM users
+func (q *Queries) GetSessionsForUser(ctx context.Context, arg GetSessionsForUserParams) ([]Session, error) {
+	rows, err := q.db.QueryContext(ctx, getSessionsForUser, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.TokenHash,
+			&i.UserID,
+			&i.Data,
+			&i.Ip,
+			&i.UserAgent,
+			&i.CreatedAt,
+			&i.LastSeen,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getShareLink = `-- name: GetShareLink :one
+SELECT id, name, created_by, created_at, expires_at, max_viewers, allow_light
+FROM share_links
 WHERE id = ?
-RETURNING id, username, password_hash, created_at, last_login
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id int64) (User, error) {
-	row := q.db.QueryRowContext(ctx, deleteUser, id)
-	var i User
+func (q *Queries) GetShareLink(ctx context.Context, id int64) (ShareLink, error) {
+	row := q.db.QueryRowContext(ctx, getShareLink, id)
+	var i ShareLink
 	err := row.Scan(
 		&i.ID,
-		&i.Username,
-		&i.PasswordHash,
+		&i.Name,
+		&i.CreatedBy,
 		&i.CreatedAt,
-		&i.LastLogin,
+		&i.ExpiresAt,
+		&i.MaxViewers,
+		&i.AllowLight,
+	)
+	return i, err
+}
+
+const getShareLinks = `-- name: GetShareLinks :many
+SELECT id, name, created_by, created_at, expires_at, max_viewers, allow_light
+FROM share_links
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetShareLinks(ctx context.Context) ([]ShareLink, error) {
+	rows, err := q.db.QueryContext(ctx, getShareLinks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ShareLink
+	for rows.Next() {
+		var i ShareLink
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.MaxViewers,
+			&i.AllowLight,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTimelapseById = `-- name: GetTimelapseById :one
+SELECT id, day, path, frame_count, size_bytes, created_at
+FROM timelapses
+WHERE id = ?
+`
+
+func (q *Queries) GetTimelapseById(ctx context.Context, id int64) (Timelapse, error) {
+	row := q.db.QueryRowContext(ctx, getTimelapseById, id)
+	var i Timelapse
+	err := row.Scan(
+		&i.ID,
+		&i.Day,
+		&i.Path,
+		&i.FrameCount,
+		&i.SizeBytes,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getTimelapses = `-- name: GetTimelapses :many
+SELECT id, day, path, frame_count, size_bytes, created_at
+FROM timelapses
+ORDER BY day DESC
+`
+
+func (q *Queries) GetTimelapses(ctx context.Context) ([]Timelapse, error) {
+	rows, err := q.db.QueryContext(ctx, getTimelapses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Timelapse
+	for rows.Next() {
+		var i Timelapse
+		if err := rows.Scan(
+			&i.ID,
+			&i.Day,
+			&i.Path,
+			&i.FrameCount,
+			&i.SizeBytes,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTotp = `-- name: GetTotp :one
+SELECT user_id, secret, last_step, enabled_at
+FROM totp
+WHERE user_id = ?
+`
+
+func (q *Queries) GetTotp(ctx context.Context, userID int64) (Totp, error) {
+	row := q.db.QueryRowContext(ctx, getTotp, userID)
+	var i Totp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.LastStep,
+		&i.EnabledAt,
 	)
 	return i, err
 }
 
 const getUserById = `-- name: GetUserById :one
-SELECT id, username, password_hash, created_at, last_login 
+SELECT id, username, password_hash, created_at, last_login, role, must_change_password 
 FROM users
 WHERE id = ?
 `
@@ -81,12 +1203,14 @@ func (q *Queries) GetUserById(ctx context.Context, id int64) (User, error) {
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastLogin,
+		&i.Role,
+		&i.MustChangePassword,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, username, password_hash, created_at, last_login
+SELECT id, username, password_hash, created_at, last_login, role, must_change_password
 FROM users
 WHERE username = ?
 `
@@ -100,12 +1224,14 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 		&i.PasswordHash,
 		&i.CreatedAt,
 		&i.LastLogin,
+		&i.Role,
+		&i.MustChangePassword,
 	)
 	return i, err
 }
 
 const getUsers = `-- name: GetUsers :many
-SELECT id, username, password_hash, created_at, last_login
+SELECT id, username, password_hash, created_at, last_login, role, must_change_password
 FROM users
 `
 
@@ -124,6 +1250,8 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 			&i.PasswordHash,
 			&i.CreatedAt,
 			&i.LastLogin,
+			&i.Role,
+			&i.MustChangePassword,
 		); err != nil {
 			return nil, err
 		}
@@ -138,6 +1266,75 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const setLockout = `-- name: SetLockout :one
+INSERT INTO lockouts (kind, subject, failures, last_failure, locked_until)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (kind, subject) DO UPDATE
+SET failures = excluded.failures, last_failure = excluded.last_failure, locked_until = excluded.locked_until
+RETURNING id, kind, subject, failures, last_failure, locked_until
+`
+
+type SetLockoutParams struct {
+	Kind        string
+	Subject     string
+	Failures    int64
+	LastFailure time.Time
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) SetLockout(ctx context.Context, arg SetLockoutParams) (Lockout, error) {
+	row := q.db.QueryRowContext(ctx, setLockout, arg.Kind, arg.Subject, arg.Failures, arg.LastFailure, arg.LockedUntil)
+	var i Lockout
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Subject,
+		&i.Failures,
+		&i.LastFailure,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const setTotp = `-- name: SetTotp :exec
+INSERT INTO totp (user_id, secret, last_step, enabled_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = excluded.secret, last_step = excluded.last_step, enabled_at = excluded.enabled_at
+`
+
+type SetTotpParams struct {
+	UserID    int64
+	Secret    string
+	LastStep  int64
+	EnabledAt time.Time
+}
+
+func (q *Queries) SetTotp(ctx context.Context, arg SetTotpParams) error {
+	_, err := q.db.ExecContext(ctx, setTotp, arg.UserID, arg.Secret, arg.LastStep, arg.EnabledAt)
+	return err
+}
+
+const setTotpLastStep = `-- name: SetTotpLastStep :execrows
+UPDATE totp
+SET last_step = ?
+WHERE user_id = ? AND last_step < ?
+`
+
+type SetTotpLastStepParams struct {
+	LastStep   int64
+	UserID     int64
+	LastStep_2 int64
+}
+
+func (q *Queries) SetTotpLastStep(ctx context.Context, arg SetTotpLastStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setTotpLastStep, arg.LastStep, arg.UserID, arg.LastStep_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const setUserLastLogin = `-- name: SetUserLastLogin :exec
 UPDATE users
 SET last_login = datetime()
@@ -148,3 +1345,112 @@ func (q *Queries) SetUserLastLogin(ctx context.Context, id int64) error {
 	_, err := q.db.ExecContext(ctx, setUserLastLogin, id)
 	return err
 }
+
+const setUserPassword = `-- name: SetUserPassword :execrows
+UPDATE users
+SET password_hash = ?, must_change_password = ?
+WHERE id = ?
+`
+
+type SetUserPasswordParams struct {
+	PasswordHash       string
+	MustChangePassword bool
+	ID                 int64
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserPassword, arg.PasswordHash, arg.MustChangePassword, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :exec
+UPDATE users
+SET role = ?
+WHERE id = ?
+`
+
+type SetUserRoleParams struct {
+	Role string
+	ID   int64
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) error {
+	_, err := q.db.ExecContext(ctx, setUserRole, arg.Role, arg.ID)
+	return err
+}
+
+const touchApiToken = `-- name: TouchApiToken :exec
+UPDATE api_tokens
+SET last_used = ?
+WHERE id = ?
+`
+
+type TouchApiTokenParams struct {
+	LastUsed sql.NullTime
+	ID       int64
+}
+
+func (q *Queries) TouchApiToken(ctx context.Context, arg TouchApiTokenParams) error {
+	_, err := q.db.ExecContext(ctx, touchApiToken, arg.LastUsed, arg.ID)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+UPDATE sessions
+SET ip = ?, user_agent = ?, last_seen = ?
+WHERE id = ?
+`
+
+type TouchSessionParams struct {
+	Ip        string
+	UserAgent string
+	LastSeen  time.Time
+	ID        int64
+}
+
+func (q *Queries) TouchSession(ctx context.Context, arg TouchSessionParams) error {
+	_, err := q.db.ExecContext(ctx, touchSession, arg.Ip, arg.UserAgent, arg.LastSeen, arg.ID)
+	return err
+}
+
+const updateSession = `-- name: UpdateSession :exec
+UPDATE sessions
+SET user_id = ?, data = ?, ip = ?, user_agent = ?, last_seen = ?, expires_at = ?
+WHERE id = ?
+`
+
+type UpdateSessionParams struct {
+	UserID    sql.NullInt64
+	Data      []byte
+	Ip        string
+	UserAgent string
+	LastSeen  time.Time
+	ExpiresAt time.Time
+	ID        int64
+}
+
+func (q *Queries) UpdateSession(ctx context.Context, arg UpdateSessionParams) error {
+	_, err := q.db.ExecContext(ctx, updateSession, arg.UserID, arg.Data, arg.Ip, arg.UserAgent, arg.LastSeen, arg.ExpiresAt, arg.ID)
+	return err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+DELETE FROM recovery_codes
+WHERE user_id = ? AND code_hash = ?
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   int64
+	CodeHash string
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
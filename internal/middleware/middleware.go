@@ -1,29 +1,190 @@
 package middleware
 
 import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/apitokens"
 	"catcam_go/internal/store/users"
 	"context"
+	"crypto/subtle"
 	"log"
 	"net/http"
+	"strings"
 )
 
 type SessionStore interface {
-	ValidateSession(r *http.Request) (int64, error) // Returns userId or error
+	// Returns userId and what the request is limited to (apitokens.ScopeAll unless it used a limited
+	// API token), or error
+	ValidateSession(r *http.Request) (int64, apitokens.Scope, error)
+}
+
+type CSRFTokenSource interface {
+	// Returns the CSRF token for the request's session, or an empty string if it doesn't have one
+	CSRFToken(r *http.Request) string
 }
 
 type Middleware func(http.Handler) http.Handler
 
+type contextKey string
+
+// The logged in user (a db.User) is attached to the request context under this key by Auth
+const userContextKey contextKey = "user"
+
+// The request's scope (an apitokens.Scope) is attached to the request context under this key by Auth
+const scopeContextKey contextKey = "scope"
+
+// The CSRF token for the request's session (a string) is attached to the request context under this
+// key by CSRF
+const csrfContextKey contextKey = "csrfToken"
+
+// The header HTMX sends the CSRF token in, set on every request by the Layout's hx-headers
+const CSRFHeader = "X-CSRF-Token"
+
+// CurrentUser gets the logged in user from a request context that has been through Auth
+func CurrentUser(ctx context.Context) (db.User, bool) {
+	user, ok := ctx.Value(userContextKey).(db.User)
+	return user, ok
+}
+
+// CSRFToken gets the CSRF token to send with requests from a request context that has been through
+// CSRF, or an empty string if there isn't one
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey).(string)
+	return token
+}
+
+// BearerToken gets the API token from the request's Authorization header, if it has one
+func BearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
 // AuthMiddleware factory with dependencies
 func Auth(sessionStore SessionStore, userStore *users.UserStore) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userId, err := sessionStore.ValidateSession(r)
-			if err != nil {
+			// Scripts using API tokens get told off rather than sent to the login page
+			_, isBearer := BearerToken(r)
+			unauthorized := func() {
+				if isBearer {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="CatCam"`)
+					http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+					return
+				}
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			}
+
+			userId, scope, err := sessionStore.ValidateSession(r)
+			if err != nil {
+				unauthorized()
+				return
+			}
+			// Load the user for their role, which also shuts out users who have since been deleted
+			user, err := userStore.GetUserById(r.Context(), userId)
+			if err != nil {
+				unauthorized()
 				return
 			}
 			// Attach user info to context
 			ctx := context.WithValue(r.Context(), "userId", userId)
+			ctx = context.WithValue(ctx, userContextKey, user)
+			ctx = context.WithValue(ctx, scopeContextKey, scope)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireRole only lets through users whose role includes the given one. It must come after Auth
+func RequireRole(role users.Role) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := CurrentUser(r.Context())
+			if !ok || !users.Role(user.Role).Includes(role) {
+				http.Error(w, "You don't have permission to do that", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope only lets through requests whose API token is allowed to do what the route does.
+// Requests from logged in users aren't limited. It must come after Auth
+func RequireScope(scope apitokens.Scope) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestScope, ok := r.Context().Value(scopeContextKey).(apitokens.Scope)
+			if !ok || !requestScope.Allows(scope) {
+				http.Error(w, "This API token can't be used for that", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession only lets through requests from logged in users, turning away API tokens whatever
+// their scope, for routes a leaked token mustn't be able to use to keep itself going. It must come
+// after Auth
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, isBearer := BearerToken(r); isBearer {
+			http.Error(w, "API tokens can't be used for that, log in instead", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePasswordChanged sends users who have to choose a new password to changeURL instead. It must
+// come after Auth
+func RequirePasswordChanged(changeURL string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := CurrentUser(r.Context())
+			if ok && user.MustChangePassword {
+				if _, isBearer := BearerToken(r); isBearer {
+					http.Error(w, "The password for this account has to be changed first", http.StatusForbidden)
+					return
+				}
+				if r.Header.Get("HX-Request") == "true" {
+					w.Header().Set("HX-Redirect", changeURL)
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				http.Redirect(w, r, changeURL, http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRF makes requests that change things (anything but GET, HEAD and OPTIONS) prove they came from
+// one of our own pages, by sending the session's CSRF token in the CSRFHeader header. Mismatched
+// requests are handed to rejected instead. Requests using API tokens are let through, as browsers
+// never add those by themselves. The token is also attached to the request context so pages can
+// include it. It must come after Auth
+func CSRF(tokens CSRFTokenSource, rejected http.Handler) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, isBearer := BearerToken(r); isBearer {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			expected := tokens.CSRFToken(r)
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				given := r.Header.Get(CSRFHeader)
+				if expected == "" || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+					log.Printf("Rejected %s %s: missing or mismatched CSRF token", r.Method, r.URL.Path)
+					rejected.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), csrfContextKey, expected)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
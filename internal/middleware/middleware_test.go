@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/users"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+const testCSRFToken = "the-csrf-token"
+
+// Sessions are a "session" cookie holding the user's ID, and API tokens are "<user ID>:<scope>"
+type fakeSessions struct{}
+
+func (fakeSessions) ValidateSession(r *http.Request) (int64, apitokens.Scope, error) {
+	if token, ok := BearerToken(r); ok {
+		userId, scope, ok := strings.Cut(token, ":")
+		if !ok {
+			return 0, "", errors.New("invalid API token")
+		}
+		id, err := strconv.ParseInt(userId, 10, 64)
+		return id, apitokens.Scope(scope), err
+	}
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return 0, "", err
+	}
+	userId, err := strconv.ParseInt(cookie.Value, 10, 64)
+	return userId, apitokens.ScopeAll, err
+}
+
+func (fakeSessions) CSRFToken(r *http.Request) string {
+	if _, err := r.Cookie("session"); err != nil {
+		return ""
+	}
+	return testCSRFToken
+}
+
+func newTestUserStore(t *testing.T) *users.UserStore {
+	t.Helper()
+	dbPool, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { dbPool.Close() })
+	logger := log.New(io.Discard, "", 0)
+	if err := db.Migrate(context.Background(), dbPool, logger); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return users.NewUserStore(db.New(dbPool), logger)
+}
+
+func addTestUser(t *testing.T, userStore *users.UserStore, username string, role users.Role, mustChangePassword bool) int64 {
+	t.Helper()
+	user, err := userStore.AddUser(context.Background(), db.AddUserParams{Username: username, PasswordHash: "hash", Role: string(role)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mustChangePassword {
+		if err := userStore.SetUserPassword(context.Background(), user.ID, "hash", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user.ID
+}
+
+func TestAuthChain(t *testing.T) {
+	userStore := newTestUserStore(t)
+	viewer := addTestUser(t, userStore, "viewer", users.RoleViewer, false)
+	operator := addTestUser(t, userStore, "operator", users.RoleOperator, false)
+	reset := addTestUser(t, userStore, "reset", users.RoleOperator, true)
+
+	// Put together like the server's routes
+	rejected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "CSRF", http.StatusForbidden)
+	})
+	route := func(scope apitokens.Scope, role users.Role, extra ...Middleware) http.Handler {
+		chain := Chain(append([]Middleware{Auth(fakeSessions{}, userStore), CSRF(fakeSessions{}, rejected), RequireScope(scope), RequirePasswordChanged("/account/password"), RequireRole(role)}, extra...)...)
+		return chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := CurrentUser(r.Context())
+			io.WriteString(w, user.Username)
+		}))
+	}
+	session := func(userId int64) string { return strconv.FormatInt(userId, 10) }
+	bearer := func(userId int64, scope apitokens.Scope) string {
+		return "Bearer " + strconv.FormatInt(userId, 10) + ":" + string(scope)
+	}
+
+	tests := []struct {
+		name          string
+		route         http.Handler
+		method        string
+		session       string // The session cookie, if any
+		authorization string
+		csrf          string
+		htmx          bool
+		wantStatus    int
+		wantBody      string // What the response body starts with, if it matters
+		wantHeader    string // A header the response must have, as "Name: value"
+	}{
+		{name: "logged in", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, session: session(viewer), wantStatus: http.StatusOK, wantBody: "viewer"},
+		{name: "not logged in", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, wantStatus: http.StatusSeeOther, wantHeader: "Location: /login"},
+		{name: "deleted user", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, session: "999", wantStatus: http.StatusSeeOther, wantHeader: "Location: /login"},
+		{name: "invalid API token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, authorization: "Bearer nonsense", wantStatus: http.StatusUnauthorized, wantHeader: `WWW-Authenticate: Bearer realm="CatCam"`},
+
+		{name: "change with CSRF token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodPost, session: session(viewer), csrf: testCSRFToken, wantStatus: http.StatusOK, wantBody: "viewer"},
+		{name: "change without CSRF token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodPost, session: session(viewer), wantStatus: http.StatusForbidden, wantBody: "CSRF"},
+		{name: "change with wrong CSRF token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodDelete, session: session(viewer), csrf: "not-the-csrf-token", wantStatus: http.StatusForbidden, wantBody: "CSRF"},
+		{name: "API token needs no CSRF token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodPost, authorization: bearer(viewer, apitokens.ScopeAll), wantStatus: http.StatusOK, wantBody: "viewer"},
+
+		{name: "API token in scope", route: route(apitokens.ScopeLight, users.RoleOperator), method: http.MethodPost, authorization: bearer(operator, apitokens.ScopeLight), wantStatus: http.StatusOK, wantBody: "operator"},
+		{name: "API token for everything", route: route(apitokens.ScopeLight, users.RoleOperator), method: http.MethodPost, authorization: bearer(operator, apitokens.ScopeAll), wantStatus: http.StatusOK, wantBody: "operator"},
+		{name: "API token out of scope", route: route(apitokens.ScopeLight, users.RoleOperator), method: http.MethodPost, authorization: bearer(operator, apitokens.ScopeFeed), wantStatus: http.StatusForbidden},
+		{name: "limited API token on a general route", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, authorization: bearer(viewer, apitokens.ScopeFeed), wantStatus: http.StatusForbidden},
+
+		{name: "role high enough", route: route(apitokens.ScopeAll, users.RoleOperator), method: http.MethodPost, session: session(operator), csrf: testCSRFToken, wantStatus: http.StatusOK, wantBody: "operator"},
+		{name: "role too low", route: route(apitokens.ScopeAll, users.RoleOperator), method: http.MethodPost, session: session(viewer), csrf: testCSRFToken, wantStatus: http.StatusForbidden},
+		{name: "role too low with API token", route: route(apitokens.ScopeLight, users.RoleOperator), method: http.MethodPost, authorization: bearer(viewer, apitokens.ScopeLight), wantStatus: http.StatusForbidden},
+
+		{name: "password change pending", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, session: session(reset), wantStatus: http.StatusSeeOther, wantHeader: "Location: /account/password"},
+		{name: "password change pending with HTMX", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodPost, session: session(reset), csrf: testCSRFToken, htmx: true, wantStatus: http.StatusOK, wantHeader: "HX-Redirect: /account/password"},
+		{name: "password change pending with API token", route: route(apitokens.ScopeAll, users.RoleViewer), method: http.MethodGet, authorization: bearer(reset, apitokens.ScopeAll), wantStatus: http.StatusForbidden},
+
+		{name: "session only route logged in", route: route(apitokens.ScopeAll, users.RoleViewer, RequireSession), method: http.MethodPost, session: session(viewer), csrf: testCSRFToken, wantStatus: http.StatusOK, wantBody: "viewer"},
+		{name: "session only route with API token", route: route(apitokens.ScopeAll, users.RoleViewer, RequireSession), method: http.MethodPost, authorization: bearer(viewer, apitokens.ScopeAll), wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.session != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.session})
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.csrf != "" {
+				r.Header.Set(CSRFHeader, tt.csrf)
+			}
+			if tt.htmx {
+				r.Header.Set("HX-Request", "true")
+			}
+			w := httptest.NewRecorder()
+			tt.route.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if body := w.Body.String(); !strings.HasPrefix(body, tt.wantBody) {
+				t.Errorf("body %q, want it to start with %q", body, tt.wantBody)
+			}
+			if tt.wantHeader != "" {
+				name, value, _ := strings.Cut(tt.wantHeader, ": ")
+				if got := w.Header().Get(name); got != value {
+					t.Errorf("%s header %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package mjpeg
+
+import "fmt"
+
+// ErrCorruptFrame is returned by Splitter.Next when a frame had to be thrown away. It is not fatal:
+// the splitter has already resynchronised and the next call to Next carries on with the stream
+type ErrCorruptFrame struct {
+	Reason    string
+	Discarded int // Number of bytes of the frame that were thrown away
+}
+
+func (e ErrCorruptFrame) Error() string {
+	return fmt.Sprintf("corrupt frame (%d bytes discarded): %s", e.Discarded, e.Reason)
+}
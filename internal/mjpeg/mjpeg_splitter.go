@@ -0,0 +1,205 @@
+// Package mjpeg splits a stream of back-to-back JPEG images (as written by rpicam-vid, ffmpeg's
+// mpjpeg muxer or a multipart HTTP stream) into individual frames
+package mjpeg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// DefaultMaxFrameSize is generous for any frame our cameras produce, while still stopping a stream
+// of garbage from eating all the memory
+const DefaultMaxFrameSize = 8 << 20
+
+// JPEG markers (the byte following an 0xFF)
+const (
+	markerTEM  = 0x01
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+)
+
+// Splitter reads JPEG frames from a stream by walking the marker segments of each image rather than
+// searching for the first end-of-image bytes, so an EOI inside an embedded thumbnail or EXIF data
+// doesn't cut a frame short. Anything between frames (e.g. multipart headers) is skipped
+type Splitter struct {
+	r            *bufio.Reader
+	maxFrameSize int
+	frame        []byte
+	// Set when the previous frame was cut short by the start of this one, so its SOI is already read
+	haveSOI bool
+}
+
+// NewSplitter creates a splitter reading from r which discards any frame larger than maxFrameSize
+// bytes. A maxFrameSize of zero or less means DefaultMaxFrameSize
+func NewSplitter(r io.Reader, maxFrameSize int) *Splitter {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Splitter{
+		r:            bufio.NewReaderSize(r, 64*1024),
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// Next returns the next complete frame in the stream. The frame is a new slice owned by the caller.
+// An ErrCorruptFrame means a frame was discarded and Next can be called again; any other error comes
+// from the underlying reader (io.EOF if the stream ended cleanly between frames, otherwise
+// io.ErrUnexpectedEOF)
+func (s *Splitter) Next() ([]byte, error) {
+	if !s.haveSOI {
+		if err := s.findSOI(); err != nil {
+			return nil, err
+		}
+	}
+	s.haveSOI = false
+	s.frame = append(s.frame[:0], 0xFF, markerSOI)
+
+	marker, err := s.readMarker()
+	for err == nil {
+		switch {
+		case marker == markerEOI:
+			s.frame = append(s.frame, 0xFF, marker)
+			frame := make([]byte, len(s.frame))
+			copy(frame, s.frame)
+			return frame, nil
+
+		case marker == markerSOI:
+			// The camera started a new image before finishing the last one
+			s.haveSOI = true
+			return nil, s.corrupt("start of image before end of previous image")
+
+		case marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7):
+			// Standalone markers without a length
+			s.frame = append(s.frame, 0xFF, marker)
+			marker, err = s.readMarker()
+
+		default:
+			if err = s.readSegment(marker); err != nil {
+				break
+			}
+			if marker == markerSOS {
+				marker, err = s.readEntropyCodedData()
+			} else {
+				marker, err = s.readMarker()
+			}
+		}
+	}
+
+	if _, ok := err.(ErrCorruptFrame); ok {
+		return nil, err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// Skip ahead to the next start of image marker
+func (s *Splitter) findSOI() error {
+	for {
+		if _, err := s.r.ReadSlice(0xFF); err != nil {
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			return err
+		}
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == markerSOI {
+			return nil
+		}
+		if b == 0xFF {
+			s.r.UnreadByte()
+		}
+	}
+}
+
+// Read the next marker, which must come straight after the previous segment (apart from fill bytes)
+func (s *Splitter) readMarker() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		s.r.UnreadByte()
+		return 0, s.corrupt(fmt.Sprintf("expected a marker but found 0x%02X", b))
+	}
+	for b == 0xFF {
+		if b, err = s.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	if b == 0x00 {
+		return 0, s.corrupt("stuffed byte outside of entropy-coded data")
+	}
+	return b, nil
+}
+
+// Read a marker segment with a length, whose marker has already been read
+func (s *Splitter) readSegment(marker byte) error {
+	var lengthBytes [2]byte
+	if _, err := io.ReadFull(s.r, lengthBytes[:]); err != nil {
+		return err
+	}
+	length := int(lengthBytes[0])<<8 | int(lengthBytes[1])
+	if length < 2 {
+		return s.corrupt(fmt.Sprintf("segment 0x%02X has invalid length %d", marker, length))
+	}
+	if len(s.frame)+2+length > s.maxFrameSize {
+		return s.corrupt(fmt.Sprintf("frame exceeds %d bytes", s.maxFrameSize))
+	}
+
+	s.frame = append(s.frame, 0xFF, marker, lengthBytes[0], lengthBytes[1])
+	start := len(s.frame)
+	s.frame = slices.Grow(s.frame, length-2)[:start+length-2]
+	_, err := io.ReadFull(s.r, s.frame[start:])
+	return err
+}
+
+// Read the compressed image data following a start of scan segment up to (and returning) the next
+// marker. Inside the data an 0xFF is either stuffed (followed by 0x00) or a restart marker
+func (s *Splitter) readEntropyCodedData() (byte, error) {
+	for {
+		chunk, err := s.r.ReadSlice(0xFF)
+		if len(s.frame)+len(chunk)+1 > s.maxFrameSize {
+			return 0, s.corrupt(fmt.Sprintf("frame exceeds %d bytes", s.maxFrameSize))
+		}
+		if err == bufio.ErrBufferFull {
+			s.frame = append(s.frame, chunk...)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		// Leave the 0xFF off until we know whether it starts the next marker
+		s.frame = append(s.frame, chunk[:len(chunk)-1]...)
+
+		b, err := s.r.ReadByte()
+		for err == nil && b == 0xFF {
+			b, err = s.r.ReadByte()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if b == 0x00 || (b >= markerRST0 && b <= markerRST7) {
+			s.frame = append(s.frame, 0xFF, b)
+			continue
+		}
+		return b, nil
+	}
+}
+
+// Discard the frame in progress and build an error describing why
+func (s *Splitter) corrupt(reason string) error {
+	err := ErrCorruptFrame{Reason: reason, Discarded: len(s.frame)}
+	s.frame = s.frame[:0]
+	return err
+}
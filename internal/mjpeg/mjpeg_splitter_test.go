@@ -0,0 +1,203 @@
+package mjpeg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// A recorded frame from the camera
+func testJPEG(t testing.TB) []byte {
+	t.Helper()
+	frame, err := os.ReadFile("../../test.jpg")
+	if err != nil {
+		t.Fatalf("reading test.jpg: %v", err)
+	}
+	return frame
+}
+
+// A minimal hand-made image whose compressed data has stuffed bytes and restart markers in it, and
+// whose comment contains an EOI
+var tinyJPEG = []byte{
+	0xFF, 0xD8, // SOI
+	0xFF, 0xFE, 0x00, 0x06, 0xFF, 0xD9, 0xFF, 0xD8, // COM holding EOI and SOI
+	0xFF, 0xDA, 0x00, 0x02, // SOS
+	0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD0, 0x56, 0xFF, 0x00, // Entropy-coded data
+	0xFF, 0xD9, // EOI
+}
+
+// Wrap a frame the way ffmpeg's mpjpeg muxer does
+func multipart(frame []byte) []byte {
+	header := fmt.Sprintf("--ffmpeg\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+	return append(append([]byte(header), frame...), "\r\n"...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// Split the whole stream, returning the frames and the ErrCorruptFrames along the way, and the error
+// that ended it
+func splitAll(t testing.TB, stream []byte, maxFrameSize int) ([][]byte, []ErrCorruptFrame, error) {
+	t.Helper()
+	s := NewSplitter(bytes.NewReader(stream), maxFrameSize)
+	var frames [][]byte
+	var corrupt []ErrCorruptFrame
+	// Every call to Next consumes at least a byte, so this is plenty
+	for range len(stream) + 2 {
+		frame, err := s.Next()
+		var corruptErr ErrCorruptFrame
+		switch {
+		case err == nil:
+			frames = append(frames, frame)
+		case errors.As(err, &corruptErr):
+			corrupt = append(corrupt, corruptErr)
+		default:
+			return frames, corrupt, err
+		}
+	}
+	t.Fatalf("splitter didn't reach the end of a %d byte stream", len(stream))
+	return nil, nil, nil
+}
+
+func TestSplitter(t *testing.T) {
+	recorded := testJPEG(t)
+
+	tests := []struct {
+		name         string
+		stream       []byte
+		maxFrameSize int
+		want         [][]byte
+		wantCorrupt  int
+		wantErr      error
+	}{
+		{
+			name:    "recorded frame",
+			stream:  recorded,
+			want:    [][]byte{recorded},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "back to back frames",
+			stream:  concat(tinyJPEG, recorded, tinyJPEG),
+			want:    [][]byte{tinyJPEG, recorded, tinyJPEG},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "garbage before start of image",
+			stream:  concat([]byte("garbage\xFF\x00\xFF\xFF"), tinyJPEG),
+			want:    [][]byte{tinyJPEG},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "multipart boundaries",
+			stream:  concat(multipart(recorded), multipart(tinyJPEG)),
+			want:    [][]byte{recorded, tinyJPEG},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "0xFF inside entropy-coded data",
+			stream:  tinyJPEG,
+			want:    [][]byte{tinyJPEG},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated at end of stream",
+			stream:  concat(tinyJPEG, recorded[:len(recorded)/2]),
+			want:    [][]byte{tinyJPEG},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:        "truncated by the next frame",
+			stream:      concat(recorded[:len(recorded)/2], tinyJPEG),
+			want:        [][]byte{tinyJPEG},
+			wantCorrupt: 1,
+			wantErr:     io.EOF,
+		},
+		{
+			name:         "over MaxFrameSize",
+			stream:       concat(recorded, tinyJPEG, recorded),
+			maxFrameSize: len(tinyJPEG),
+			want:         [][]byte{tinyJPEG},
+			wantCorrupt:  2,
+			wantErr:      io.EOF,
+		},
+		{
+			name:         "exactly MaxFrameSize",
+			stream:       tinyJPEG,
+			maxFrameSize: len(tinyJPEG),
+			want:         [][]byte{tinyJPEG},
+			wantErr:      io.EOF,
+		},
+		{
+			name:    "nothing but garbage",
+			stream:  []byte("no frames here"),
+			wantErr: io.EOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, corrupt, err := splitAll(t, tt.stream, tt.maxFrameSize)
+			if err != tt.wantErr {
+				t.Errorf("ended with error %v, want %v", err, tt.wantErr)
+			}
+			if len(corrupt) != tt.wantCorrupt {
+				t.Errorf("got %d corrupt frames (%v), want %d", len(corrupt), corrupt, tt.wantCorrupt)
+			}
+			if len(frames) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d", len(frames), len(tt.want))
+			}
+			for i := range frames {
+				if !bytes.Equal(frames[i], tt.want[i]) {
+					t.Errorf("frame %d is %d bytes and differs from the %d bytes wanted", i, len(frames[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestSplitterFramesAreCopies(t *testing.T) {
+	s := NewSplitter(bytes.NewReader(concat(tinyJPEG, tinyJPEG)), 0)
+	first, err := s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := bytes.Clone(first)
+	if _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, before) {
+		t.Error("reading the next frame changed the previous one")
+	}
+}
+
+// Seeded with the recorded frame cut down to its headers and the start of its compressed data, as
+// the fuzzer slows to a crawl with inputs the size of a whole frame. TestSplitter covers whole frames
+func FuzzSplitter(f *testing.F) {
+	recorded := testJPEG(f)
+	head := concat(recorded[:4096], []byte{0xFF, markerEOI})
+	f.Add(head)
+	f.Add(multipart(head))
+	f.Add(concat(recorded[:1024], head))
+	f.Add(tinyJPEG)
+
+	const maxFrameSize = 1 << 20
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		frames, _, err := splitAll(t, stream, maxFrameSize)
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("ended with error %v, want io.EOF or io.ErrUnexpectedEOF", err)
+		}
+		for i, frame := range frames {
+			if len(frame) > maxFrameSize {
+				t.Errorf("frame %d is %d bytes, over the %d byte limit", i, len(frame), maxFrameSize)
+			}
+			if !bytes.HasPrefix(frame, []byte{0xFF, markerSOI}) || !bytes.HasSuffix(frame, []byte{0xFF, markerEOI}) {
+				t.Errorf("frame %d doesn't run from SOI to EOI", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,357 @@
+// Package motion detects movement in the camera's frames by comparing downscaled greyscale copies
+// of each frame against a slowly updating background
+package motion
+
+import (
+	"bytes"
+	"catcam_go/internal/states"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Region is a rectangle of the frame to watch for motion, in fractions of the frame's width and
+// height so it doesn't depend on the capture resolution
+type Region struct {
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+type Config struct {
+	// How much a pixel's brightness (0-255) must differ from the background to count as changed
+	Sensitivity int
+	// The fraction (0-1) of watched pixels that must change for a frame to count as motion
+	Threshold float64
+	// How many frames in a row must count as motion before a motion event starts, to ignore flicker
+	StartFrames int
+	// How long without motion before the event stops
+	Cooldown time.Duration
+	// The most frames per second to analyse, since decoding every frame is wasted effort
+	MaxFps int
+	// The width of the greyscale copy frames are compared at
+	AnalysisWidth int
+	// Only look for motion within these regions. Empty means the whole frame
+	Regions []Region
+}
+
+// DefaultConfig returns settings that work reasonably for a room with a cat in it
+func DefaultConfig() Config {
+	return Config{
+		Sensitivity:   25,
+		Threshold:     0.02,
+		StartFrames:   2,
+		Cooldown:      5 * time.Second,
+		MaxFps:        5,
+		AnalysisWidth: 96,
+	}
+}
+
+type EventType int
+
+const (
+	MotionStarted EventType = iota
+	MotionStopped
+)
+
+func (t EventType) String() string {
+	if t == MotionStarted {
+		return "motion started"
+	}
+	return "motion stopped"
+}
+
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	StartedAt time.Time // When the motion this event belongs to started
+	Score     float64   // The fraction of watched pixels that changed in the triggering frame
+	PeakScore float64   // The highest score since the motion started
+	PeakFrame []byte    // The JPEG frame with the highest score since the motion started
+}
+
+// Who the detector starts the camera as
+var cameraClient = states.CameraClient{Name: "motion"}
+
+// Detector turns a sequence of JPEG frames into motion started/stopped events. Frames can be fed in
+// directly with Process (e.g. from files), or Run can take them from the camera
+type Detector struct {
+	config Config
+	logger *log.Logger
+
+	background []float64 // Running average brightness of each analysed pixel
+	mask       []bool    // Whether each analysed pixel is inside a watched region
+	width      int
+	height     int
+
+	active       bool
+	overCount    int
+	startedAt    time.Time
+	lastMotionAt time.Time
+	peakScore    float64
+	peakFrame    []byte
+	lastAnalysed time.Time
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewDetector(config Config, logger *log.Logger) *Detector {
+	if config.AnalysisWidth <= 0 {
+		config.AnalysisWidth = DefaultConfig().AnalysisWidth
+	}
+	if config.StartFrames <= 0 {
+		config.StartFrames = 1
+	}
+	return &Detector{
+		config:      config,
+		logger:      logger,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving every event the detector emits from Run
+func (d *Detector) Subscribe() chan Event {
+	ch := make(chan Event, 8)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (d *Detector) Unsubscribe(ch chan Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.subscribers, ch)
+}
+
+func (d *Detector) publish(event Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.subscribers {
+		select {
+		case ch <- event:
+		default:
+			d.logger.Println("Motion event dropped: subscriber channel full")
+		}
+	}
+}
+
+// Run analyses the camera's frames until the context is cancelled, publishing events to subscribers.
+// Like the recorder, it keeps the camera running while it does
+func (d *Detector) Run(ctx context.Context, camera *states.Camera) {
+	frames := camera.Subscribe()
+	defer camera.Unsubscribe(frames)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	if err := camera.Start(cameraClient); err != nil {
+		d.logger.Printf("Couldn't start camera for motion detection: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := camera.Start(cameraClient); err != nil {
+				d.logger.Printf("Couldn't start camera for motion detection: %v", err)
+			}
+			// Let motion stop even if the camera has stopped sending frames
+			if event := d.checkCooldown(time.Now()); event != nil {
+				d.logger.Printf("Motion stopped (peak score %.3f)", event.PeakScore)
+				d.publish(*event)
+			}
+
+		case frame := <-frames:
+			now := time.Now()
+			if d.config.MaxFps > 0 && now.Sub(d.lastAnalysed) < time.Second/time.Duration(d.config.MaxFps) {
+				continue
+			}
+			d.lastAnalysed = now
+
+			_, event, err := d.Process(frame, now)
+			if err != nil {
+				d.logger.Printf("Error when analysing frame for motion: %v", err)
+				continue
+			}
+			if event != nil {
+				if event.Type == MotionStarted {
+					d.logger.Printf("Motion started (score %.3f)", event.Score)
+				} else {
+					d.logger.Printf("Motion stopped (peak score %.3f)", event.PeakScore)
+				}
+				d.publish(*event)
+			}
+		}
+	}
+}
+
+// Process analyses a single frame captured at time t, returning its motion score and the event it
+// triggered (if any). The first frame, and any frame after the resolution changes, only sets the
+// background
+func (d *Detector) Process(frame []byte, t time.Time) (float64, *Event, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	bounds := img.Bounds()
+	width := d.config.AnalysisWidth
+	height := max(width*bounds.Dy()/max(bounds.Dx(), 1), 1)
+	grey := downscaleGrey(img, width, height)
+
+	if d.background == nil || width != d.width || height != d.height {
+		d.width, d.height = width, height
+		d.background = grey
+		d.mask = buildMask(d.config.Regions, width, height)
+		return 0, nil, nil
+	}
+
+	score := d.compare(grey)
+	return score, d.update(score, frame, t), nil
+}
+
+// Work out the fraction of watched pixels that differ from the background, then blend the frame into
+// the background so gradual lighting changes aren't mistaken for motion
+func (d *Detector) compare(grey []float64) float64 {
+	const backgroundWeight = 0.8
+
+	changed, watched := 0, 0
+	for i, v := range grey {
+		if d.mask[i] {
+			watched++
+			if math.Abs(v-d.background[i]) > float64(d.config.Sensitivity) {
+				changed++
+			}
+		}
+		d.background[i] = d.background[i]*backgroundWeight + v*(1-backgroundWeight)
+	}
+
+	if watched <= 0 {
+		return 0
+	}
+	return float64(changed) / float64(watched)
+}
+
+// Advance the started/stopped state machine with the latest score
+func (d *Detector) update(score float64, frame []byte, t time.Time) *Event {
+	if score < d.config.Threshold {
+		d.overCount = 0
+		return d.checkCooldown(t)
+	}
+
+	d.overCount++
+	if d.active {
+		d.lastMotionAt = t
+		if score > d.peakScore {
+			d.peakScore = score
+			d.peakFrame = frame
+		}
+		return nil
+	}
+
+	if d.overCount < d.config.StartFrames {
+		return nil
+	}
+
+	d.active = true
+	d.startedAt = t
+	d.lastMotionAt = t
+	d.peakScore = score
+	d.peakFrame = frame
+	return &Event{
+		Type:      MotionStarted,
+		Time:      t,
+		StartedAt: t,
+		Score:     score,
+		PeakScore: score,
+		PeakFrame: frame,
+	}
+}
+
+// Stop the current motion if there hasn't been any for the cooldown period
+func (d *Detector) checkCooldown(t time.Time) *Event {
+	if !d.active || t.Sub(d.lastMotionAt) < d.config.Cooldown {
+		return nil
+	}
+
+	d.active = false
+	event := &Event{
+		Type:      MotionStopped,
+		Time:      t,
+		StartedAt: d.startedAt,
+		PeakScore: d.peakScore,
+		PeakFrame: d.peakFrame,
+	}
+	d.peakFrame = nil
+	return event
+}
+
+// Shrink the image to width x height greyscale pixels by averaging the brightness of each block
+func downscaleGrey(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	sums := make([]float64, width*height)
+	counts := make([]int, width*height)
+
+	// Use the luma plane directly when we can, since it's by far the common case for JPEGs
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	grey, isGrey := img.(*image.Gray)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * height / bounds.Dy() * width
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var v uint8
+			switch {
+			case isYCbCr:
+				v = ycbcr.Y[ycbcr.YOffset(x, y)]
+			case isGrey:
+				v = grey.Pix[grey.PixOffset(x, y)]
+			default:
+				v = color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			}
+			i := row + (x-bounds.Min.X)*width/bounds.Dx()
+			sums[i] += float64(v)
+			counts[i]++
+		}
+	}
+
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// Work out which analysed pixels fall inside the regions of interest
+func buildMask(regions []Region, width, height int) []bool {
+	mask := make([]bool, width*height)
+	if len(regions) <= 0 {
+		for i := range mask {
+			mask[i] = true
+		}
+		return mask
+	}
+
+	for _, region := range regions {
+		x0 := int(region.X * float64(width))
+		y0 := int(region.Y * float64(height))
+		x1 := int(math.Ceil((region.X + region.Width) * float64(width)))
+		y1 := int(math.Ceil((region.Y + region.Height) * float64(height)))
+		for y := max(y0, 0); y < min(y1, height); y++ {
+			for x := max(x0, 0); x < min(x1, width); x++ {
+				mask[y*width+x] = true
+			}
+		}
+	}
+	return mask
+}
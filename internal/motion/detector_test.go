@@ -0,0 +1,155 @@
+package motion
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// A dark JPEG frame, with a white block covering the given fractions of it if block isn't empty
+func testFrame(t *testing.T, block Region) []byte {
+	t.Helper()
+	const width, height = 64, 48
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			fx, fy := float64(x)/width, float64(y)/height
+			if fx >= block.X && fx < block.X+block.Width && fy >= block.Y && fy < block.Y+block.Height {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 20})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encoding frame: %v", err)
+	}
+	return buf.Bytes()
+}
+
+var (
+	leftBlock  = Region{X: 0, Y: 0, Width: 0.5, Height: 1}
+	rightBlock = Region{X: 0.5, Y: 0, Width: 0.5, Height: 1}
+)
+
+func newTestDetector(config Config) *Detector {
+	config.AnalysisWidth = 16
+	return NewDetector(config, log.New(io.Discard, "", 0))
+}
+
+func process(t *testing.T, d *Detector, frame []byte, at time.Time) (float64, *Event) {
+	t.Helper()
+	score, event, err := d.Process(frame, at)
+	if err != nil {
+		t.Fatalf("processing frame: %v", err)
+	}
+	return score, event
+}
+
+func TestFirstFrameOnlySetsBackground(t *testing.T) {
+	d := newTestDetector(Config{Sensitivity: 25, Threshold: 0.02, StartFrames: 1, Cooldown: time.Second})
+	start := time.Now()
+
+	// However different it is from nothing, the first frame is just the background
+	if score, event := process(t, d, testFrame(t, leftBlock), start); score != 0 || event != nil {
+		t.Fatalf("first frame: score %v, event %v, want 0 and no event", score, event)
+	}
+	if score, event := process(t, d, testFrame(t, leftBlock), start.Add(time.Second)); score != 0 || event != nil {
+		t.Errorf("same frame again: score %v, event %v, want 0 and no event", score, event)
+	}
+	score, event := process(t, d, testFrame(t, rightBlock), start.Add(2*time.Second))
+	if score < 0.9 {
+		t.Errorf("frame with the block moved: score %v, want nearly all of it changed", score)
+	}
+	if event == nil || event.Type != MotionStarted {
+		t.Errorf("frame with the block moved: event %v, want motion started", event)
+	}
+}
+
+func TestStartFramesDebounce(t *testing.T) {
+	// Not so sensitive that the block going away again (while it's still only partly blended into the
+	// background) counts as motion too
+	d := newTestDetector(Config{Sensitivity: 100, Threshold: 0.02, StartFrames: 3, Cooldown: time.Second})
+	start := time.Now()
+	quiet, moving := testFrame(t, Region{}), testFrame(t, leftBlock)
+	process(t, d, quiet, start)
+
+	// A quiet frame in between starts the count again
+	steps := []struct {
+		frame       []byte
+		wantStarted bool
+	}{
+		{moving, false},
+		{moving, false},
+		{quiet, false},
+		{moving, false},
+		{moving, false},
+		{moving, true},
+		{moving, false}, // Already started
+	}
+	for i, step := range steps {
+		_, event := process(t, d, step.frame, start.Add(time.Duration(i+1)*100*time.Millisecond))
+		if started := event != nil && event.Type == MotionStarted; started != step.wantStarted {
+			t.Errorf("frame %d: event %v, want motion started %v", i+1, event, step.wantStarted)
+		}
+	}
+}
+
+func TestRegionsMask(t *testing.T) {
+	d := newTestDetector(Config{Sensitivity: 25, Threshold: 0.02, StartFrames: 1, Cooldown: time.Second, Regions: []Region{leftBlock}})
+	start := time.Now()
+	process(t, d, testFrame(t, Region{}), start)
+
+	// Motion outside the watched region doesn't count
+	if score, event := process(t, d, testFrame(t, rightBlock), start.Add(time.Second)); score != 0 || event != nil {
+		t.Errorf("motion outside the region: score %v, event %v, want 0 and no event", score, event)
+	}
+	// The block has moved into it from the right, so the whole region has changed
+	score, event := process(t, d, testFrame(t, leftBlock), start.Add(2*time.Second))
+	if score < 0.9 {
+		t.Errorf("motion inside the region: score %v, want nearly all of it changed", score)
+	}
+	if event == nil || event.Type != MotionStarted {
+		t.Errorf("motion inside the region: event %v, want motion started", event)
+	}
+}
+
+func TestCooldownStopsMotion(t *testing.T) {
+	const cooldown = 5 * time.Second
+	d := newTestDetector(Config{Sensitivity: 25, Threshold: 0.02, StartFrames: 1, Cooldown: cooldown})
+	start := time.Now()
+	process(t, d, testFrame(t, Region{}), start)
+
+	moving := testFrame(t, leftBlock)
+	_, started := process(t, d, moving, start.Add(time.Second))
+	if started == nil || started.Type != MotionStarted {
+		t.Fatalf("event %v, want motion started", started)
+	}
+	lastMotion := start.Add(2 * time.Second)
+	if _, event := process(t, d, testFrame(t, rightBlock), lastMotion); event != nil {
+		t.Fatalf("more motion: event %v, want none", event)
+	}
+
+	if event := d.checkCooldown(lastMotion.Add(cooldown - time.Millisecond)); event != nil {
+		t.Errorf("before the cooldown: event %v, want none", event)
+	}
+	stopped := d.checkCooldown(lastMotion.Add(cooldown))
+	if stopped == nil || stopped.Type != MotionStopped {
+		t.Fatalf("after the cooldown: event %v, want motion stopped", stopped)
+	}
+	if !stopped.StartedAt.Equal(started.StartedAt) {
+		t.Errorf("stopped event started at %v, want %v", stopped.StartedAt, started.StartedAt)
+	}
+	if stopped.PeakScore < started.Score || stopped.PeakFrame == nil {
+		t.Errorf("stopped event has peak score %v and frame %v, want at least %v and a frame", stopped.PeakScore, stopped.PeakFrame != nil, started.Score)
+	}
+	if event := d.checkCooldown(lastMotion.Add(2 * cooldown)); event != nil {
+		t.Errorf("once stopped: event %v, want none", event)
+	}
+}
@@ -0,0 +1,227 @@
+package recording
+
+import (
+	"bytes"
+	"catcam_go/internal/db"
+	"catcam_go/internal/motion"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/events"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The width of the thumbnail stored with each motion event
+const thumbnailWidth = 320
+
+// How much more than the pre-roll the camera keeps, as motion is only noticed a little while after it
+// starts: the detector has to see it in a few frames, may be behind on analysing them, and then the
+// event has to reach the clipper
+const preRollSlack = 5 * time.Second
+
+// MotionSource tells the Clipper when motion starts and stops, e.g. a motion.Detector
+type MotionSource interface {
+	Subscribe() chan motion.Event
+	Unsubscribe(ch chan motion.Event)
+}
+
+// Clipper saves a clip of every motion event, starting a few seconds before the motion was detected
+// so we see what set it off. The camera keeps those seconds in memory (see Camera.KeepHistory)
+type Clipper struct {
+	camera     *states.Camera
+	detector   MotionSource
+	eventStore *events.EventStore
+	dir        string
+	preRoll    time.Duration
+	maxLength  time.Duration
+	logger     *log.Logger
+
+	segment *segmentFile
+	event   db.MotionEvent
+	trigger motion.Event
+	frames  chan []byte // Live frames while a clip is being written, otherwise nil
+}
+
+func NewClipper(camera *states.Camera, detector MotionSource, eventStore *events.EventStore, dir string, preRoll, maxLength time.Duration, logger *log.Logger) *Clipper {
+	camera.KeepHistory(preRoll + preRollSlack)
+	return &Clipper{
+		camera:     camera,
+		detector:   detector,
+		eventStore: eventStore,
+		dir:        dir,
+		preRoll:    preRoll,
+		maxLength:  maxLength,
+		logger:     logger,
+	}
+}
+
+// Run writes clips for the detector's motion events until the context is cancelled
+func (c *Clipper) Run(ctx context.Context) {
+	motionEvents := c.detector.Subscribe()
+	defer c.detector.Unsubscribe(motionEvents)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.finishClip(context.Background(), time.Now(), nil)
+			return
+
+		case event := <-motionEvents:
+			switch event.Type {
+			case motion.MotionStarted:
+				if c.segment != nil {
+					continue
+				}
+				if err := c.startClip(ctx, event); err != nil {
+					c.logger.Printf("Error when starting motion clip: %v", err)
+				}
+			case motion.MotionStopped:
+				c.finishClip(ctx, event.Time, &event)
+			}
+
+		case frame := <-c.frames:
+			if err := c.segment.writeFrame(frame); err != nil {
+				c.logger.Printf("Error when writing frame to %s: %v", c.event.ClipPath, err)
+				c.finishClip(ctx, time.Now(), nil)
+				continue
+			}
+			// Split up motion that goes on and on (e.g. the light changing) into clips of at most
+			// maxLength, so none of it is missed
+			if time.Since(c.event.ClipStartedAt) >= c.maxLength {
+				c.continueClip(ctx, time.Now(), frame)
+			}
+		}
+	}
+}
+
+func (c *Clipper) startClip(ctx context.Context, event motion.Event) error {
+	frames, preRoll := c.camera.SubscribeWithHistory(event.StartedAt.Add(-c.preRoll))
+	if err := c.openClip(ctx, event, preRoll); err != nil {
+		c.camera.Unsubscribe(frames)
+		return err
+	}
+	c.frames = frames
+	return nil
+}
+
+// Start writing a new clip for the event, beginning with the frames from before it
+func (c *Clipper) openClip(ctx context.Context, event motion.Event, preRoll []states.TimedFrame) error {
+	clipStartedAt := event.StartedAt
+	if len(preRoll) > 0 {
+		clipStartedAt = preRoll[0].Time
+	}
+	path := filepath.Join(c.dir, clipStartedAt.Format("2006-01-02"), clipStartedAt.Format("15-04-05")+".mjpeg")
+
+	segment, err := createSegmentFile(path)
+	if err != nil {
+		return err
+	}
+	for _, frame := range preRoll {
+		if err := segment.writeFrame(frame.Data); err != nil {
+			segment.close()
+			return err
+		}
+	}
+
+	motionEvent, err := c.eventStore.AddEvent(ctx, event.StartedAt, path, clipStartedAt)
+	if err != nil {
+		segment.close()
+		os.Remove(path)
+		return err
+	}
+
+	c.logger.Printf("Writing motion clip to %s", path)
+	c.segment = segment
+	c.event = motionEvent
+	c.trigger = event
+	return nil
+}
+
+// Close off the clip that has reached maxLength and carry on straight away in a new one, as a new
+// event starting now. The frame just written to the old clip stands in for the new one's trigger
+func (c *Clipper) continueClip(ctx context.Context, now time.Time, frame []byte) {
+	c.closeClip(ctx, now, nil)
+
+	event := c.trigger
+	event.Time, event.StartedAt, event.PeakFrame = now, now, frame
+	if err := c.openClip(ctx, event, nil); err != nil {
+		c.logger.Printf("Error when continuing motion clip: %v", err)
+		c.camera.Unsubscribe(c.frames)
+		c.frames = nil
+	}
+}
+
+// Stop writing the clip in progress (if any) and record how it went. The stopped event is nil if the
+// clip is being cut short, in which case the frame that started the event stands in for the peak
+func (c *Clipper) finishClip(ctx context.Context, endedAt time.Time, stopped *motion.Event) {
+	if c.segment == nil {
+		return
+	}
+	c.camera.Unsubscribe(c.frames)
+	c.frames = nil
+	c.closeClip(ctx, endedAt, stopped)
+}
+
+// Close the clip's file and finish its event, without unsubscribing from the camera
+func (c *Clipper) closeClip(ctx context.Context, endedAt time.Time, stopped *motion.Event) {
+	if err := c.segment.close(); err != nil {
+		c.logger.Printf("Error when closing %s: %v", c.event.ClipPath, err)
+	}
+
+	peak := c.trigger
+	if stopped != nil {
+		peak = *stopped
+	}
+	thumbnail, err := makeThumbnail(peak.PeakFrame, thumbnailWidth)
+	if err != nil {
+		c.logger.Printf("Error when making thumbnail for motion event %d: %v", c.event.ID, err)
+	}
+
+	err = c.eventStore.FinishEvent(ctx, c.event.ID, endedAt, peak.PeakScore, c.segment.frameCount, c.segment.sizeBytes, thumbnail)
+	if err != nil {
+		c.logger.Printf("Error when finishing motion event %d: %v", c.event.ID, err)
+	}
+	c.segment = nil
+}
+
+// Shrink a JPEG frame down to the given width, keeping its aspect ratio
+func makeThumbnail(frame []byte, width int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	height := max(width*bounds.Dy()/max(bounds.Dx(), 1), 1)
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Average each block of source pixels into one thumbnail pixel
+	for ty := 0; ty < height; ty++ {
+		y0 := bounds.Min.Y + ty*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(ty+1)*bounds.Dy()/height, y0+1)
+		for tx := 0; tx < width; tx++ {
+			x0 := bounds.Min.X + tx*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(tx+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, n uint32
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					pr, pg, pb, _ := img.At(x, y).RGBA()
+					r, g, b, n = r+pr, g+pg, b+pb, n+1
+				}
+			}
+			thumb.SetRGBA(tx, ty, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package recording
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/motion"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/events"
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// A detector whose events are made up by the test
+type fakeDetector struct {
+	events chan motion.Event
+}
+
+func newFakeDetector() *fakeDetector {
+	return &fakeDetector{events: make(chan motion.Event, 8)}
+}
+
+func (d *fakeDetector) Subscribe() chan motion.Event {
+	return d.events
+}
+
+func (d *fakeDetector) Unsubscribe(ch chan motion.Event) {}
+
+// Start a camera with someone watching, so it keeps running between clips
+func startTestCamera(t *testing.T) *states.Camera {
+	t.Helper()
+	camera := newTestCamera(t)
+	viewer := camera.Subscribe()
+	t.Cleanup(func() { camera.Unsubscribe(viewer) })
+	go func() {
+		for range viewer {
+		}
+	}()
+	if err := camera.Start(states.CameraClient{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	return camera
+}
+
+func testFrame(t *testing.T, camera *states.Camera) []byte {
+	t.Helper()
+	frame, err := camera.Snapshot(states.CameraClient{Name: "test"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func storedEvents(t *testing.T, store *events.EventStore) []db.MotionEvent {
+	t.Helper()
+	motionEvents, err := store.GetEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return motionEvents
+}
+
+func TestClipIncludesPreRoll(t *testing.T) {
+	store := events.NewEventStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	detector := newFakeDetector()
+	camera := startTestCamera(t)
+	preRoll := 500 * time.Millisecond
+	clipper := NewClipper(camera, detector, store, t.TempDir(), preRoll, time.Minute, log.New(io.Discard, "", 0))
+	// Let the camera build up more history than the pre-roll
+	time.Sleep(2 * time.Second)
+
+	// The motion is only noticed a second after it started
+	peak := testFrame(t, camera)
+	startedAt := time.Now().Add(-time.Second)
+	detector.events <- motion.Event{Type: motion.MotionStarted, Time: startedAt, StartedAt: startedAt, Score: 0.1, PeakScore: 0.1, PeakFrame: peak}
+	runFor(t, 500*time.Millisecond, func(ctx context.Context) {
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			detector.events <- motion.Event{Type: motion.MotionStopped, Time: time.Now(), StartedAt: startedAt, PeakScore: 0.3, PeakFrame: peak}
+		}()
+		clipper.Run(ctx)
+	})
+
+	motionEvents := storedEvents(t, store)
+	if len(motionEvents) != 1 {
+		t.Fatalf("got %d motion events, want 1", len(motionEvents))
+	}
+	event := motionEvents[0]
+	if !event.StartedAt.Equal(startedAt) {
+		t.Errorf("event started at %v, want %v", event.StartedAt, startedAt)
+	}
+	if gap := startedAt.Sub(event.ClipStartedAt); gap < preRoll-2*time.Second/testFps || gap > preRoll {
+		t.Errorf("clip started %v before the motion, want about %v", gap, preRoll)
+	}
+	// The pre-roll, the second before the motion was noticed and the 300ms after
+	if minFrames := int64(1.5 * testFps); event.FrameCount < minFrames {
+		t.Errorf("clip has %d frames, want at least %d", event.FrameCount, minFrames)
+	}
+	if !event.EndedAt.Valid || event.PeakScore != 0.3 || len(event.Thumbnail) == 0 {
+		t.Errorf("event ended %v with peak score %v and a %d byte thumbnail, want it finished with the stopped event's peak", event.EndedAt, event.PeakScore, len(event.Thumbnail))
+	}
+	info, err := os.Stat(event.ClipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != event.SizeBytes {
+		t.Errorf("clip is %d bytes, but recorded as %d", info.Size(), event.SizeBytes)
+	}
+}
+
+func TestLongMotionIsSplitIntoClips(t *testing.T) {
+	store := events.NewEventStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	detector := newFakeDetector()
+	camera := startTestCamera(t)
+	clipper := NewClipper(camera, detector, store, t.TempDir(), 0, time.Second, log.New(io.Discard, "", 0))
+	time.Sleep(200 * time.Millisecond)
+
+	startedAt := time.Now()
+	detector.events <- motion.Event{Type: motion.MotionStarted, Time: startedAt, StartedAt: startedAt, Score: 0.1, PeakScore: 0.1, PeakFrame: testFrame(t, camera)}
+	runFor(t, 2500*time.Millisecond, clipper.Run)
+
+	motionEvents := storedEvents(t, store)
+	if len(motionEvents) < 3 {
+		t.Fatalf("got %d motion events in 2.5s of motion cut at 1s, want at least 3", len(motionEvents))
+	}
+	// GetEvents is newest first
+	for i := len(motionEvents) - 1; i >= 0; i-- {
+		event := motionEvents[i]
+		if !event.EndedAt.Valid || event.FrameCount <= 0 || len(event.Thumbnail) == 0 {
+			t.Errorf("event %d ended %v with %d frames and a %d byte thumbnail, want it finished", event.ID, event.EndedAt, event.FrameCount, len(event.Thumbnail))
+		}
+		if i == len(motionEvents)-1 {
+			continue
+		}
+		previous := motionEvents[i+1]
+		if event.ClipPath == previous.ClipPath {
+			t.Errorf("events %d and %d were both written to %s", previous.ID, event.ID, event.ClipPath)
+		}
+		// Each clip carries on straight after the one before
+		if !event.StartedAt.Equal(previous.EndedAt.Time) {
+			t.Errorf("event %d started at %v, but the one before ended at %v", event.ID, event.StartedAt, previous.EndedAt.Time)
+		}
+		if length := previous.EndedAt.Time.Sub(previous.ClipStartedAt); length < time.Second || length > 1500*time.Millisecond {
+			t.Errorf("event %d's clip is %v long, want about 1s", previous.ID, length)
+		}
+	}
+}
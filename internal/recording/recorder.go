@@ -0,0 +1,182 @@
+// Package recording keeps what the camera sees by writing its frames to disk
+package recording
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/recordings"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Who the recorder starts the camera as
+var recorderClient = states.CameraClient{Name: "recorder"}
+
+// Recorder continuously writes the camera's frames to time-segmented .mjpeg files under a directory,
+// indexing each segment in the database and deleting the oldest segments once they are too old or
+// take up too much space
+type Recorder struct {
+	camera         *states.Camera
+	recordingStore *recordings.RecordingStore
+	dir            string
+	segmentLength  time.Duration
+	maxAge         time.Duration // Zero for no limit
+	maxBytes       int64         // Zero for no limit
+	logger         *log.Logger
+
+	segment   *segmentFile
+	recording db.Recording
+}
+
+func NewRecorder(camera *states.Camera, recordingStore *recordings.RecordingStore, dir string, segmentLength, maxAge time.Duration, maxBytes int64, logger *log.Logger) *Recorder {
+	return &Recorder{
+		camera:         camera,
+		recordingStore: recordingStore,
+		dir:            dir,
+		segmentLength:  segmentLength,
+		maxAge:         maxAge,
+		maxBytes:       maxBytes,
+		logger:         logger,
+	}
+}
+
+// Run records until the context is cancelled. Being a subscriber keeps the camera from stopping for
+// lack of viewers, and the camera is restarted if its source stops for any other reason
+func (r *Recorder) Run(ctx context.Context) {
+	r.logger.Printf("Recording to %s in %s segments", r.dir, r.segmentLength)
+
+	frames := r.camera.Subscribe()
+	defer r.camera.Unsubscribe(frames)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	r.enforceRetention(ctx)
+	r.startCamera()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.finishSegment(context.Background())
+			return
+
+		case <-ticker.C:
+			r.startCamera()
+			if r.segment != nil && time.Since(r.recording.StartedAt) >= r.segmentLength {
+				r.finishSegment(ctx)
+			}
+
+		case frame := <-frames:
+			if r.segment == nil {
+				if err := r.startSegment(ctx); err != nil {
+					r.logger.Printf("Error when starting recording segment: %v", err)
+					continue
+				}
+			}
+			if err := r.segment.writeFrame(frame); err != nil {
+				r.logger.Printf("Error when writing frame to %s: %v", r.recording.Path, err)
+				r.finishSegment(ctx)
+				continue
+			}
+			if time.Since(r.recording.StartedAt) >= r.segmentLength {
+				r.finishSegment(ctx)
+			}
+		}
+	}
+}
+
+func (r *Recorder) startCamera() {
+	if err := r.camera.Start(recorderClient); err != nil {
+		r.logger.Printf("Couldn't start camera for recording: %v", err)
+	}
+}
+
+func (r *Recorder) startSegment(ctx context.Context) error {
+	now := time.Now()
+	path := filepath.Join(r.dir, now.Format("2006-01-02"), now.Format("15-04-05")+".mjpeg")
+
+	segment, err := createSegmentFile(path)
+	if err != nil {
+		return err
+	}
+
+	recording, err := r.recordingStore.AddRecording(ctx, path, now)
+	if err != nil {
+		segment.close()
+		os.Remove(path)
+		return err
+	}
+
+	r.segment = segment
+	r.recording = recording
+	return nil
+}
+
+// Close off the current segment (if any), record its final size in the index, then make room for
+// the next one
+func (r *Recorder) finishSegment(ctx context.Context) {
+	if r.segment == nil {
+		return
+	}
+
+	if err := r.segment.close(); err != nil {
+		r.logger.Printf("Error when closing %s: %v", r.recording.Path, err)
+	}
+	err := r.recordingStore.FinishRecording(ctx, r.recording.ID, time.Now(), r.segment.frameCount, r.segment.sizeBytes)
+	if err != nil {
+		r.logger.Printf("Error when finishing recording %d: %v", r.recording.ID, err)
+	}
+	r.segment = nil
+
+	r.enforceRetention(ctx)
+}
+
+// Delete segments older than the maximum age, then the oldest segments until the total size is
+// within the limit
+func (r *Recorder) enforceRetention(ctx context.Context) {
+	if r.maxAge > 0 {
+		expired, err := r.recordingStore.GetFinishedRecordingsStartedBefore(ctx, time.Now().Add(-r.maxAge))
+		if err != nil {
+			return
+		}
+		for _, recording := range expired {
+			r.deleteRecording(ctx, recording)
+		}
+	}
+
+	if r.maxBytes > 0 {
+		totalSize, err := r.recordingStore.TotalSize(ctx)
+		if err != nil || totalSize <= r.maxBytes {
+			return
+		}
+		oldest, err := r.recordingStore.GetFinishedRecordingsOldestFirst(ctx)
+		if err != nil {
+			return
+		}
+		for _, recording := range oldest {
+			if totalSize <= r.maxBytes {
+				break
+			}
+			r.deleteRecording(ctx, recording)
+			totalSize -= recording.SizeBytes
+		}
+	}
+}
+
+func (r *Recorder) deleteRecording(ctx context.Context, recording db.Recording) {
+	if err := os.Remove(recording.Path); err != nil && !os.IsNotExist(err) {
+		r.logger.Printf("Error when deleting %s: %v", recording.Path, err)
+		return
+	}
+	// Tidy up the day's directory once its last segment is gone (fails harmlessly if it isn't empty)
+	os.Remove(filepath.Dir(recording.Path))
+
+	if _, err := r.recordingStore.DeleteRecording(ctx, recording.ID); err != nil {
+		r.logger.Printf("Error when removing recording %d from the index: %v", recording.ID, err)
+		return
+	}
+	r.logger.Printf("Deleted recording %s", recording.Path)
+}
@@ -0,0 +1,198 @@
+package recording
+
+import (
+	"bytes"
+	"catcam_go/internal/db"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/recordings"
+	"context"
+	"database/sql"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const testFps = 20
+
+// A frame source sending the same small JPEG at the frame rate it's asked for
+type fakeFrameSource struct{}
+
+func (s fakeFrameSource) Open(settings states.CaptureSettings) (io.ReadCloser, error) {
+	var frame bytes.Buffer
+	if err := jpeg.Encode(&frame, image.NewGray(image.Rect(0, 0, 16, 12)), nil); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		ticker := time.NewTicker(time.Second / time.Duration(settings.Fps))
+		defer ticker.Stop()
+		for range ticker.C {
+			// Fails once the camera closes the read end of the pipe
+			if _, err := pw.Write(frame.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+	return pr, nil
+}
+
+func (s fakeFrameSource) String() string {
+	return "fake"
+}
+
+func newTestCamera(t *testing.T) *states.Camera {
+	t.Helper()
+	camera := states.NewCamera(fakeFrameSource{}, 16, 12, testFps, 80, 2, states.NewLight(&states.FakeLEDDriver{NumPixels: 1}))
+	t.Cleanup(func() { camera.Stop(states.CameraClient{Name: "test"}) })
+	return camera
+}
+
+func newTestQueries(t *testing.T) *db.Queries {
+	t.Helper()
+	dbPool, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { dbPool.Close() })
+	if err := db.Migrate(context.Background(), dbPool, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	return db.New(dbPool)
+}
+
+// Run the job until the test has seen enough, then wait for it to finish up
+func runFor(t *testing.T, d time.Duration, run func(ctx context.Context)) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	time.Sleep(d)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("still running after being cancelled")
+	}
+}
+
+func TestRecorderRotatesSegments(t *testing.T) {
+	store := recordings.NewRecordingStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	dir := t.TempDir()
+	recorder := NewRecorder(newTestCamera(t), store, dir, time.Second, 0, 0, log.New(io.Discard, "", 0))
+
+	runFor(t, 2500*time.Millisecond, recorder.Run)
+
+	segments, err := store.GetFinishedRecordingsOldestFirst(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("recorded %d segments in 2.5s of 1s segments, want at least 2", len(segments))
+	}
+	for i, segment := range segments {
+		if !segment.EndedAt.Valid || segment.FrameCount <= 0 {
+			t.Errorf("segment %s: ended %v with %d frames, want it finished with some frames", segment.Path, segment.EndedAt, segment.FrameCount)
+		}
+		if filepath.Dir(filepath.Dir(segment.Path)) != dir {
+			t.Errorf("segment %s isn't in a day's directory under %s", segment.Path, dir)
+		}
+		info, err := os.Stat(segment.Path)
+		if err != nil {
+			t.Errorf("segment %s: %v", segment.Path, err)
+		} else if info.Size() != segment.SizeBytes {
+			t.Errorf("segment %s is %d bytes, but indexed as %d", segment.Path, info.Size(), segment.SizeBytes)
+		}
+		if i > 0 && segment.Path == segments[i-1].Path {
+			t.Errorf("segments %d and %d were both written to %s", i-1, i, segment.Path)
+		}
+		if length := segment.EndedAt.Time.Sub(segment.StartedAt); i < len(segments)-1 && length > 2*time.Second {
+			t.Errorf("segment %s is %v long, want about 1s", segment.Path, length)
+		}
+	}
+}
+
+// Add a finished recording of the given size that started at the given time, with its file
+func addTestRecording(t *testing.T, store *recordings.RecordingStore, dir string, startedAt time.Time, size int) db.Recording {
+	t.Helper()
+	path := filepath.Join(dir, startedAt.Format("2006-01-02"), startedAt.Format("15-04-05")+".mjpeg")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recording, err := store.AddRecording(context.Background(), path, startedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FinishRecording(context.Background(), recording.ID, startedAt.Add(time.Minute), 1, int64(size)); err != nil {
+		t.Fatal(err)
+	}
+	return recording
+}
+
+// The paths of the recordings left, and whether each file still exists
+func remainingRecordings(t *testing.T, store *recordings.RecordingStore, all []db.Recording) map[string]bool {
+	t.Helper()
+	indexed, err := store.GetRecordings(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := make(map[string]bool)
+	for _, recording := range indexed {
+		remaining[recording.Path] = true
+	}
+	for _, recording := range all {
+		_, err := os.Stat(recording.Path)
+		if exists := err == nil; exists != remaining[recording.Path] {
+			t.Errorf("%s: file exists %v, but indexed %v", recording.Path, exists, remaining[recording.Path])
+		}
+	}
+	return remaining
+}
+
+func TestRecorderDeletesOldSegments(t *testing.T) {
+	store := recordings.NewRecordingStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	dir := t.TempDir()
+	now := time.Now()
+	old := addTestRecording(t, store, dir, now.Add(-72*time.Hour), 100)
+	recent := addTestRecording(t, store, dir, now.Add(-time.Hour), 100)
+
+	recorder := NewRecorder(newTestCamera(t), store, dir, time.Minute, 24*time.Hour, 0, log.New(io.Discard, "", 0))
+	recorder.enforceRetention(context.Background())
+
+	remaining := remainingRecordings(t, store, []db.Recording{old, recent})
+	if remaining[old.Path] || !remaining[recent.Path] {
+		t.Errorf("recordings left: %v, want only %s", remaining, recent.Path)
+	}
+	if _, err := os.Stat(filepath.Dir(old.Path)); !os.IsNotExist(err) {
+		t.Errorf("the old recording's directory is still there (%v)", err)
+	}
+}
+
+func TestRecorderDeletesOldestSegmentsOverSize(t *testing.T) {
+	store := recordings.NewRecordingStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	dir := t.TempDir()
+	now := time.Now()
+	oldest := addTestRecording(t, store, dir, now.Add(-3*time.Hour), 100)
+	older := addTestRecording(t, store, dir, now.Add(-2*time.Hour), 100)
+	newest := addTestRecording(t, store, dir, now.Add(-time.Hour), 100)
+
+	recorder := NewRecorder(newTestCamera(t), store, dir, time.Minute, 0, 250, log.New(io.Discard, "", 0))
+	recorder.enforceRetention(context.Background())
+
+	remaining := remainingRecordings(t, store, []db.Recording{oldest, older, newest})
+	if remaining[oldest.Path] || !remaining[older.Path] || !remaining[newest.Path] {
+		t.Errorf("recordings left: %v, want all but %s", remaining, oldest.Path)
+	}
+}
@@ -0,0 +1,48 @@
+package recording
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+)
+
+// segmentFile is a raw .mjpeg file: JPEG frames written back to back, exactly as they came from the
+// camera, so it can be replayed by splitting it with the mjpeg package (or played by ffplay/VLC)
+type segmentFile struct {
+	file       *os.File
+	writer     *bufio.Writer
+	frameCount int64
+	sizeBytes  int64
+}
+
+func createSegmentFile(path string) (*segmentFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentFile{
+		file:   file,
+		writer: bufio.NewWriterSize(file, 256*1024),
+	}, nil
+}
+
+func (s *segmentFile) writeFrame(frame []byte) error {
+	n, err := s.writer.Write(frame)
+	s.sizeBytes += int64(n)
+	if err != nil {
+		return err
+	}
+	s.frameCount++
+	return nil
+}
+
+func (s *segmentFile) close() error {
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
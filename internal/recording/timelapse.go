@@ -0,0 +1,200 @@
+package recording
+
+import (
+	"bytes"
+	"catcam_go/internal/avi"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/timelapses"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// How long to wait for the camera to produce a frame for the timelapse
+const timelapseFrameTimeout = 10 * time.Second
+
+// Who the timelapse starts the camera as
+var timelapseClient = states.CameraClient{Name: "timelapse"}
+
+// Timelapser takes a still from the camera every so often, saving them under a directory per day.
+// Once a day is over its stills are assembled into an MJPEG AVI and deleted
+type Timelapser struct {
+	camera         *states.Camera
+	timelapseStore *timelapses.TimelapseStore
+	dir            string
+	interval       time.Duration
+	fps            int // Frame rate of the assembled video
+	logger         *log.Logger
+}
+
+func NewTimelapser(camera *states.Camera, timelapseStore *timelapses.TimelapseStore, dir string, interval time.Duration, fps int, logger *log.Logger) *Timelapser {
+	return &Timelapser{
+		camera:         camera,
+		timelapseStore: timelapseStore,
+		dir:            dir,
+		interval:       interval,
+		fps:            fps,
+		logger:         logger,
+	}
+}
+
+// Run captures stills until the context is cancelled. The camera is only started for as long as it
+// takes to grab each still (see Camera.Snapshot), so unless someone is watching it stops in between
+func (t *Timelapser) Run(ctx context.Context) {
+	t.logger.Printf("Capturing a timelapse still every %s to %s", t.interval, t.dir)
+
+	day := time.Now().Format("2006-01-02")
+	t.assembleDaysBefore(ctx, day)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if today := now.Format("2006-01-02"); today != day {
+				if err := t.assemble(ctx, day); err != nil {
+					t.logger.Printf("Error when assembling timelapse for %s: %v", day, err)
+				}
+				day = today
+			}
+			if err := t.captureStill(now); err != nil {
+				t.logger.Printf("Error when capturing timelapse still: %v", err)
+			}
+		}
+	}
+}
+
+func (t *Timelapser) captureStill(now time.Time) error {
+	frame, err := t.camera.Snapshot(timelapseClient, timelapseFrameTimeout)
+	if err != nil {
+		return err
+	}
+
+	dayDir := filepath.Join(t.dir, now.Format("2006-01-02"))
+	if err := os.MkdirAll(dayDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dayDir, now.Format("15-04-05")+".jpg"), frame, 0644)
+}
+
+// Assemble the stills left over from earlier days, e.g. if we weren't running at midnight
+func (t *Timelapser) assembleDaysBefore(ctx context.Context, today string) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			t.logger.Printf("Error when reading %s: %v", t.dir, err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() >= today {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", entry.Name()); err != nil {
+			continue
+		}
+		if err := t.assemble(ctx, entry.Name()); err != nil {
+			t.logger.Printf("Error when assembling timelapse for %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// Turn a day's stills into dir/YYYY-MM-DD.avi, then delete them
+func (t *Timelapser) assemble(ctx context.Context, day string) error {
+	dayDir := filepath.Join(t.dir, day)
+	entries, err := os.ReadDir(dayDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var stills []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".jpg") {
+			stills = append(stills, filepath.Join(dayDir, entry.Name()))
+		}
+	}
+	slices.Sort(stills)
+	if len(stills) <= 0 {
+		return os.Remove(dayDir)
+	}
+
+	path := filepath.Join(t.dir, day+".avi")
+	frameCount, err := t.writeVideo(path, stills)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, err := t.timelapseStore.AddTimelapse(ctx, day, path, frameCount, info.Size()); err != nil {
+		return err
+	}
+	t.logger.Printf("Assembled %d stills into %s", frameCount, path)
+
+	return os.RemoveAll(dayDir)
+}
+
+// Write the stills into an AVI, going via a temporary file so a half written video never replaces a
+// good one. The video is the size of the first still that can be read, and any stills that can't be
+// read, or are a different size (e.g. from before the camera's resolution changed), are left out
+func (t *Timelapser) writeVideo(path string, stills []string) (int64, error) {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	var video *avi.Writer
+	var width, height int
+	for _, still := range stills {
+		frame, err := os.ReadFile(still)
+		if err != nil {
+			return 0, err
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+		if err != nil {
+			t.logger.Printf("Leaving %s out of the timelapse, as its size can't be read: %v", still, err)
+			continue
+		}
+		if video == nil {
+			width, height = config.Width, config.Height
+			if video, err = avi.NewWriter(file, width, height, t.fps); err != nil {
+				return 0, err
+			}
+		} else if config.Width != width || config.Height != height {
+			t.logger.Printf("Leaving %s out of the timelapse, as it's %dx%d rather than %dx%d", still, config.Width, config.Height, width, height)
+			continue
+		}
+		if err := video.WriteFrame(frame); err != nil {
+			return 0, err
+		}
+	}
+	if video == nil {
+		return 0, fmt.Errorf("none of the %d stills could be read", len(stills))
+	}
+	if err := video.Close(); err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+
+	return int64(video.FrameCount()), os.Rename(tmpPath, path)
+}
@@ -0,0 +1,109 @@
+package recording
+
+import (
+	"bytes"
+	"catcam_go/internal/store/timelapses"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestStill(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var still bytes.Buffer
+	if err := jpeg.Encode(&still, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, still.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimelapseCapturesStills(t *testing.T) {
+	store := timelapses.NewTimelapseStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	dir := t.TempDir()
+	camera := newTestCamera(t)
+	timelapser := NewTimelapser(camera, store, dir, time.Minute, 30, log.New(io.Discard, "", 0))
+
+	start := time.Date(2025, 1, 31, 23, 59, 0, 0, time.Local)
+	for _, now := range []time.Time{start, start.Add(time.Minute)} {
+		if err := timelapser.captureStill(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, still := range []string{"2025-01-31/23-59-00.jpg", "2025-02-01/00-00-00.jpg"} {
+		data, err := os.ReadFile(filepath.Join(dir, still))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width != camera.Width() || config.Height != camera.Height() {
+			t.Errorf("%s is %dx%d (%v), want a %dx%d JPEG", still, config.Width, config.Height, err, camera.Width(), camera.Height())
+		}
+	}
+}
+
+func TestTimelapseAssemblesEarlierDays(t *testing.T) {
+	store := timelapses.NewTimelapseStore(newTestQueries(t), log.New(io.Discard, "", 0))
+	dir := t.TempDir()
+	yesterday := filepath.Join(dir, "2025-01-30")
+	today := filepath.Join(dir, "2025-01-31")
+	for _, dayDir := range []string{yesterday, today} {
+		if err := os.Mkdir(dayDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestStill(t, filepath.Join(yesterday, "10-00-00.jpg"), 16, 12)
+	writeTestStill(t, filepath.Join(yesterday, "10-01-00.JPG"), 16, 12)
+	// Cut off part way through being written, and from before the resolution changed
+	if err := os.WriteFile(filepath.Join(yesterday, "10-02-00.jpg"), []byte{0xFF, 0xD8, 0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestStill(t, filepath.Join(yesterday, "10-03-00.jpg"), 32, 24)
+	writeTestStill(t, filepath.Join(yesterday, "10-04-00.jpg"), 16, 12)
+	if err := os.WriteFile(filepath.Join(yesterday, "notes.txt"), []byte("not a still"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestStill(t, filepath.Join(today, "09-00-00.jpg"), 16, 12)
+
+	timelapser := NewTimelapser(newTestCamera(t), store, dir, time.Minute, 30, log.New(io.Discard, "", 0))
+	timelapser.assembleDaysBefore(context.Background(), "2025-01-31")
+
+	timelapses, err := store.GetTimelapses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timelapses) != 1 {
+		t.Fatalf("got %d timelapses, want 1 for 2025-01-30", len(timelapses))
+	}
+	timelapse := timelapses[0]
+	if timelapse.Day != "2025-01-30" || timelapse.Path != filepath.Join(dir, "2025-01-30.avi") {
+		t.Errorf("timelapse for %s at %s, want 2025-01-30 at 2025-01-30.avi", timelapse.Day, timelapse.Path)
+	}
+	if timelapse.FrameCount != 3 {
+		t.Errorf("timelapse has %d frames, want the 3 readable 16x12 stills", timelapse.FrameCount)
+	}
+	info, err := os.Stat(timelapse.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != timelapse.SizeBytes {
+		t.Errorf("timelapse is %d bytes, but recorded as %d", info.Size(), timelapse.SizeBytes)
+	}
+	if _, err := os.Stat(timelapse.Path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind (%v)", err)
+	}
+	if _, err := os.Stat(yesterday); !os.IsNotExist(err) {
+		t.Errorf("yesterday's stills are still there (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(today, "09-00-00.jpg")); err != nil {
+		t.Errorf("today's still is gone: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"catcam_go/internal/states"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// How much of an animation the preview plays before looping
+const animationPreviewSeconds = 5
+
+// How many frames a second the preview has. It only has to give the idea, so it skips most of the
+// engine's ticks to keep each preview small and quick to render
+const animationPreviewTickRate = 10
+
+// The JSON representation of an animation preview
+type animationPreviewJson struct {
+	TickRate int        `json:"tick_rate"` // Frames per second
+	Frames   [][]string `json:"frames"`    // The #rrggbb color of each LED in each frame
+}
+
+// GET /light/preview
+func (s *server) animationPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode, err := states.ParseAnimationMode(query.Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	speed, err := strconv.ParseFloat(query.Get("speed"), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid speed: %q", query.Get("speed")), http.StatusBadRequest)
+		return
+	}
+	brightness, err := strconv.ParseFloat(query.Get("brightness"), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid brightness: %q", query.Get("brightness")), http.StatusBadRequest)
+		return
+	}
+	color, err := states.ParseHex(query.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	animation := states.Animation{Mode: mode, Color: color, Speed: speed, Brightness: brightness}
+	if err := animation.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Previews always use the Go engine, which plays the same animations as control_leds.py closely
+	// enough to get the idea
+	response := animationPreviewJson{TickRate: animationPreviewTickRate}
+	ticksPerFrame := states.AnimationTickRate / animationPreviewTickRate
+	for frameNum := range animationPreviewSeconds * animationPreviewTickRate {
+		frame := states.RenderAnimation(animation, s.ledCount, frameNum*ticksPerFrame)
+		colors := make([]string, len(frame))
+		for i, c := range frame {
+			colors[i] = c.Hex()
+		}
+		response.Frames = append(response.Frames, colors)
+	}
+
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Printf("Error when encoding animation preview: %v", err)
+	}
+}
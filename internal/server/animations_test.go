@@ -0,0 +1,74 @@
+package server
+
+import (
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/users"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAnimationPreviewRejectsBadNumbers(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "password")
+
+	preview := func(speed, brightness string) int {
+		query := url.Values{"mode": {"rainbow"}, "speed": {speed}, "brightness": {brightness}, "color": {"#ff8800"}}
+		return doRequest(t, s, routes, http.MethodGet, "/light/preview?"+query.Encode(), nil, session).Code
+	}
+
+	if code := preview("1", "0.5"); code != http.StatusOK {
+		t.Fatalf("valid animation: status %d, want 200", code)
+	}
+	tests := []struct {
+		speed, brightness string
+	}{
+		{"NaN", "0.5"},
+		{"Inf", "0.5"},
+		{"-Inf", "0.5"},
+		{"1", "NaN"},
+		{"1", "Inf"},
+		{"1e309", "0.5"},
+	}
+	for _, tt := range tests {
+		if code := preview(tt.speed, tt.brightness); code != http.StatusBadRequest {
+			t.Errorf("speed %s, brightness %s: status %d, want 400", tt.speed, tt.brightness, code)
+		}
+	}
+}
+
+func TestAnimationPreviewIsSmall(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "password")
+
+	query := url.Values{"mode": {"chase"}, "speed": {"1"}, "brightness": {"1"}, "color": {"#ff8800"}}
+	w := doRequest(t, s, routes, http.MethodGet, "/light/preview?"+query.Encode(), nil, session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	var preview animationPreviewJson
+	if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.TickRate != animationPreviewTickRate || len(preview.Frames) != animationPreviewSeconds*animationPreviewTickRate {
+		t.Errorf("preview has %d frames at %d a second, want %d at %d", len(preview.Frames), preview.TickRate, animationPreviewSeconds*animationPreviewTickRate, animationPreviewTickRate)
+	}
+	// Each frame is what the engine shows at that point in the animation
+	animation := states.Animation{Mode: states.ModeChase, Color: states.Color{R: 0xff, G: 0x88}, Speed: 1, Brightness: 1}
+	for _, frameNum := range []int{0, 1, len(preview.Frames) - 1} {
+		want := states.RenderAnimation(animation, s.ledCount, frameNum*states.AnimationTickRate/animationPreviewTickRate)
+		got := preview.Frames[frameNum]
+		if len(got) != len(want) {
+			t.Fatalf("frame %d has %d LEDs, want %d", frameNum, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i].Hex() {
+				t.Errorf("frame %d LED %d is %s, want %s", frameNum, i, got[i], want[i].Hex())
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/templates"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Prefixed so leaked tokens are easy to recognise
+const apiTokenPrefix = "catcam_"
+
+func newAPIToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(tokenBytes), nil
+}
+
+// GET /account/tokens
+func (s *server) listAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	apiTokens, err := s.apiTokenStore.GetTokensForUser(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting API tokens: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templates.APITokens(apiTokens, time.Now()), "API tokens")
+}
+
+// POST /account/tokens
+func (s *server) addAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formName := r.FormValue("name")
+	formScope := r.FormValue("scope")
+	formExpiresInDays := r.FormValue("expires-in-days")
+
+	validationErrors := make(map[string]string)
+	if formName == "" {
+		validationErrors["name"] = "Name is required"
+	}
+	scope, err := apitokens.ParseScope(formScope)
+	if err != nil {
+		validationErrors["scope"] = "Choose what the token can do"
+	}
+	expiresInDays, err := strconv.Atoi(formExpiresInDays)
+	if err != nil || expiresInDays < 0 {
+		validationErrors["expires-in-days"] = "Choose when the token expires"
+	}
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.APITokenForm(validationErrors))
+		return
+	}
+
+	// Zero days means it never expires
+	var expiresAt time.Time
+	if expiresInDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, expiresInDays)
+	}
+
+	token, err := newAPIToken()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when generating API token: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	user, _ := middleware.CurrentUser(r.Context())
+	apiToken, err := s.apiTokenStore.AddToken(r.Context(), token, user.ID, formName, scope, expiresAt)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when adding API token: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("%s added API token %q", user.Username, apiToken.Name)
+
+	renderTemplate(w, r, templates.NewAPIToken(apiToken, token, time.Now()))
+}
+
+// DELETE /account/tokens/{id}
+func (s *server) revokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid API token id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	user, _ := middleware.CurrentUser(r.Context())
+	err = s.apiTokenStore.DeleteTokenForUser(r.Context(), int64(id), user.ID)
+	if err != nil {
+		if _, ok := err.(apitokens.ErrAPITokenNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when revoking API token: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Return nothing so the revoked token is removed from the list
+	w.WriteHeader(http.StatusOK)
+}
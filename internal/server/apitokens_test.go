@@ -0,0 +1,55 @@
+package server
+
+import (
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/users"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokensCantManageTokens(t *testing.T) {
+	s, routes := newTestServer(t)
+	tabby := addTestUser(t, s, "tabby", "tabby password", users.RoleViewer)
+	token := "catcam_test"
+	if _, err := s.apiTokenStore.AddToken(context.Background(), token, tabby.ID, "script", apitokens.ScopeAll, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method, target string
+		form           url.Values
+	}{
+		{http.MethodGet, "/account/tokens", nil},
+		{http.MethodPost, "/account/tokens", url.Values{"name": {"another"}, "scope": {"all"}, "expires-in-days": {"0"}}},
+		{http.MethodDelete, "/account/tokens/1", nil},
+	} {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with an API token: status %d, want 403", test.method, test.target, w.Code)
+		}
+	}
+
+	tokens, err := s.apiTokenStore.GetTokensForUser(context.Background(), tabby.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Errorf("tabby has %d API tokens, want just the one they started with", len(tokens))
+	}
+
+	// Logged in, they can still make more
+	session := login(t, s, routes, "tabby", "tabby password")
+	w := doRequest(t, s, routes, http.MethodPost, "/account/tokens", url.Values{"name": {"another"}, "scope": {"feed"}, "expires-in-days": {"0"}}, session)
+	if w.Code != http.StatusOK {
+		t.Errorf("POST /account/tokens logged in: status %d, want 200", w.Code)
+	}
+}
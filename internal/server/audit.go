@@ -0,0 +1,117 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/templates"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Who made the request: the logged in user, the guest with a share link, or just an address
+func auditActor(r *http.Request) audit.Actor {
+	actor := audit.Actor{IP: clientIP(r)}
+	if user, ok := middleware.CurrentUser(r.Context()); ok {
+		actor.UserID = sql.NullInt64{Int64: user.ID, Valid: true}
+		actor.Username = user.Username
+	} else if link, ok := r.Context().Value(shareLinkContextKey).(db.ShareLink); ok {
+		actor.Username = fmt.Sprintf("%s (guest)", link.Name)
+	}
+	return actor
+}
+
+// The actor for someone logging in as a user, who isn't logged in yet
+func loginActor(r *http.Request, user db.User) audit.Actor {
+	return audit.Actor{
+		UserID:   sql.NullInt64{Int64: user.ID, Valid: user.ID != 0},
+		Username: user.Username,
+		IP:       clientIP(r),
+	}
+}
+
+// Record an action in the audit log. Failing to isn't reason enough to fail whatever was done, so
+// errors are only logged (by the store)
+func (s *server) recordAudit(ctx context.Context, action audit.Action, actor audit.Actor, detail string) {
+	s.auditStore.AddEntry(ctx, action, actor, detail)
+}
+
+// Record an action done by whoever made the request
+func (s *server) auditRequest(r *http.Request, action audit.Action, detail string) {
+	s.recordAudit(r.Context(), action, auditActor(r), detail)
+}
+
+// Who the camera is started for on behalf of a request, as the audit log has it
+func cameraClient(r *http.Request) states.CameraClient {
+	actor := auditActor(r)
+	return states.CameraClient{UserID: actor.UserID.Int64, Name: actor.Username, IP: actor.IP}
+}
+
+// Record the camera starting and stopping, along with who or what started or stopped it: someone
+// watching, or one of CatCam's own jobs
+func (s *server) auditCamera(running bool, by states.CameraClient) {
+	action := audit.ActionCameraStopped
+	if running {
+		action = audit.ActionCameraStarted
+	}
+	actor := audit.Actor{
+		UserID:   sql.NullInt64{Int64: by.UserID, Valid: by.UserID != 0},
+		Username: by.Name,
+		IP:       by.IP,
+	}
+	s.recordAudit(context.Background(), action, actor, "")
+}
+
+// Forget audit entries older than AUDIT_RETENTION, unless they're to be kept forever
+func (s *server) pruneAuditLog(ctx context.Context) {
+	if s.auditRetention <= 0 {
+		return
+	}
+	s.auditStore.DeleteEntriesBefore(ctx, time.Now().Add(-s.auditRetention))
+}
+
+// GET /audit
+func (s *server) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{Username: query.Get("user")}
+	if action := query.Get("action"); action != "" {
+		parsed, err := audit.ParseAction(action)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.Action = parsed
+	}
+	date := query.Get("date")
+	if date != "" {
+		day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid date %q, expected YYYY-MM-DD", date), http.StatusBadRequest)
+			return
+		}
+		filter.Start, filter.End = day, day.AddDate(0, 0, 1)
+	}
+
+	s.pruneAuditLog(r.Context())
+
+	entries, err := s.auditStore.GetEntries(r.Context(), filter)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting audit log: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	usernames, err := s.auditStore.GetUsernames(r.Context())
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting audit log usernames: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templates.Audit(audit.Collapse(entries), usernames, filter, date, len(entries) >= audit.MaxEntries), "Audit log")
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/users"
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// The audit log's entries for an action, oldest first
+func auditEntries(t *testing.T, s *server, action audit.Action) []db.AuditLog {
+	t.Helper()
+	entries, err := s.auditStore.GetEntries(context.Background(), audit.Filter{Action: action})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Reverse(entries)
+	return entries
+}
+
+func TestEveryColorChangeIsAudited(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "password", users.RoleOperator)
+	session := login(t, s, routes, "tabby", "password")
+
+	colors := []string{"#ff0000", "#00ff00", "#0000ff"}
+	for _, color := range colors {
+		if w := doRequest(t, s, routes, http.MethodPost, "/set-color", url.Values{"color": {color}}, session); w.Code != http.StatusNoContent {
+			t.Fatalf("setting color %s: status %d", color, w.Code)
+		}
+	}
+
+	entries := auditEntries(t, s, audit.ActionColorChanged)
+	if len(entries) != len(colors) {
+		t.Fatalf("got %d color change entries, want %d", len(entries), len(colors))
+	}
+	for i, entry := range entries {
+		if entry.Detail != colors[i] || entry.Username != "tabby" {
+			t.Errorf("entry %d: %s by %q, want %s by tabby", i, entry.Detail, entry.Username, colors[i])
+		}
+	}
+}
+
+func TestCameraStartIsAuditedWithWhoStartedIt(t *testing.T) {
+	s, routes := newTestServer(t)
+	user := addTestUser(t, s, "tabby", "password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "password")
+
+	if w := doRequest(t, s, routes, http.MethodGet, "/snapshot", nil, session); w.Code != http.StatusOK {
+		t.Fatalf("getting snapshot: status %d", w.Code)
+	}
+	started := auditEntries(t, s, audit.ActionCameraStarted)
+	if len(started) != 1 {
+		t.Fatalf("got %d camera started entries, want 1", len(started))
+	}
+	if got := started[0]; got.UserID.Int64 != user.ID || got.Username != "tabby" || got.Ip == "" {
+		t.Errorf("camera started by user %v %q from %q, want tabby and their address", got.UserID, got.Username, got.Ip)
+	}
+}
@@ -1,69 +1,132 @@
 package server
 
 import (
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/apitokens"
 	"catcam_go/internal/store/users"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
 
 type CatCamSessionStore struct {
-	sessionStore sessions.Store
-	userStore    *users.UserStore
-	logger       *log.Logger
+	sessionStore  sessions.Store
+	userStore     *users.UserStore
+	apiTokenStore *apitokens.APITokenStore
+	csrfKey       []byte
+	logger        *log.Logger
 }
 
-func NewCatCamSessionStore(sessionStore sessions.Store, userStore *users.UserStore) *CatCamSessionStore {
+func NewCatCamSessionStore(sessionStore sessions.Store, userStore *users.UserStore, apiTokenStore *apitokens.APITokenStore, csrfKey []byte) *CatCamSessionStore {
 	return &CatCamSessionStore{
-		sessionStore: sessionStore,
-		userStore:    userStore,
-		logger:       log.New(os.Stdout, "[Session Store]: ", log.LstdFlags),
+		sessionStore:  sessionStore,
+		userStore:     userStore,
+		apiTokenStore: apiTokenStore,
+		csrfKey:       csrfKey,
+		logger:        log.New(os.Stdout, "[Session Store]: ", log.LstdFlags),
 	}
 }
 
-func (s *CatCamSessionStore) ValidateSession(r *http.Request) (int64, error) {
+// ValidateSession finds who the request is from, using the API token in its Authorization header if
+// it has one, or else its session cookie
+func (s *CatCamSessionStore) ValidateSession(r *http.Request) (int64, apitokens.Scope, error) {
+	if token, ok := middleware.BearerToken(r); ok {
+		return s.validateAPIToken(r, token)
+	}
+
 	// Get the authentication cookie
 	session, err := s.sessionStore.Get(r, "session")
 	if err != nil {
-		return 0, fmt.Errorf("Error when getting session (it was nil): %v", err)
+		return 0, "", fmt.Errorf("Error when getting session (it was nil): %v", err)
 
 	}
 
 	userIdValue := session.Values["userId"]
 	userId, ok := userIdValue.(int64)
 	if !ok {
-		return 0, fmt.Errorf("Invalid user ID in session (could not cast to int64): %v", userIdValue)
+		return 0, "", fmt.Errorf("Invalid user ID in session (could not cast to int64): %v", userIdValue)
 	}
 
-	// Validate the user exists
-	// Currently commented out for performance reasons, plus it's not that useful to me for this app
-	// _, err = s.userStore.GetUserById(r.Context(), userId)
-	// if err != nil {
-	// 	return 0, fmt.Errorf("Error when validating userId in session (could not get user by ID): %v", err)
-	// }
+	// No need to check the user still exists: their sessions are deleted along with them
 
-	return userId, nil
+	return userId, apitokens.ScopeAll, nil
 }
 
-func (s *CatCamSessionStore) WriteNew(w http.ResponseWriter, r *http.Request, userId int64) error {
-	session, err := s.sessionStore.New(r, "session")
+func (s *CatCamSessionStore) validateAPIToken(r *http.Request, token string) (int64, apitokens.Scope, error) {
+	apiToken, err := s.apiTokenStore.GetTokenByToken(r.Context(), token)
 	if err != nil {
-		return err
+		return 0, "", err
+	}
+
+	// Like sessions, only note when a token was used every so often
+	if !apiToken.LastUsed.Valid || time.Since(apiToken.LastUsed.Time) >= sessionTouchInterval {
+		s.apiTokenStore.TouchToken(r.Context(), apiToken.ID)
+	}
+	return apiToken.UserID, apitokens.Scope(apiToken.Scope), nil
+}
+
+// CurrentToken returns the token identifying the request's session, or an empty string if it doesn't
+// have one
+func (s *CatCamSessionStore) CurrentToken(r *http.Request) string {
+	session, err := s.sessionStore.Get(r, "session")
+	if err != nil || session.IsNew {
+		return ""
 	}
-	session.Options = &sessions.Options{
-		MaxAge:   3600, // 1 hour
-		HttpOnly: true, // JS cannot access the cookie
-		Domain:   "tbat.me",
-		SameSite: http.SameSiteLaxMode,
+	return session.ID
+}
+
+// CSRFToken returns the CSRF token for the request's session, or an empty string if it doesn't have
+// one. It's derived from the session token rather than stored, so every session has its own and it
+// changes whenever someone logs in again
+func (s *CatCamSessionStore) CSRFToken(r *http.Request) string {
+	sessionToken := s.CurrentToken(r)
+	if sessionToken == "" {
+		return ""
 	}
+	mac := hmac.New(sha256.New, s.csrfKey)
+	fmt.Fprintf(mac, "csrf:%s", sessionToken)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *CatCamSessionStore) WriteNew(w http.ResponseWriter, r *http.Request, userId int64) error {
+	// Always start a brand new session rather than reusing the one the request came with, so a
+	// session planted before logging in is no use afterwards
+	session := sessions.NewSession(s.sessionStore, "session")
+	session.IsNew = true
+	session.Options = sessionCookieOptions(3600) // 1 hour
 
 	session.Values["userId"] = userId
 	return session.Save(r, w)
 }
 
+// WritePending starts a short-lived session for a user who has given the right password but still
+// has to give a two-factor code. It doesn't log them in: that takes WriteNew once the code checks out
+func (s *CatCamSessionStore) WritePending(w http.ResponseWriter, r *http.Request, userId int64) error {
+	session := sessions.NewSession(s.sessionStore, "session")
+	session.IsNew = true
+	session.Options = sessionCookieOptions(300) // 5 minutes to find the authenticator app
+
+	session.Values["pendingUserId"] = userId
+	return session.Save(r, w)
+}
+
+// PendingUser returns the ID of the user whose login is waiting on a two-factor code, if any
+func (s *CatCamSessionStore) PendingUser(r *http.Request) (int64, bool) {
+	session, err := s.sessionStore.Get(r, "session")
+	if err != nil {
+		return 0, false
+	}
+	userId, ok := session.Values["pendingUserId"].(int64)
+	return userId, ok
+}
+
 func (s *CatCamSessionStore) EraseCurrent(w http.ResponseWriter, r *http.Request) {
 	session, err := s.sessionStore.Get(r, "session")
 	if err != nil {
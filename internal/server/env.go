@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Read a duration such as "5m" or "168h" from the environment, or return the fallback if it isn't set
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	str := os.Getenv(name)
+	if str == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration such as 5m or 24h, got %q", name, str)
+	}
+	return d, nil
+}
+
+// Read a boolean such as "true" or "0" from the environment, or return the fallback if it isn't set
+func envBool(name string, fallback bool) (bool, error) {
+	str := os.Getenv(name)
+	if str == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, str)
+	}
+	return b, nil
+}
+
+// Read a non-negative integer from the environment, or return the fallback if it isn't set
+func envInt(name string, fallback int64) (int64, error) {
+	str := os.Getenv(name)
+	if str == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got %q", name, str)
+	}
+	return n, nil
+}
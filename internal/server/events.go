@@ -0,0 +1,188 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/mjpeg"
+	"catcam_go/internal/store/events"
+	"catcam_go/internal/templates"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The JSON representation of a motion event
+type eventJson struct {
+	ID              int64      `json:"id"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	PeakScore       float64    `json:"peak_score"`
+	FrameCount      int64      `json:"frame_count"`
+	ThumbnailUrl    string     `json:"thumbnail_url"`
+	PlayUrl         string     `json:"play_url"`
+}
+
+// Get the motion events, filtered to a single (local) day if the request has a date=YYYY-MM-DD query
+// parameter. Returns the date that was filtered on, if any
+func (s *server) getFilteredEvents(r *http.Request) ([]db.MotionEvent, string, error) {
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		motionEvents, err := s.eventStore.GetEvents(r.Context())
+		return motionEvents, "", err
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, date, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+	}
+	motionEvents, err := s.eventStore.GetEventsBetween(r.Context(), day, day.AddDate(0, 0, 1))
+	return motionEvents, date, err
+}
+
+// Get the motion event identified by the request's {id} path value, writing an error response if
+// it can't be found
+func (s *server) getEventFromPath(w http.ResponseWriter, r *http.Request) (db.MotionEvent, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid event id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return db.MotionEvent{}, false
+	}
+
+	event, err := s.eventStore.GetEvent(r.Context(), int64(id))
+	if err != nil {
+		if _, ok := err.(events.ErrEventNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return db.MotionEvent{}, false
+		}
+		errMsg := fmt.Sprintf("Error when getting event: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return db.MotionEvent{}, false
+	}
+	return event, true
+}
+
+// GET /events
+func (s *server) listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	motionEvents, date, err := s.getFilteredEvents(r)
+	if err != nil {
+		s.logger.Printf("Error when getting events: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderTemplate(w, r, templates.Events(motionEvents, date), "Events")
+}
+
+// GET /api/events
+func (s *server) listEventsJsonHandler(w http.ResponseWriter, r *http.Request) {
+	motionEvents, _, err := s.getFilteredEvents(r)
+	if err != nil {
+		s.logger.Printf("Error when getting events: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := make([]eventJson, 0, len(motionEvents))
+	for _, event := range motionEvents {
+		e := eventJson{
+			ID:           event.ID,
+			StartedAt:    event.StartedAt,
+			PeakScore:    event.PeakScore,
+			FrameCount:   event.FrameCount,
+			ThumbnailUrl: fmt.Sprintf("/events/%d/thumbnail", event.ID),
+			PlayUrl:      fmt.Sprintf("/events/%d/play", event.ID),
+		}
+		if event.EndedAt.Valid {
+			e.EndedAt = &event.EndedAt.Time
+			e.DurationSeconds = event.EndedAt.Time.Sub(event.StartedAt).Seconds()
+		}
+		response = append(response, e)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Printf("Error when encoding events: %v", err)
+	}
+}
+
+// GET /events/{id}/thumbnail
+func (s *server) eventThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := s.getEventFromPath(w, r)
+	if !ok {
+		return
+	}
+	if len(event.Thumbnail) <= 0 {
+		http.Error(w, "Event has no thumbnail", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(event.Thumbnail)
+}
+
+// GET /events/{id}/player
+func (s *server) eventPlayerHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := s.getEventFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	renderTemplate(w, r, templates.EventPlayer(event), "Event")
+}
+
+// GET /events/{id}/play
+func (s *server) playEventHandler(w http.ResponseWriter, r *http.Request) {
+	event, ok := s.getEventFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	clip, err := os.Open(event.ClipPath)
+	if err != nil {
+		s.logger.Printf("Error when opening clip %s: %v", event.ClipPath, err)
+		http.Error(w, "Clip unavailable", http.StatusNotFound)
+		return
+	}
+	defer clip.Close()
+
+	// Play back at the rate the clip was recorded
+	interval := time.Second / time.Duration(max(s.camera.Fps(), 1))
+	if event.EndedAt.Valid && event.FrameCount > 0 {
+		interval = event.EndedAt.Time.Sub(event.ClipStartedAt) / time.Duration(event.FrameCount)
+	}
+	ticker := time.NewTicker(max(interval, time.Millisecond))
+	defer ticker.Stop()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	splitter := mjpeg.NewSplitter(clip, mjpeg.DefaultMaxFrameSize)
+	for {
+		frame, err := splitter.Next()
+		if err != nil {
+			if _, ok := err.(mjpeg.ErrCorruptFrame); ok {
+				continue
+			}
+			if err != io.EOF {
+				s.logger.Printf("Error when reading clip %s: %v", event.ClipPath, err)
+			}
+			return
+		}
+
+		if err := s.sendFrame(w, frame); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"catcam_go/internal/store/users"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Wait up to a few seconds for the condition to hold
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGuestFeedEndsWhenGuestLeaves(t *testing.T) {
+	s, routes := newTestServer(t)
+	owner := addTestUser(t, s, "tabby", "password", users.RoleAdmin)
+	link, err := s.shareLinkStore.AddShareLink(context.Background(), "Grandma", owner.ID, time.Now().Add(time.Hour), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, leave := context.WithCancel(context.Background())
+	r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/guest/"+s.shareLinkToken(link)+"/feed", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		routes.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	eventually(t, "the guest to start watching", func() bool {
+		return s.shareViewerCounts()[link.ID] == 1 && s.feedViewers.Load() == 1
+	})
+
+	leave()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("feed still streaming after the guest left")
+	}
+	if counts := s.shareViewerCounts(); len(counts) != 0 {
+		t.Errorf("share link viewers after the guest left: %v, want none", counts)
+	}
+	if viewers := s.feedViewers.Load(); viewers != 0 {
+		t.Errorf("feed viewers after the guest left: %d, want 0", viewers)
+	}
+}
+
+func TestFeedEndsWhenSessionIsRevoked(t *testing.T) {
+	s, routes := newTestServer(t)
+	tabby := addTestUser(t, s, "tabby", "password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "password")
+	defer func(interval time.Duration) { feedRecheckInterval = interval }(feedRecheckInterval)
+	feedRecheckInterval = 50 * time.Millisecond
+
+	r := httptest.NewRequest(http.MethodGet, "/feed", nil)
+	r.AddCookie(session)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		routes.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	eventually(t, "tabby to start watching", func() bool { return s.feedViewers.Load() == 1 })
+
+	if err := s.sessionRecords.DeleteSessionsForUser(context.Background(), tabby.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("feed still streaming after the session was revoked")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"catcam_go/internal/motion"
+	"catcam_go/internal/recording"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/events"
+	"catcam_go/internal/store/recordings"
+	"catcam_go/internal/store/timelapses"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Build the continuous recorder from the environment. Recording is off (and this returns nil) unless
+// RECORDING_DIR is set
+func recorderFromEnv(camera *states.Camera, recordingStore *recordings.RecordingStore, logger *log.Logger) (*recording.Recorder, error) {
+	dir := os.Getenv("RECORDING_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	segmentLength, err := envDuration("RECORDING_SEGMENT_LENGTH", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if segmentLength <= 0 {
+		return nil, fmt.Errorf("RECORDING_SEGMENT_LENGTH must be greater than zero")
+	}
+	maxAge, err := envDuration("RECORDING_MAX_AGE", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxSizeMB, err := envInt("RECORDING_MAX_SIZE_MB", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return recording.NewRecorder(camera, recordingStore, dir, segmentLength, maxAge, maxSizeMB*1024*1024, logger), nil
+}
+
+// Build the motion clipper from the environment. Clips are off (and this returns nil) unless
+// CLIP_DIR is set, and need motion detection to be on
+func clipperFromEnv(camera *states.Camera, detector *motion.Detector, eventStore *events.EventStore, logger *log.Logger) (*recording.Clipper, error) {
+	dir := os.Getenv("CLIP_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	if detector == nil {
+		return nil, fmt.Errorf("CLIP_DIR needs MOTION_DETECTION to be true")
+	}
+
+	preRoll, err := envDuration("CLIP_PRE_ROLL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	maxLength, err := envDuration("CLIP_MAX_LENGTH", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if maxLength <= 0 {
+		return nil, fmt.Errorf("CLIP_MAX_LENGTH must be greater than zero")
+	}
+
+	return recording.NewClipper(camera, detector, eventStore, dir, preRoll, maxLength, logger), nil
+}
+
+// Build the timelapse job from the environment. Timelapses are off (and this returns nil) unless
+// TIMELAPSE_DIR is set
+func timelapserFromEnv(camera *states.Camera, timelapseStore *timelapses.TimelapseStore, logger *log.Logger) (*recording.Timelapser, error) {
+	dir := os.Getenv("TIMELAPSE_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+
+	interval, err := envDuration("TIMELAPSE_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("TIMELAPSE_INTERVAL must be greater than zero")
+	}
+	fps, err := envInt("TIMELAPSE_FPS", 30)
+	if err != nil {
+		return nil, err
+	}
+	if fps <= 0 {
+		return nil, fmt.Errorf("TIMELAPSE_FPS must be greater than zero")
+	}
+
+	return recording.NewTimelapser(camera, timelapseStore, dir, interval, int(fps), logger), nil
+}
+
+// Build the motion detector from the environment. Motion detection is off (and this returns nil)
+// unless MOTION_DETECTION is true
+func motionDetectorFromEnv(logger *log.Logger) (*motion.Detector, error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv("MOTION_DETECTION")); !enabled {
+		return nil, nil
+	}
+
+	config := motion.DefaultConfig()
+
+	sensitivity, err := envInt("MOTION_SENSITIVITY", int64(config.Sensitivity))
+	if err != nil {
+		return nil, err
+	}
+	if sensitivity < 1 || sensitivity > 255 {
+		return nil, fmt.Errorf("MOTION_SENSITIVITY must be between 1 and 255")
+	}
+	config.Sensitivity = int(sensitivity)
+
+	if str := os.Getenv("MOTION_THRESHOLD"); str != "" {
+		threshold, err := strconv.ParseFloat(str, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return nil, fmt.Errorf("MOTION_THRESHOLD must be a fraction between 0 and 1, got %q", str)
+		}
+		config.Threshold = threshold
+	}
+
+	if config.Cooldown, err = envDuration("MOTION_COOLDOWN", config.Cooldown); err != nil {
+		return nil, err
+	}
+
+	if config.Regions, err = parseMotionRegions(os.Getenv("MOTION_REGIONS")); err != nil {
+		return nil, err
+	}
+
+	return motion.NewDetector(config, logger), nil
+}
+
+// Parse regions of interest written as "x,y,width,height" fractions of the frame, separated by
+// semicolons, e.g. "0,0.5,1,0.5" for the bottom half of the frame
+func parseMotionRegions(str string) ([]motion.Region, error) {
+	var regions []motion.Region
+	for _, regionStr := range strings.Split(str, ";") {
+		if strings.TrimSpace(regionStr) == "" {
+			continue
+		}
+		fields := strings.Split(regionStr, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("MOTION_REGIONS entry %q must be x,y,width,height", regionStr)
+		}
+		var values [4]float64
+		for i, field := range fields {
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || value < 0 || value > 1 {
+				return nil, fmt.Errorf("MOTION_REGIONS entry %q must contain fractions between 0 and 1", regionStr)
+			}
+			values[i] = value
+		}
+		regions = append(regions, motion.Region{X: values[0], Y: values[1], Width: values[2], Height: values[3]})
+	}
+	return regions, nil
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/lockouts"
+	"catcam_go/internal/templates"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits on failed logins. Each failure doubles the wait before the next attempt (starting from a
+// second) until the threshold is reached, which locks out logins for lockFor
+type loginLimits struct {
+	usernameThreshold int64 // Failures in a row before a username is locked out
+	ipThreshold       int64 // Failures in a row before an IP address is locked out, whatever username it tries
+	lockFor           time.Duration
+}
+
+// Read the login limits from the environment
+func loginLimitsFromEnv() (loginLimits, error) {
+	usernameThreshold, err := envInt("LOGIN_LOCKOUT_THRESHOLD", 5)
+	if err != nil {
+		return loginLimits{}, err
+	}
+	ipThreshold, err := envInt("LOGIN_IP_LOCKOUT_THRESHOLD", 20)
+	if err != nil {
+		return loginLimits{}, err
+	}
+	if usernameThreshold <= 0 || ipThreshold <= 0 {
+		return loginLimits{}, fmt.Errorf("LOGIN_LOCKOUT_THRESHOLD and LOGIN_IP_LOCKOUT_THRESHOLD must be greater than zero")
+	}
+	lockFor, err := envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	if err != nil {
+		return loginLimits{}, err
+	}
+	if lockFor <= 0 {
+		return loginLimits{}, fmt.Errorf("LOGIN_LOCKOUT_DURATION must be greater than zero")
+	}
+
+	return loginLimits{
+		usernameThreshold: usernameThreshold,
+		ipThreshold:       ipThreshold,
+		lockFor:           lockFor,
+	}, nil
+}
+
+// How much longer the subject must wait before it may try to log in again
+func (l loginLimits) retryAfter(lockout db.Lockout, now time.Time) time.Duration {
+	if lockout.Failures <= 0 {
+		return 0
+	}
+	if lockout.LockedUntil.Valid {
+		return lockout.LockedUntil.Time.Sub(now)
+	}
+	backoff := min(time.Second<<min(lockout.Failures-1, 30), l.lockFor)
+	return lockout.LastFailure.Add(backoff).Sub(now)
+}
+
+// How long the client must wait before trying to log in as username, if at all
+func (s *server) loginWait(ctx context.Context, username string, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for kind, subject := range map[lockouts.Kind]string{lockouts.KindUsername: username, lockouts.KindIP: ip} {
+		lockout, err := s.lockoutStore.GetLockout(ctx, kind, subject)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, s.loginLimits.retryAfter(lockout, now))
+	}
+	return wait, nil
+}
+
+// Tell the client how long to wait before trying again, rounded up to whole seconds as that's the
+// granularity of Retry-After. Returns the message to show them
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) string {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return fmt.Sprintf("Too many failed logins. Try again in %s", time.Duration(seconds)*time.Second)
+}
+
+// Count a failed login against both the username and the client's address
+func (s *server) recordLoginFailure(ctx context.Context, username string, ip string) {
+	s.logger.Printf("Failed login for %s from %s", username, ip)
+	if _, err := s.lockoutStore.RecordFailure(ctx, lockouts.KindUsername, username, s.loginLimits.usernameThreshold, s.loginLimits.lockFor); err != nil {
+		s.logger.Printf("Error when recording failed login: %v", err)
+	}
+	if _, err := s.lockoutStore.RecordFailure(ctx, lockouts.KindIP, ip, s.loginLimits.ipThreshold, s.loginLimits.lockFor); err != nil {
+		s.logger.Printf("Error when recording failed login: %v", err)
+	}
+}
+
+// Forget the failed logins before a successful one
+func (s *server) clearLoginFailures(ctx context.Context, username string, ip string) {
+	if err := s.lockoutStore.ClearLockout(ctx, lockouts.KindUsername, username); err != nil {
+		s.logger.Printf("Error when clearing failed logins: %v", err)
+	}
+	if err := s.lockoutStore.ClearLockout(ctx, lockouts.KindIP, ip); err != nil {
+		s.logger.Printf("Error when clearing failed logins: %v", err)
+	}
+}
+
+// GET /lockouts
+func (s *server) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	allLockouts, err := s.lockoutStore.GetLockouts(r.Context())
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting lockouts: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Leave out the failures that have since been forgotten
+	now := time.Now()
+	var current []db.Lockout
+	for _, lockout := range allLockouts {
+		if now.Sub(lockout.LastFailure) < s.loginLimits.lockFor {
+			current = append(current, lockout)
+		}
+	}
+
+	renderTemplate(w, r, templates.Lockouts(current, now), "Lockouts")
+}
+
+// DELETE /lockouts/{id}
+func (s *server) unlockHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid lockout id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.lockoutStore.DeleteLockout(r.Context(), int64(id)); err != nil {
+		if _, ok := err.(lockouts.ErrLockoutNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when unlocking: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Return nothing so the lockout is removed from the list
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"catcam_go/internal/store/users"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLoginRedirects(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "password", users.RoleViewer)
+
+	login := func(htmx bool) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"tabby"}, "password": {"password"}}
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+
+	// HTMX has to load the whole page, to get its CSRF token, rather than follow a redirect and only
+	// swap in the home page's content
+	w := login(true)
+	if w.Code != http.StatusOK || w.Header().Get("HX-Redirect") != "/" || w.Header().Get("Location") != "" {
+		t.Errorf("logging in with HTMX: status %d, HX-Redirect %q, Location %q, want 200 and an HX-Redirect to /", w.Code, w.Header().Get("HX-Redirect"), w.Header().Get("Location"))
+	}
+	if sessionCookie(w) == nil {
+		t.Error("logging in with HTMX didn't start a session")
+	}
+
+	w = login(false)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Errorf("logging in without HTMX: status %d, Location %q, want a 303 to /", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLogoutClearsTheLoginCookie(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "password")
+
+	w := doRequest(t, s, routes, http.MethodPost, "/logout", url.Values{}, session)
+	cleared := sessionCookie(w)
+	if cleared == nil {
+		t.Fatalf("logging out didn't clear the session cookie (status %d)", w.Code)
+	}
+	if cleared.MaxAge >= 0 {
+		t.Errorf("logging out set a session cookie with MaxAge %d, want it deleted", cleared.MaxAge)
+	}
+	// Browsers only replace a cookie with one for the same domain and path
+	if cleared.Domain != session.Domain || cleared.Path != session.Path {
+		t.Errorf("logging out cleared the cookie for domain %q path %q, but it was set for domain %q path %q", cleared.Domain, cleared.Path, session.Domain, session.Path)
+	}
+
+	w = doRequest(t, s, routes, http.MethodGet, "/", nil, session)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("GET / after logging out: status %d, want 303", w.Code)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/users"
+	"catcam_go/internal/templates"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only looks at the first 72 bytes of a password, and refuses longer ones
+const maxPasswordBytes = 72
+
+// Rules new passwords have to follow
+type passwordPolicy struct {
+	minLength        int64
+	requireMixedCase bool
+	requireDigit     bool
+	requireSymbol    bool
+}
+
+// Read the password policy from the environment
+func passwordPolicyFromEnv() (passwordPolicy, error) {
+	minLength, err := envInt("PASSWORD_MIN_LENGTH", 8)
+	if err != nil {
+		return passwordPolicy{}, err
+	}
+	if minLength > maxPasswordBytes {
+		return passwordPolicy{}, fmt.Errorf("PASSWORD_MIN_LENGTH can be at most %d", maxPasswordBytes)
+	}
+	requireMixedCase, err := envBool("PASSWORD_REQUIRE_MIXED_CASE", false)
+	if err != nil {
+		return passwordPolicy{}, err
+	}
+	requireDigit, err := envBool("PASSWORD_REQUIRE_DIGIT", false)
+	if err != nil {
+		return passwordPolicy{}, err
+	}
+	requireSymbol, err := envBool("PASSWORD_REQUIRE_SYMBOL", false)
+	if err != nil {
+		return passwordPolicy{}, err
+	}
+
+	return passwordPolicy{
+		minLength:        minLength,
+		requireMixedCase: requireMixedCase,
+		requireDigit:     requireDigit,
+		requireSymbol:    requireSymbol,
+	}, nil
+}
+
+// Check a new password for the user against the policy, returning what's wrong with it or an empty
+// string if nothing is
+func (p passwordPolicy) check(password string, username string) string {
+	if int64(len([]rune(password))) < p.minLength {
+		return fmt.Sprintf("Password must be at least %d characters long", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("Password must be at most %d bytes long", maxPasswordBytes)
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return "Password can't be the same as the username"
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	var missing []string
+	if p.requireMixedCase && !(hasUpper && hasLower) {
+		missing = append(missing, "upper and lower case letters")
+	}
+	if p.requireDigit && !hasDigit {
+		missing = append(missing, "a digit")
+	}
+	if p.requireSymbol && !hasSymbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return "Password must contain " + strings.Join(missing, ", ")
+	}
+	return ""
+}
+
+// Make up a temporary password for an admin to pass on, which the user must change when they log in
+func newTemporaryPassword() (string, error) {
+	passwordBytes := make([]byte, 10)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(passwordBytes)), nil
+}
+
+// GET /account/password
+func (s *server) changePasswordFormHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	renderTemplate(w, r, templates.ChangePasswordForm(user.MustChangePassword, nil), "Change password")
+}
+
+// POST /account/password
+func (s *server) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formCurrentPassword := r.FormValue("current-password")
+	formPassword := r.FormValue("password")
+	formConfirmPassword := r.FormValue("confirm-password")
+
+	user, _ := middleware.CurrentUser(r.Context())
+
+	validationErrors := make(map[string]string)
+	if formCurrentPassword == "" {
+		validationErrors["current-password"] = "Current password is required"
+	} else if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(formCurrentPassword)) != nil {
+		validationErrors["current-password"] = "Current password is incorrect"
+	}
+	if formPassword == "" {
+		validationErrors["password"] = "Password is required"
+	} else if msg := s.passwordPolicy.check(formPassword, user.Username); msg != "" {
+		validationErrors["password"] = msg
+	} else if formPassword == formCurrentPassword {
+		validationErrors["password"] = "New password must be different"
+	}
+	if formPassword != formConfirmPassword {
+		validationErrors["confirm-password"] = "Passwords do not match"
+	}
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.ChangePasswordForm(user.MustChangePassword, validationErrors))
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(formPassword), bcrypt.DefaultCost)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when hashing password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	if err := s.userStore.SetUserPassword(r.Context(), user.ID, string(passwordHash), false); err != nil {
+		errMsg := fmt.Sprintf("Error when setting password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("%s changed their password", user.Username)
+	s.auditRequest(r, audit.ActionPasswordChanged, "")
+
+	// Sign out everywhere else, in case the old password is why it was changed, and start a fresh
+	// session here
+	if err := s.sessionRecords.DeleteSessionsForUser(r.Context(), user.ID); err != nil {
+		s.logger.Printf("Error when signing out other sessions: %v", err)
+	}
+	if err := s.sessionStore.WriteNew(w, r, user.ID); err != nil {
+		s.logger.Printf("Error when saving session: %v", err)
+		redirectAfterForm(w, r, "/login")
+		return
+	}
+	redirectAfterForm(w, r, "/")
+}
+
+// POST /user/{id}/reset-password
+func (s *server) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid user id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.userStore.GetUserById(r.Context(), int64(id))
+	if err != nil {
+		if _, ok := err.(users.ErrUserNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when getting user: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	password, err := newTemporaryPassword()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when generating password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when hashing password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	err = s.userStore.SetUserPassword(r.Context(), int64(id), string(passwordHash), true)
+	if err != nil {
+		if _, ok := err.(users.ErrUserNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when resetting password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay signed in with it, or keep using tokens made with it
+	if err := s.sessionRecords.DeleteSessionsForUser(r.Context(), int64(id)); err != nil {
+		s.logger.Printf("Error when signing out user: %v", err)
+	}
+	if err := s.apiTokenStore.DeleteTokensForUser(r.Context(), int64(id)); err != nil {
+		s.logger.Printf("Error when revoking user's API tokens: %v", err)
+	}
+
+	user, _ := middleware.CurrentUser(r.Context())
+	s.logger.Printf("%s reset the password of %s", user.Username, target.Username)
+	s.auditRequest(r, audit.ActionPasswordReset, target.Username)
+	renderTemplate(w, r, templates.TemporaryPassword(password))
+}
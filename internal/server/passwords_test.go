@@ -0,0 +1,99 @@
+package server
+
+import (
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/users"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangePasswordKeepsUserLoggedIn(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "old password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "old password")
+
+	form := url.Values{"current-password": {"old password"}, "password": {"new password"}, "confirm-password": {"new password"}}
+	w := doRequest(t, s, routes, http.MethodPost, "/account/password", form, session)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("changing password: status %d (Location %q), want 303 to /", w.Code, w.Header().Get("Location"))
+	}
+	newSession := sessionCookie(w)
+	if newSession == nil {
+		t.Fatal("changing password didn't start a new session")
+	}
+	if !cookieSentTo(newSession, "/account/password", "/") {
+		t.Fatalf("new session cookie has Path %q, so it isn't sent to /", newSession.Path)
+	}
+
+	w = doRequest(t, s, routes, http.MethodGet, "/", nil, newSession)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET / after changing password: status %d (Location %q), want 200", w.Code, w.Header().Get("Location"))
+	}
+	// The session the password was changed with is signed out, like every other
+	w = doRequest(t, s, routes, http.MethodGet, "/", nil, session)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("GET / with the old session: status %d, want 303", w.Code)
+	}
+}
+
+func TestChangePasswordFromHTMXRedirectsWithHeader(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "tabby", "old password", users.RoleViewer)
+	session := login(t, s, routes, "tabby", "old password")
+
+	form := url.Values{"current-password": {"old password"}, "password": {"new password"}, "confirm-password": {"new password"}}
+	r := httptest.NewRequest(http.MethodPost, "/account/password", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("HX-Request", "true")
+	r.AddCookie(session)
+	r.Header.Set(middleware.CSRFHeader, s.sessionStore.CSRFToken(r))
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("HX-Redirect") != "/" {
+		t.Errorf("changing password with HTMX: status %d (HX-Redirect %q), want 200 with HX-Redirect /", w.Code, w.Header().Get("HX-Redirect"))
+	}
+	if entries := auditEntries(t, s, audit.ActionPasswordChanged); len(entries) != 1 || entries[0].Username != "tabby" {
+		t.Errorf("password changes audited: %v, want one by tabby", entries)
+	}
+}
+
+func TestResetPasswordRevokesSessionsAndTokens(t *testing.T) {
+	s, routes := newTestServer(t)
+	addTestUser(t, s, "admin", "admin password", users.RoleAdmin)
+	tabby := addTestUser(t, s, "tabby", "tabby password", users.RoleViewer)
+	adminSession := login(t, s, routes, "admin", "admin password")
+	tabbySession := login(t, s, routes, "tabby", "tabby password")
+	token := "catcam_test"
+	if _, err := s.apiTokenStore.AddToken(context.Background(), token, tabby.ID, "script", apitokens.ScopeFeed, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := doRequest(t, s, routes, http.MethodPost, fmt.Sprintf("/user/%d/reset-password", tabby.ID), url.Values{}, adminSession)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resetting password: status %d, want 200", w.Code)
+	}
+
+	w = doRequest(t, s, routes, http.MethodGet, "/", nil, tabbySession)
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("GET / with tabby's old session: status %d, want 303", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/snapshot", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /snapshot with tabby's API token: status %d, want 401", w.Code)
+	}
+	entries := auditEntries(t, s, audit.ActionPasswordReset)
+	if len(entries) != 1 || entries[0].Username != "admin" || entries[0].Detail != "tabby" {
+		t.Errorf("password resets audited: %v, want one by admin of tabby", entries)
+	}
+}
@@ -5,45 +5,110 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"catcam_go/internal/db"
 	"catcam_go/internal/middleware"
+	"catcam_go/internal/motion"
+	"catcam_go/internal/recording"
 	"catcam_go/internal/states"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/events"
+	"catcam_go/internal/store/lockouts"
+	"catcam_go/internal/store/recordings"
+	sessionstore "catcam_go/internal/store/sessions"
+	"catcam_go/internal/store/sharelinks"
+	"catcam_go/internal/store/timelapses"
+	"catcam_go/internal/store/twofactor"
 	"catcam_go/internal/store/users"
 	"catcam_go/internal/templates"
 
 	"github.com/a-h/templ"
-	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const AppName = "CatCam"
 
 type server struct {
-	logger       *log.Logger
-	port         int
-	httpServer   *http.Server
-	userStore    *users.UserStore
-	sessionStore *CatCamSessionStore
-	light        *states.Light
-	camera       *states.Camera
+	logger         *log.Logger
+	port           int
+	httpServer     *http.Server
+	userStore      *users.UserStore
+	recordingStore *recordings.RecordingStore
+	eventStore     *events.EventStore
+	timelapseStore *timelapses.TimelapseStore
+	sessionStore   *CatCamSessionStore
+	sessionRecords *sessionstore.SessionStore
+	lockoutStore   *lockouts.LockoutStore
+	loginLimits    loginLimits
+	passwordPolicy passwordPolicy
+	twoFactorStore *twofactor.TwoFactorStore
+	apiTokenStore  *apitokens.APITokenStore
+	shareLinkStore *sharelinks.ShareLinkStore
+	auditStore     *audit.AuditStore
+	auditRetention time.Duration // How long audit entries are kept, or zero for forever
+	light          *states.Light
+	ledCount       int // How many LEDs the light has
+	camera         *states.Camera
+	recorder       *recording.Recorder   // nil when recording is turned off
+	motionDetector *motion.Detector      // nil when motion detection is turned off
+	clipper        *recording.Clipper    // nil when motion clips are turned off
+	timelapser     *recording.Timelapser // nil when timelapses are turned off
+	feedViewers    atomic.Int64          // How many feeds are being streamed, to users and guests alike
+
+	setupMu    sync.Mutex
+	setupToken string // Only set while there are no users, see prepareSetup
+
+	shareKey     []byte // Signs share link tokens
+	shareMu      sync.Mutex
+	shareViewers map[int64]int // How many guests are watching with each share link
 }
 
 // Creat a new server instance with the given logger and port
-func NewServer(logger *log.Logger, port int, userStore *users.UserStore) (*server, error) {
+func NewServer(logger *log.Logger, port int, userStore *users.UserStore, recordingStore *recordings.RecordingStore, eventStore *events.EventStore, timelapseStore *timelapses.TimelapseStore, sessionRecords *sessionstore.SessionStore, lockoutStore *lockouts.LockoutStore, twoFactorStore *twofactor.TwoFactorStore, apiTokenStore *apitokens.APITokenStore, shareLinkStore *sharelinks.ShareLinkStore, auditStore *audit.AuditStore) (*server, error) {
 	if logger == nil {
 		return nil, fmt.Errorf("logger is required")
 	}
 	if userStore == nil {
 		return nil, fmt.Errorf("userStore is required")
 	}
+	if recordingStore == nil {
+		return nil, fmt.Errorf("recordingStore is required")
+	}
+	if eventStore == nil {
+		return nil, fmt.Errorf("eventStore is required")
+	}
+	if timelapseStore == nil {
+		return nil, fmt.Errorf("timelapseStore is required")
+	}
+	if sessionRecords == nil {
+		return nil, fmt.Errorf("sessionRecords is required")
+	}
+	if lockoutStore == nil {
+		return nil, fmt.Errorf("lockoutStore is required")
+	}
+	if twoFactorStore == nil {
+		return nil, fmt.Errorf("twoFactorStore is required")
+	}
+	if apiTokenStore == nil {
+		return nil, fmt.Errorf("apiTokenStore is required")
+	}
+	if shareLinkStore == nil {
+		return nil, fmt.Errorf("shareLinkStore is required")
+	}
+	if auditStore == nil {
+		return nil, fmt.Errorf("auditStore is required")
+	}
 
 	sessionKeyB64 := os.Getenv("SESSION_KEY")
 	if sessionKeyB64 == "" {
@@ -55,34 +120,131 @@ func NewServer(logger *log.Logger, port int, userStore *users.UserStore) (*serve
 		return nil, fmt.Errorf("Error when decoding session key. Ensure it is a base64 encoded string of 32 random bytes: %v", err)
 	}
 
-	cookieStore := sessions.NewCookieStore(sessionKeyBytes)
+	sqliteStore := newSqliteSessionStore(sessionRecords, sessionKeyBytes)
 
-	light := states.NewLight()
+	loginLimits, err := loginLimitsFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
-	return &server{
-		logger:       logger,
-		port:         port,
-		userStore:    userStore,
-		sessionStore: NewCatCamSessionStore(cookieStore, userStore),
-		light:        light,
-		camera:       states.NewCamera(1296/1.2, 972/1.2, 30, 50, 1, light),
-	}, nil
-}
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
-// Start the server
-func (s *server) Start() error {
-	s.logger.Printf("Starting server on port %d", s.port)
-	var stopChan chan os.Signal
+	auditRetention, err := envDuration("AUDIT_RETENTION", 90*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	frameSource, err := frameSourceFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
+	settings, err := captureSettingsFromEnv(states.CaptureSettings{
+		Width:   1296 / 1.2,
+		Height:  972 / 1.2,
+		Fps:     30,
+		Quality: 50,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ledDriver, ledCount, err := ledDriverFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	light := states.NewLight(ledDriver)
+	camera := states.NewCamera(frameSource, settings.Width, settings.Height, settings.Fps, settings.Quality, 1, light)
+
+	recorder, err := recorderFromEnv(camera, recordingStore, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	motionDetector, err := motionDetectorFromEnv(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	clipper, err := clipperFromEnv(camera, motionDetector, eventStore, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	timelapser, err := timelapserFromEnv(camera, timelapseStore, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{
+		logger:         logger,
+		port:           port,
+		userStore:      userStore,
+		recordingStore: recordingStore,
+		eventStore:     eventStore,
+		timelapseStore: timelapseStore,
+		sessionStore:   NewCatCamSessionStore(sqliteStore, userStore, apiTokenStore, sessionKeyBytes),
+		sessionRecords: sessionRecords,
+		lockoutStore:   lockoutStore,
+		loginLimits:    loginLimits,
+		passwordPolicy: passwordPolicy,
+		twoFactorStore: twoFactorStore,
+		apiTokenStore:  apiTokenStore,
+		shareLinkStore: shareLinkStore,
+		auditStore:     auditStore,
+		auditRetention: auditRetention,
+		light:          light,
+		ledCount:       ledCount,
+		camera:         camera,
+		recorder:       recorder,
+		motionDetector: motionDetector,
+		clipper:        clipper,
+		timelapser:     timelapser,
+		shareKey:       sessionKeyBytes,
+		shareViewers:   make(map[int64]int),
+	}
+	camera.OnRunningChange(s.auditCamera)
+	go s.logLightChanges(light.Subscribe())
+	s.pruneAuditLog(context.Background())
+	return s, nil
+}
+
+// Build the router serving all of the server's pages, with their middleware
+func (s *server) routes() http.Handler {
 	// define router
 	router := http.NewServeMux()
 
 	// define middleware
-	authMiddleware := middleware.Auth(s.sessionStore, s.userStore)
+	// API tokens limited to a scope only get through the routes for that scope, and no API token can
+	// manage API tokens. Users whose password has been reset can only change it (or log out) until they do. Requests that change things have
+	// to come from our own pages
+	csrfMiddleware := middleware.CSRF(s.sessionStore, http.HandlerFunc(s.csrfRejectedHandler))
+	authScopeMiddleware := func(scope apitokens.Scope) middleware.Middleware {
+		return middleware.Chain(middleware.Auth(s.sessionStore, s.userStore), csrfMiddleware, middleware.RequireScope(scope), middleware.RequirePasswordChanged("/account/password"))
+	}
+	authMiddleware := authScopeMiddleware(apitokens.ScopeAll)
 	htmlContentTypeMiddleware := middleware.ContentType("text/html; charset=utf-8")
 	loggingMiddleware := middleware.Chain(htmlContentTypeMiddleware, middleware.Logging)
 	authLoggingMiddleware := middleware.Chain(htmlContentTypeMiddleware, middleware.Logging, authMiddleware)
+	passwordChangeLoggingMiddleware := middleware.Chain(htmlContentTypeMiddleware, middleware.Logging, middleware.Auth(s.sessionStore, s.userStore), csrfMiddleware, middleware.RequireScope(apitokens.ScopeAll))
+	lightLoggingMiddleware := middleware.Chain(htmlContentTypeMiddleware, middleware.Logging, authScopeMiddleware(apitokens.ScopeLight), middleware.RequireRole(users.RoleOperator))
+	adminLoggingMiddleware := middleware.Chain(authLoggingMiddleware, middleware.RequireRole(users.RoleAdmin))
+	sessionLoggingMiddleware := middleware.Chain(authLoggingMiddleware, middleware.RequireSession)
 	authLoggingFeedMiddleware := middleware.Chain(middleware.ContentType("multipart/x-mixed-replace; boundary=frame"), middleware.Logging, authMiddleware)
+	authLoggingJpegMiddleware := middleware.Chain(middleware.ContentType("image/jpeg"), middleware.Logging, authMiddleware)
+	feedLoggingMiddleware := middleware.Chain(middleware.ContentType("multipart/x-mixed-replace; boundary=frame"), middleware.Logging, authScopeMiddleware(apitokens.ScopeFeed))
+	snapshotLoggingMiddleware := middleware.Chain(middleware.ContentType("image/jpeg"), middleware.Logging, authScopeMiddleware(apitokens.ScopeFeed))
+	authLoggingJsonMiddleware := middleware.Chain(middleware.ContentType("application/json"), middleware.Logging, authMiddleware)
+	authLoggingVideoMiddleware := middleware.Chain(middleware.ContentType("video/x-msvideo"), middleware.Logging, authMiddleware)
+	authLoggingEventStreamMiddleware := middleware.Chain(middleware.ContentType("text/event-stream"), middleware.Logging, authMiddleware)
+	guestLoggingMiddleware := middleware.Chain(loggingMiddleware, s.requireShareLink(false))
+	guestLightLoggingMiddleware := middleware.Chain(loggingMiddleware, s.requireShareLink(true))
+	guestFeedLoggingMiddleware := middleware.Chain(middleware.ContentType("multipart/x-mixed-replace; boundary=frame"), middleware.Logging, s.requireShareLink(false))
+	guestEventStreamLoggingMiddleware := middleware.Chain(middleware.ContentType("text/event-stream"), middleware.Logging, s.requireShareLink(false))
 
 	// unprotected routes:
 	fileServer := http.FileServer(http.Dir("./static"))
@@ -94,34 +256,129 @@ func (s *server) Start() error {
 
 	router.Handle("GET /login", loggingMiddleware(http.HandlerFunc(s.loginFormHandler)))
 	router.Handle("POST /login", loggingMiddleware(http.HandlerFunc(s.loginHandler)))
+	router.Handle("POST /login/two-factor", loggingMiddleware(http.HandlerFunc(s.twoFactorLoginHandler)))
+	router.Handle("GET /setup", loggingMiddleware(http.HandlerFunc(s.setupFormHandler)))
+	router.Handle("POST /setup", loggingMiddleware(http.HandlerFunc(s.setupHandler)))
+
+	// guest routes, for anyone with a share link:
+	router.Handle("GET /guest/{token}", guestLoggingMiddleware(http.HandlerFunc(s.guestHandler)))
+	router.Handle("GET /guest/{token}/feed", guestFeedLoggingMiddleware(http.HandlerFunc(s.guestFeedHandler)))
+	router.Handle("GET /guest/{token}/events/stream", guestEventStreamLoggingMiddleware(http.HandlerFunc(s.guestStatusStreamHandler)))
+	router.Handle("POST /guest/{token}/toggle-light", guestLightLoggingMiddleware(http.HandlerFunc(s.toggleLightHandler)))
+	router.Handle("POST /guest/{token}/set-color", guestLightLoggingMiddleware(http.HandlerFunc(s.setColorHandler)))
 
 	// protected routes:
 	router.Handle("GET /", authLoggingMiddleware(http.HandlerFunc(s.homeHandler)))
+	router.Handle("GET /events/stream", authLoggingEventStreamMiddleware(http.HandlerFunc(s.statusStreamHandler)))
+
+	router.Handle("GET /logout", passwordChangeLoggingMiddleware(http.HandlerFunc(s.logoutHandler)))
+	router.Handle("POST /logout", passwordChangeLoggingMiddleware(http.HandlerFunc(s.logoutHandler)))
 
-	router.Handle("GET /logout", authLoggingMiddleware(http.HandlerFunc(s.logoutHandler)))
-	router.Handle("POST /logout", authLoggingMiddleware(http.HandlerFunc(s.logoutHandler)))
+	router.Handle("GET /sessions", authLoggingMiddleware(http.HandlerFunc(s.listSessionsHandler)))
+	router.Handle("DELETE /sessions/{id}", authLoggingMiddleware(http.HandlerFunc(s.revokeSessionHandler)))
+	router.Handle("POST /sessions/sign-out-everywhere", authLoggingMiddleware(http.HandlerFunc(s.signOutEverywhereHandler)))
 
-	router.Handle("POST /user", authLoggingMiddleware(http.HandlerFunc(s.addUserHandler)))
-	router.Handle("GET /user/add", authLoggingMiddleware(http.HandlerFunc(s.getUserFormHandler)))
-	router.Handle("DELETE /user/{id}", authLoggingMiddleware(http.HandlerFunc(s.deleteUserHandler)))
-	router.Handle("GET /users", authLoggingMiddleware(http.HandlerFunc(s.listUsersHandler)))
-	router.Handle("GET /user/{id}", authLoggingMiddleware(http.HandlerFunc(s.getUserHandler)))
+	router.Handle("GET /account/password", passwordChangeLoggingMiddleware(http.HandlerFunc(s.changePasswordFormHandler)))
+	router.Handle("POST /account/password", passwordChangeLoggingMiddleware(http.HandlerFunc(s.changePasswordHandler)))
 
-	router.Handle("GET /feed", authLoggingFeedMiddleware(http.HandlerFunc(s.feedHandler)))
+	router.Handle("GET /account/tokens", sessionLoggingMiddleware(http.HandlerFunc(s.listAPITokensHandler)))
+	router.Handle("POST /account/tokens", sessionLoggingMiddleware(http.HandlerFunc(s.addAPITokenHandler)))
+	router.Handle("DELETE /account/tokens/{id}", sessionLoggingMiddleware(http.HandlerFunc(s.revokeAPITokenHandler)))
 
-	router.Handle("POST /toggle-light", authLoggingMiddleware(http.HandlerFunc(s.toggleLightHandler)))
-	router.Handle("POST /set-color", authLoggingMiddleware(http.HandlerFunc(s.setColorHandler)))
+	router.Handle("GET /account/two-factor", authLoggingMiddleware(http.HandlerFunc(s.twoFactorHandler)))
+	router.Handle("POST /account/two-factor", authLoggingMiddleware(http.HandlerFunc(s.enableTwoFactorHandler)))
+	router.Handle("POST /account/two-factor/recovery-codes", authLoggingMiddleware(http.HandlerFunc(s.newRecoveryCodesHandler)))
+	router.Handle("DELETE /account/two-factor", authLoggingMiddleware(http.HandlerFunc(s.disableTwoFactorHandler)))
+
+	router.Handle("POST /user", adminLoggingMiddleware(http.HandlerFunc(s.addUserHandler)))
+	router.Handle("GET /user/add", adminLoggingMiddleware(http.HandlerFunc(s.getUserFormHandler)))
+	router.Handle("DELETE /user/{id}", adminLoggingMiddleware(http.HandlerFunc(s.deleteUserHandler)))
+	router.Handle("GET /users", adminLoggingMiddleware(http.HandlerFunc(s.listUsersHandler)))
+	router.Handle("GET /user/{id}", adminLoggingMiddleware(http.HandlerFunc(s.getUserHandler)))
+	router.Handle("PUT /user/{id}/role", adminLoggingMiddleware(http.HandlerFunc(s.setUserRoleHandler)))
+	router.Handle("POST /user/{id}/reset-password", adminLoggingMiddleware(http.HandlerFunc(s.resetPasswordHandler)))
+
+	router.Handle("GET /shares", adminLoggingMiddleware(http.HandlerFunc(s.listShareLinksHandler)))
+	router.Handle("POST /shares", adminLoggingMiddleware(http.HandlerFunc(s.addShareLinkHandler)))
+	router.Handle("DELETE /shares/{id}", adminLoggingMiddleware(http.HandlerFunc(s.revokeShareLinkHandler)))
+
+	router.Handle("GET /audit", adminLoggingMiddleware(http.HandlerFunc(s.listAuditHandler)))
+
+	router.Handle("GET /lockouts", adminLoggingMiddleware(http.HandlerFunc(s.listLockoutsHandler)))
+	router.Handle("DELETE /lockouts/{id}", adminLoggingMiddleware(http.HandlerFunc(s.unlockHandler)))
+
+	router.Handle("GET /feed", feedLoggingMiddleware(http.HandlerFunc(s.feedHandler)))
+	router.Handle("GET /snapshot", snapshotLoggingMiddleware(http.HandlerFunc(s.snapshotHandler)))
+
+	router.Handle("GET /events", authLoggingMiddleware(http.HandlerFunc(s.listEventsHandler)))
+	router.Handle("GET /api/events", authLoggingJsonMiddleware(http.HandlerFunc(s.listEventsJsonHandler)))
+	router.Handle("GET /events/{id}/thumbnail", authLoggingJpegMiddleware(http.HandlerFunc(s.eventThumbnailHandler)))
+	router.Handle("GET /events/{id}/player", authLoggingMiddleware(http.HandlerFunc(s.eventPlayerHandler)))
+	router.Handle("GET /events/{id}/play", authLoggingFeedMiddleware(http.HandlerFunc(s.playEventHandler)))
+
+	router.Handle("GET /timelapses", authLoggingMiddleware(http.HandlerFunc(s.listTimelapsesHandler)))
+	router.Handle("GET /timelapses/{id}/download", authLoggingVideoMiddleware(http.HandlerFunc(s.downloadTimelapseHandler)))
+
+	router.Handle("POST /toggle-light", lightLoggingMiddleware(http.HandlerFunc(s.toggleLightHandler)))
+	router.Handle("POST /set-color", lightLoggingMiddleware(http.HandlerFunc(s.setColorHandler)))
+	router.Handle("POST /set-animation", lightLoggingMiddleware(http.HandlerFunc(s.setAnimationHandler)))
+	router.Handle("GET /light/preview", authLoggingJsonMiddleware(http.HandlerFunc(s.animationPreviewHandler)))
+
+	return router
+}
+
+// Start the server
+func (s *server) Start() error {
+	s.logger.Printf("Starting server on port %d", s.port)
+	var stopChan chan os.Signal
 
 	// define server
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: router,
+		Handler: s.routes(),
+	}
+
+	// make sure someone can create the first user
+	if err := s.prepareSetup(context.Background()); err != nil {
+		return fmt.Errorf("error when checking for users: %w", err)
 	}
 
 	// create channel to listen for signals
 	stopChan = make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
+	// start background jobs, which run until the server shuts down
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	if s.recorder != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			s.recorder.Run(jobsCtx)
+		}()
+	}
+	if s.motionDetector != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			s.motionDetector.Run(jobsCtx, s.camera)
+		}()
+	}
+	if s.clipper != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			s.clipper.Run(jobsCtx)
+		}()
+	}
+	if s.timelapser != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			s.timelapser.Run(jobsCtx)
+		}()
+	}
+
 	go func() {
 		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			s.logger.Fatalf("Error when running server: %s", err)
@@ -130,6 +387,9 @@ func (s *server) Start() error {
 
 	<-stopChan
 
+	stopJobs()
+	jobs.Wait()
+
 	// Create a context with a timeout of 5 seconds
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -140,12 +400,40 @@ func (s *server) Start() error {
 	return nil
 }
 
+// The address of the client that made the request. Requests from a reverse proxy on this machine are
+// taken to be from the first address in X-Forwarded-For
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	return host
+}
+
 // A helper function to determine whether a request was made by HTMX, so we can use this to inform
 // whether the response should be a full layout page or just the partial content
 func isHtmxRequest(r *http.Request) bool {
 	return r.Header.Get("HX-Request") == "true"
 }
 
+// Redirect to target after a form is sent. Requests made by HTMX get a full page load, as following a
+// redirect would only swap the new page's content into the old one, without the CSRF token that comes
+// with the full layout
+func redirectAfterForm(w http.ResponseWriter, r *http.Request, target string) {
+	if isHtmxRequest(r) {
+		w.Header().Set("HX-Redirect", target)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
 // A helper function to respond with a template, either as a full page or just the partial content
 // depending on whether the request was made by HTMX and the HTML verb used (full pages only apply
 // to GET requests) the AppName to the title provided. If the template fails to render, a 500 error
@@ -165,7 +453,7 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, t templ.Component, t
 	}
 
 	// and render the full page
-	err := templates.Layout(t, title[0]).Render(r.Context(), w)
+	err := templates.Layout(t, title[0], middleware.CSRFToken(r.Context())).Render(r.Context(), w)
 	if err != nil {
 		log.Printf("Error when rendering: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -176,26 +464,34 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, t templ.Component, t
 func (s *server) homeHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
-	renderTemplate(w, r, templates.Home(s.light, s.camera), "Home")
+	user, _ := middleware.CurrentUser(r.Context())
+	renderTemplate(w, r, templates.Home(s.light, s.camera, s.cameraStatus(), users.Role(user.Role)), "Home")
 }
 
 // GET /login
 func (s *server) loginFormHandler(w http.ResponseWriter, r *http.Request) {
 	// Pass through if already logged in
-	if _, err := s.sessionStore.ValidateSession(r); err == nil {
+	if _, _, err := s.sessionStore.ValidateSession(r); err == nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	// Nobody can log in until the first user has been created
+	if s.setupPending() {
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+		return
+	}
+
 	renderTemplate(w, r, templates.LoginForm(nil), "Login")
 }
 
 // GET or POST /logout
 func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	s.auditRequest(r, audit.ActionLogout, "")
 
 	s.sessionStore.EraseCurrent(w, r)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	redirectAfterForm(w, r, "/")
 }
 
 // POST /user
@@ -209,6 +505,7 @@ func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	formUsername := r.FormValue("username")
 	formPassword := r.FormValue("password")
 	formConfirmPassword := r.FormValue("confirm-password")
+	formRole := r.FormValue("role")
 
 	validationErrors := make(map[string]string)
 	if formUsername == "" {
@@ -216,6 +513,8 @@ func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	if formPassword == "" {
 		validationErrors["password"] = "Password is required"
+	} else if msg := s.passwordPolicy.check(formPassword, formUsername); msg != "" {
+		validationErrors["password"] = msg
 	}
 	if formConfirmPassword == "" {
 		validationErrors["confirm-password"] = "Confirm password is required"
@@ -223,9 +522,12 @@ func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	if formPassword != formConfirmPassword {
 		validationErrors["confirm-password"] = "Passwords do not match"
 	}
+	if _, err := users.ParseRole(formRole); err != nil {
+		validationErrors["role"] = "Choose a role"
+	}
 	if len(validationErrors) > 0 {
 		w.WriteHeader(http.StatusUnprocessableEntity)
-		renderTemplate(w, r, templates.AddUserForm(db.User{Username: formUsername}, validationErrors))
+		renderTemplate(w, r, templates.AddUserForm(db.User{Username: formUsername, Role: formRole}, validationErrors))
 		return
 	}
 
@@ -242,6 +544,7 @@ func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := s.userStore.AddUser(context.Background(), db.AddUserParams{
 		Username:     formUsername,
 		PasswordHash: string(passwordHash),
+		Role:         formRole,
 	})
 	if err != nil {
 		errMsg := fmt.Sprintf("Error when adding user: %v", err)
@@ -249,13 +552,14 @@ func (s *server) addUserHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, errMsg, http.StatusInternalServerError)
 		return
 	}
+	s.auditRequest(r, audit.ActionUserAdded, fmt.Sprintf("%s as %s", user.Username, user.Role))
 
 	renderTemplate(w, r, templates.UserToAppend(user))
 }
 
 // GET /user/add
 func (s *server) getUserFormHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, r, templates.AddUserForm(db.User{}, nil), "Add User")
+	renderTemplate(w, r, templates.AddUserForm(db.User{Role: string(users.RoleViewer)}, nil), "Add User")
 }
 
 // DELETE /user/{id}
@@ -268,13 +572,22 @@ func (s *server) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, errMsg, http.StatusInternalServerError)
 		return
 	}
-	_, err = s.userStore.DeleteUser(r.Context(), int64(id))
+	deleted, err := s.userStore.DeleteUser(r.Context(), int64(id))
 	if err != nil {
+		if _, ok := err.(users.ErrLastAdmin); ok {
+			// Show why next to the delete button rather than replacing the user
+			w.Header().Set("HX-Retarget", fmt.Sprintf("#delete-response-%d", id))
+			w.Header().Set("HX-Reswap", "innerHTML")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Can't delete the last admin"))
+			return
+		}
 		errMsg := fmt.Sprintf("Error when deleting user: %v", err)
 		s.logger.Print(errMsg)
 		http.Error(w, errMsg, http.StatusInternalServerError)
 		return
 	}
+	s.auditRequest(r, audit.ActionUserDeleted, deleted.Username)
 
 	// Check if that was the last user
 	numUsers, err := s.userStore.CountUsers(r.Context())
@@ -286,11 +599,15 @@ func (s *server) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if numUsers == 0 {
-		// If we just deleted the last user, render the no users template
+		// If we just deleted the last user, let someone set CatCam up again and render the no users
+		// template
+		if err := s.prepareSetup(r.Context()); err != nil {
+			s.logger.Printf("Error when preparing setup: %v", err)
+		}
 		renderTemplate(w, r, templates.NoUsers())
 	} else {
 		// Return nothing so the target of the delete request is replaced with nothing, i.e. removed
-		w.WriteHeader(http.StatusNoContent)
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -307,6 +624,53 @@ func (s *server) listUsersHandler(w http.ResponseWriter, r *http.Request) {
 	renderTemplate(w, r, templates.UsersList(users, s.userStore), "Users")
 }
 
+// PUT /user/{id}/role
+func (s *server) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid user id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	role, err := users.ParseRole(r.FormValue("role"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.userStore.SetUserRole(r.Context(), int64(id), role)
+	errMsg := ""
+	if err != nil {
+		switch err.(type) {
+		case users.ErrLastAdmin:
+			w.WriteHeader(http.StatusConflict)
+			errMsg = "There must be at least one admin"
+		case users.ErrUserNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			errMsg := fmt.Sprintf("Error when setting user role: %v", err)
+			s.logger.Print(errMsg)
+			http.Error(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user, err := s.userStore.GetUserById(r.Context(), int64(id))
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting user: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templates.UserRole(user, errMsg))
+}
+
 // GET /user/{id}
 func (s *server) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
@@ -352,12 +716,33 @@ func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Make password guessers wait longer and longer between tries
+	username := strings.ToLower(formUsername)
+	ip := clientIP(r)
+	wait, err := s.loginWait(r.Context(), username, ip)
+	if err != nil {
+		s.logger.Printf("Error when checking failed logins: %v", err)
+		validationErrors["password"] = "Internal server error"
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, templates.LoginForm(validationErrors))
+		return
+	}
+	if wait > 0 {
+		s.recordAudit(r.Context(), audit.ActionLoginFailed, loginActor(r, db.User{Username: username}), "Too many attempts")
+		validationErrors["password"] = setRetryAfter(w, wait)
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderTemplate(w, r, templates.LoginForm(validationErrors))
+		return
+	}
+
 	// Check if the user exists
-	user, err := s.userStore.GetUserByUsername(r.Context(), strings.ToLower(formUsername))
+	user, err := s.userStore.GetUserByUsername(r.Context(), username)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error when getting user by username: %v", err)
 		switch err.(type) {
 		case users.ErrUserNotFound:
+			s.recordLoginFailure(r.Context(), username, ip)
+			s.recordAudit(r.Context(), audit.ActionLoginFailed, loginActor(r, db.User{Username: username}), "No such user")
 			validationErrors["password"] = "Username or password is incorrect"
 			w.WriteHeader(http.StatusUnauthorized)
 		default:
@@ -372,12 +757,37 @@ func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if the password is correct
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(formPassword))
 	if err != nil {
+		s.recordLoginFailure(r.Context(), username, ip)
+		s.recordAudit(r.Context(), audit.ActionLoginFailed, loginActor(r, user), "Wrong password")
 		validationErrors["password"] = "Username or password is incorrect"
 		w.WriteHeader(http.StatusUnauthorized)
 		renderTemplate(w, r, templates.LoginForm(validationErrors))
 		return
 	}
 
+	// Users with two-factor authentication still have to give a code before they're logged in
+	hasTotp, err := s.twoFactorStore.HasTotp(r.Context(), user.ID)
+	if err != nil {
+		s.logger.Printf("Error when checking for two-factor authentication: %v", err)
+		validationErrors["password"] = "Internal server error"
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, templates.LoginForm(validationErrors))
+		return
+	}
+	if hasTotp {
+		if err := s.sessionStore.WritePending(w, r, user.ID); err != nil {
+			s.logger.Printf("Error when saving pending session: %v", err)
+			validationErrors["password"] = "Internal server error"
+			w.WriteHeader(http.StatusInternalServerError)
+			renderTemplate(w, r, templates.LoginForm(validationErrors))
+			return
+		}
+		renderTemplate(w, r, templates.TwoFactorLoginForm(nil))
+		return
+	}
+
+	s.clearLoginFailures(r.Context(), username, ip)
+
 	// Generate a session token
 	err = s.sessionStore.WriteNew(w, r, user.ID)
 
@@ -391,13 +801,25 @@ func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.userStore.SetUserLastLogin(r.Context(), user.ID)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	s.recordAudit(r.Context(), audit.ActionLoginSucceeded, loginActor(r, user), "")
+	redirectAfterForm(w, r, "/")
 }
 
+// How often a feed checks whoever's watching is still allowed to, e.g. that their session or share
+// link hasn't been revoked. A variable so tests don't have to wait as long
+var feedRecheckInterval = 10 * time.Second
+
 // GET /feed
 func (s *server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	// Cut the viewer off once they're logged out, or their API token is revoked
+	s.streamFeed(w, r, s.stillLoggedIn(r))
+}
+
+// Stream the camera to the client. If stillAllowed is given, it's checked every so often and the
+// stream ends once it returns false
+func (s *server) streamFeed(w http.ResponseWriter, r *http.Request, stillAllowed func() bool) {
 	if !s.camera.IsRunning() {
-		err := s.camera.Start()
+		err := s.camera.Start(cameraClient(r))
 		if err != nil {
 			s.logger.Printf("Couldn't start camera: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -410,22 +832,88 @@ func (s *server) feedHandler(w http.ResponseWriter, r *http.Request) {
 
 	clientStream := s.camera.Subscribe()
 	defer s.camera.Unsubscribe(clientStream)
-
-	for buf := range clientStream {
-		err := s.sendFrame(w, buf)
-		if err != nil {
-			s.logger.Printf("Error when sending frame: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+	s.feedViewers.Add(1)
+	defer s.feedViewers.Add(-1)
+
+	// Check on a ticker rather than with each frame, so revoked viewers are cut off (and viewers who
+	// have gone are noticed) even while the camera isn't sending anything
+	var recheck <-chan time.Time
+	if stillAllowed != nil {
+		ticker := time.NewTicker(feedRecheckInterval)
+		defer ticker.Stop()
+		recheck = ticker.C
+	}
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-recheck:
+			if !stillAllowed() {
+				return
+			}
+		case buf, ok := <-clientStream:
+			if !ok {
+				return
+			}
+			err := s.sendFrame(w, buf)
+			if err != nil {
+				s.logger.Printf("Error when sending frame: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
 		}
 	}
 }
 
+// GET /snapshot
+func (s *server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	frame, err := s.camera.Snapshot(cameraClient(r), 5*time.Second)
+	if err != nil {
+		s.logger.Printf("Couldn't get snapshot: %v", err)
+		http.Error(w, "Camera unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(frame)))
+	if r.URL.Query().Get("download") == "1" {
+		filename := fmt.Sprintf("catcam-%s.jpg", time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(frame)
+}
+
+// Responds to requests without the right CSRF token, showing why at the top of the page
+func (s *server) csrfRejectedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("HX-Retarget", "#request-error")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	w.WriteHeader(http.StatusForbidden)
+	renderTemplate(w, r, templates.CSRFRejected())
+}
+
+// Log every change to the light, whether it came from a request or e.g. the camera stopping
+func (s *server) logLightChanges(changes chan states.LightState) {
+	for state := range changes {
+		s.logger.Printf("Light is now %s", state)
+	}
+}
+
 // POST /toggle-light
 func (s *server) toggleLightHandler(w http.ResponseWriter, r *http.Request) {
-	s.light.Toggle()
+	isOn := s.light.Toggle()
+	if isOn {
+		s.auditRequest(r, audit.ActionLightToggled, "On")
+	} else {
+		s.auditRequest(r, audit.ActionLightToggled, "Off")
+	}
+
 	w.WriteHeader(http.StatusOK)
-	if s.light.IsOn() {
+	if isOn {
 		w.Write([]byte("Light off")) // We are telling the button what its new text should be
 	} else {
 		w.Write([]byte("Light on"))
@@ -434,9 +922,40 @@ func (s *server) toggleLightHandler(w http.ResponseWriter, r *http.Request) {
 
 // POST /set-color
 func (s *server) setColorHandler(w http.ResponseWriter, r *http.Request) {
-	s.light.FromHex(r.FormValue("color"))
+	if err := s.light.FromHex(r.FormValue("color")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.auditRequest(r, audit.ActionColorChanged, s.light.Hex())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /set-animation
+func (s *server) setAnimationHandler(w http.ResponseWriter, r *http.Request) {
+	mode, err := states.ParseAnimationMode(r.FormValue("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	speed, err := strconv.ParseFloat(r.FormValue("speed"), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid speed: %q", r.FormValue("speed")), http.StatusBadRequest)
+		return
+	}
+	brightness, err := strconv.ParseFloat(r.FormValue("brightness"), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid brightness: %q", r.FormValue("brightness")), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.light.SetAnimation(states.Animation{Mode: mode, Speed: speed, Brightness: brightness}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	s.logger.Printf("Set light color: %v", s.light)
+	s.auditRequest(r, audit.ActionAnimationSet, fmt.Sprintf("%s at %gx speed, %g%% brightness", mode.Label(), speed, brightness*100))
 
 	w.WriteHeader(http.StatusNoContent)
 }
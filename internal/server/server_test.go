@@ -0,0 +1,126 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/states"
+	"catcam_go/internal/store/apitokens"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/events"
+	"catcam_go/internal/store/lockouts"
+	"catcam_go/internal/store/recordings"
+	sessionstore "catcam_go/internal/store/sessions"
+	"catcam_go/internal/store/sharelinks"
+	"catcam_go/internal/store/timelapses"
+	"catcam_go/internal/store/twofactor"
+	"catcam_go/internal/store/users"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// Make a server backed by a fresh database, with a test pattern camera and fake LEDs, along with its
+// routes
+func newTestServer(t *testing.T) (*server, http.Handler) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	t.Setenv("SESSION_KEY", base64.StdEncoding.EncodeToString(key))
+	t.Setenv("CAMERA_SOURCE", "testpattern")
+	t.Setenv("LED_DRIVER", "fake")
+
+	dbPool, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { dbPool.Close() })
+
+	logger := log.New(io.Discard, "", 0)
+	if err := db.Migrate(context.Background(), dbPool, logger); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+
+	queries := db.New(dbPool)
+	s, err := NewServer(logger, 0, users.NewUserStore(queries, logger), recordings.NewRecordingStore(queries, logger), events.NewEventStore(queries, logger), timelapses.NewTimelapseStore(queries, logger), sessionstore.NewSessionStore(queries, logger), lockouts.NewLockoutStore(queries, logger), twofactor.NewTwoFactorStore(queries, logger), apitokens.NewAPITokenStore(queries, logger), sharelinks.NewShareLinkStore(queries, logger), audit.NewAuditStore(queries, logger))
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	t.Cleanup(func() { s.camera.Stop(states.CameraClient{Name: "test"}) })
+	return s, s.routes()
+}
+
+func addTestUser(t *testing.T, s *server, username, password string, role users.Role) db.User {
+	t.Helper()
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+	user, err := s.userStore.AddUser(context.Background(), db.AddUserParams{Username: username, PasswordHash: string(passwordHash), Role: string(role)})
+	if err != nil {
+		t.Fatalf("adding user: %v", err)
+	}
+	return user
+}
+
+// Send a request through the routes, with the session cookie (and its CSRF token) if given
+func doRequest(t *testing.T, s *server, routes http.Handler, method, target string, form url.Values, session *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	r := httptest.NewRequest(method, target, body)
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if session != nil {
+		r.AddCookie(session)
+		r.Header.Set(middleware.CSRFHeader, s.sessionStore.CSRFToken(r))
+	}
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	return w
+}
+
+// The session cookie a response set, if any
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Whether a browser would send a cookie set by a response to setBy along with a request to target.
+// Without a Path, cookies only go to the directory of the URL that set them
+func cookieSentTo(cookie *http.Cookie, setBy, target string) bool {
+	cookiePath := cookie.Path
+	if cookiePath == "" {
+		cookiePath = path.Dir(setBy)
+	}
+	return target == cookiePath || strings.HasPrefix(target, strings.TrimSuffix(cookiePath, "/")+"/")
+}
+
+func login(t *testing.T, s *server, routes http.Handler, username, password string) *http.Cookie {
+	t.Helper()
+	w := doRequest(t, s, routes, http.MethodPost, "/login", url.Values{"username": {username}, "password": {password}}, nil)
+	cookie := sessionCookie(w)
+	if cookie == nil {
+		t.Fatalf("logging in as %s: no session cookie (status %d)", username, w.Code)
+	}
+	return cookie
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"catcam_go/internal/middleware"
+	sessionstore "catcam_go/internal/store/sessions"
+	"catcam_go/internal/store/tokens"
+	"catcam_go/internal/templates"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// How long a session can go unused before last_seen is updated again, to save writing to the
+// database on every request
+const sessionTouchInterval = time.Minute
+
+// sqliteSessionStore is a sessions.Store that keeps sessions in the database, so they can be listed
+// and revoked. The cookie only holds a signed random token identifying the session
+type sqliteSessionStore struct {
+	store   *sessionstore.SessionStore
+	codecs  []securecookie.Codec
+	options *sessions.Options
+}
+
+// The options for a session cookie lasting maxAge seconds. Every session cookie has to be set with the
+// same domain and path, or the one clearing it when logging out wouldn't replace the one logged in with
+func sessionCookieOptions(maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		Domain:   "tbat.me",
+		MaxAge:   maxAge,
+		HttpOnly: true, // JS cannot access the cookie
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func newSqliteSessionStore(store *sessionstore.SessionStore, keyPairs ...[]byte) *sqliteSessionStore {
+	return &sqliteSessionStore{
+		store:   store,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: sessionCookieOptions(3600),
+	}
+}
+
+// Get returns the request's session, only loading it from the database once per request
+func (s *sqliteSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named in the request's cookie. If there's no cookie, or it doesn't name a
+// live session, a new empty session is returned instead
+func (s *sqliteSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	record, err := s.store.GetSessionByToken(r.Context(), token)
+	if err != nil {
+		if _, ok := err.(sessionstore.ErrSessionNotFound); ok {
+			return session, nil
+		}
+		return session, err
+	}
+	if err := (securecookie.GobEncoder{}).Deserialize(record.Data, &session.Values); err != nil {
+		return session, fmt.Errorf("error decoding session %d: %w", record.ID, err)
+	}
+	session.ID = token
+	session.IsNew = false
+
+	if time.Since(record.LastSeen) >= sessionTouchInterval {
+		s.store.TouchSession(r.Context(), record.ID, clientIP(r), r.UserAgent())
+	}
+	return session, nil
+}
+
+// Save writes the session to the database and sets the cookie, or deletes both if the session's
+// MaxAge is negative
+func (s *sqliteSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if record, err := s.store.GetSessionByToken(r.Context(), session.ID); err == nil {
+				if err := s.store.DeleteSession(r.Context(), record.ID); err != nil {
+					return err
+				}
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	userId, _ := session.Values["userId"].(int64)
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	saved := false
+	if session.ID != "" {
+		if record, err := s.store.GetSessionByToken(r.Context(), session.ID); err == nil {
+			if err := s.store.UpdateSession(r.Context(), record.ID, userId, data, clientIP(r), r.UserAgent(), expiresAt); err != nil {
+				return err
+			}
+			saved = true
+		}
+	}
+	if !saved {
+		// Tidy up while we're making a new one
+		s.store.DeleteExpiredSessions(r.Context())
+
+		tokenBytes := make([]byte, 32)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			return err
+		}
+		session.ID = hex.EncodeToString(tokenBytes)
+		if _, err := s.store.AddSession(r.Context(), session.ID, userId, data, clientIP(r), r.UserAgent(), expiresAt); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Returns whether the session (or API token) the request came with is still good, looking it up again
+// each time rather than going by what was loaded when the request started, for streams that outlast
+// logging out or having it revoked
+func (s *server) stillLoggedIn(r *http.Request) func() bool {
+	if apiToken, ok := middleware.BearerToken(r); ok {
+		return func() bool {
+			_, err := s.apiTokenStore.GetTokenByToken(context.Background(), apiToken)
+			return err == nil
+		}
+	}
+	sessionToken := s.sessionStore.CurrentToken(r)
+	return func() bool {
+		_, err := s.sessionRecords.GetSessionByToken(context.Background(), sessionToken)
+		return err == nil
+	}
+}
+
+// GET /sessions
+func (s *server) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	userSessions, err := s.sessionRecords.GetSessionsForUser(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting sessions: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	currentTokenHash := tokens.Hash(s.sessionStore.CurrentToken(r))
+	renderTemplate(w, r, templates.Sessions(userSessions, currentTokenHash), "Sessions")
+}
+
+// DELETE /sessions/{id}
+func (s *server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid session id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	// Revoking this very session is just logging out
+	current, err := s.sessionRecords.GetSessionByToken(r.Context(), s.sessionStore.CurrentToken(r))
+	isCurrent := err == nil && current.ID == int64(id)
+
+	user, _ := middleware.CurrentUser(r.Context())
+	err = s.sessionRecords.DeleteSessionForUser(r.Context(), int64(id), user.ID)
+	if err != nil {
+		if _, ok := err.(sessionstore.ErrSessionNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when revoking session: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	if isCurrent {
+		s.sessionStore.EraseCurrent(w, r)
+		w.Header().Set("HX-Redirect", "/login")
+	}
+
+	// Return nothing so the revoked session is removed from the list
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /sessions/sign-out-everywhere
+func (s *server) signOutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	if err := s.sessionRecords.DeleteSessionsForUser(r.Context(), user.ID); err != nil {
+		errMsg := fmt.Sprintf("Error when signing out everywhere: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("Signed %s out everywhere", user.Username)
+
+	s.sessionStore.EraseCurrent(w, r)
+	redirectAfterForm(w, r, "/login")
+}
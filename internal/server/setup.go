@@ -0,0 +1,134 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/users"
+	"catcam_go/internal/templates"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Check whether there are any users yet. If not, make up a one-time setup token and print it to the
+// log, so only whoever can see the server's output can create the first account
+func (s *server) prepareSetup(ctx context.Context) error {
+	numUsers, err := s.userStore.CountUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	if numUsers > 0 {
+		s.setupToken = ""
+		return nil
+	}
+	if s.setupToken != "" {
+		return nil
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return err
+	}
+	s.setupToken = hex.EncodeToString(tokenBytes)
+	s.logger.Printf("There are no users yet. Create the first one at http://localhost:%d/setup?token=%s", s.port, s.setupToken)
+	return nil
+}
+
+// Whether the first user still needs to be created
+func (s *server) setupPending() bool {
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+	return s.setupToken != ""
+}
+
+// GET /setup
+func (s *server) setupFormHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.setupPending() {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	renderTemplate(w, r, templates.SetupForm(r.URL.Query().Get("token"), "", nil), "Setup")
+}
+
+// POST /setup
+func (s *server) setupHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formToken := r.FormValue("token")
+	formUsername := r.FormValue("username")
+	formPassword := r.FormValue("password")
+	formConfirmPassword := r.FormValue("confirm-password")
+
+	// Hold the lock throughout so two people can't both create the first user
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	if s.setupToken == "" {
+		http.Error(w, "CatCam has already been set up", http.StatusNotFound)
+		return
+	}
+
+	validationErrors := make(map[string]string)
+	if subtle.ConstantTimeCompare([]byte(formToken), []byte(s.setupToken)) != 1 {
+		validationErrors["token"] = "Setup token is incorrect. Check the server log for the right one"
+	}
+	if formUsername == "" {
+		validationErrors["username"] = "Username is required"
+	}
+	if formPassword == "" {
+		validationErrors["password"] = "Password is required"
+	} else if msg := s.passwordPolicy.check(formPassword, formUsername); msg != "" {
+		validationErrors["password"] = msg
+	}
+	if formPassword != formConfirmPassword {
+		validationErrors["confirm-password"] = "Passwords do not match"
+	}
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.SetupForm(formToken, formUsername, validationErrors))
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(formPassword), bcrypt.DefaultCost)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when hashing password: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userStore.AddUser(r.Context(), db.AddUserParams{
+		Username:     formUsername,
+		PasswordHash: string(passwordHash),
+		Role:         string(users.RoleAdmin),
+	})
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when adding user: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.setupToken = ""
+	s.logger.Printf("Setup finished, created user %s", user.Username)
+
+	// Log straight in as the new user
+	if err := s.sessionStore.WriteNew(w, r, user.ID); err != nil {
+		s.logger.Printf("Error when saving session: %v", err)
+		redirectAfterForm(w, r, "/login")
+		return
+	}
+	s.userStore.SetUserLastLogin(r.Context(), user.ID)
+	redirectAfterForm(w, r, "/")
+}
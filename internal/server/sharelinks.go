@@ -0,0 +1,242 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/sharelinks"
+	"catcam_go/internal/templates"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long share links can last, for the picker
+var shareLinkDurations = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+type contextKey string
+
+// The share link (a db.ShareLink) a guest's request came with is attached to the request context
+// under this key by requireShareLink
+const shareLinkContextKey contextKey = "shareLink"
+
+// The token in a share link: its ID and a signature over the ID and expiry, so links can't be made
+// up or extended
+func (s *server) shareLinkToken(link db.ShareLink) string {
+	mac := hmac.New(sha256.New, s.shareKey)
+	fmt.Fprintf(mac, "share-link:%d:%d", link.ID, link.ExpiresAt.Unix())
+	return fmt.Sprintf("%d.%s", link.ID, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// The full URL of a share link, to send to guests
+func (s *server) shareLinkURL(r *http.Request, link db.ShareLink) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/guest/%s", scheme, r.Host, s.shareLinkToken(link))
+}
+
+// Find the share link for a token, returning ErrShareLinkNotFound if the token is forged or the link
+// has expired or been revoked
+func (s *server) shareLinkFromToken(ctx context.Context, token string) (db.ShareLink, error) {
+	idStr, _, _ := strings.Cut(token, ".")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return db.ShareLink{}, sharelinks.ErrShareLinkNotFound{}
+	}
+
+	link, err := s.shareLinkStore.GetShareLink(ctx, id)
+	if err != nil {
+		return db.ShareLink{}, err
+	}
+	if !hmac.Equal([]byte(token), []byte(s.shareLinkToken(link))) {
+		return db.ShareLink{}, sharelinks.ErrShareLinkNotFound{ID: id}
+	}
+	return link, nil
+}
+
+// Only let through guests with a working share link in the path, attaching the link to the request
+// context. If light is true, the link must also allow controlling the light
+func (s *server) requireShareLink(light bool) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			link, err := s.shareLinkFromToken(r.Context(), r.PathValue("token"))
+			if err != nil {
+				if _, ok := err.(sharelinks.ErrShareLinkNotFound); !ok {
+					s.logger.Printf("Error when checking share link: %v", err)
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusNotFound)
+				renderTemplate(w, r, templates.ShareLinkInvalid(), "Link expired")
+				return
+			}
+			if light && !link.AllowLight {
+				http.Error(w, "This link can't control the light", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), shareLinkContextKey, link)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Count another guest watching with the link, unless it already has as many as it allows
+func (s *server) addShareViewer(link db.ShareLink) bool {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	if link.MaxViewers > 0 && int64(s.shareViewers[link.ID]) >= link.MaxViewers {
+		return false
+	}
+	s.shareViewers[link.ID]++
+	return true
+}
+
+func (s *server) removeShareViewer(id int64) {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	s.shareViewers[id]--
+	if s.shareViewers[id] <= 0 {
+		delete(s.shareViewers, id)
+	}
+}
+
+// How many guests are watching with each link
+func (s *server) shareViewerCounts() map[int64]int {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	counts := make(map[int64]int, len(s.shareViewers))
+	for id, count := range s.shareViewers {
+		counts[id] = count
+	}
+	return counts
+}
+
+// GET /guest/{token}
+func (s *server) guestHandler(w http.ResponseWriter, r *http.Request) {
+	link := r.Context().Value(shareLinkContextKey).(db.ShareLink)
+	renderTemplate(w, r, templates.Guest(link, s.shareLinkToken(link), s.light, s.camera, s.cameraStatus()), "Guest")
+}
+
+// GET /guest/{token}/feed
+func (s *server) guestFeedHandler(w http.ResponseWriter, r *http.Request) {
+	link := r.Context().Value(shareLinkContextKey).(db.ShareLink)
+	if !s.addShareViewer(link) {
+		http.Error(w, "Too many people are watching with this link. Try again later", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.removeShareViewer(link.ID)
+
+	// Cut the guest off once the link expires or is revoked
+	s.streamFeed(w, r, func() bool {
+		_, err := s.shareLinkStore.GetShareLink(context.Background(), link.ID)
+		return err == nil
+	})
+}
+
+// GET /guest/{token}/events/stream
+func (s *server) guestStatusStreamHandler(w http.ResponseWriter, r *http.Request) {
+	link := r.Context().Value(shareLinkContextKey).(db.ShareLink)
+
+	// Cut the guest off once the link expires or is revoked
+	s.streamStatus(w, r, func() bool {
+		_, err := s.shareLinkStore.GetShareLink(context.Background(), link.ID)
+		return err == nil
+	})
+}
+
+// GET /shares
+func (s *server) listShareLinksHandler(w http.ResponseWriter, r *http.Request) {
+	links, err := s.shareLinkStore.GetShareLinks(r.Context())
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting share links: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	viewers := s.shareViewerCounts()
+	var rows []templates.ShareLinkRow
+	for _, link := range links {
+		if !link.ExpiresAt.After(now) {
+			continue
+		}
+		rows = append(rows, templates.ShareLinkRow{Link: link, URL: s.shareLinkURL(r, link), Viewers: viewers[link.ID]})
+	}
+
+	renderTemplate(w, r, templates.ShareLinks(rows, shareLinkDurations), "Share links")
+}
+
+// POST /shares
+func (s *server) addShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formName := r.FormValue("name")
+	formDuration := r.FormValue("duration")
+	formMaxViewers := r.FormValue("max-viewers")
+	formAllowLight := r.FormValue("allow-light")
+
+	validationErrors := make(map[string]string)
+	if formName == "" {
+		validationErrors["name"] = "Name is required"
+	}
+	duration, err := time.ParseDuration(formDuration)
+	if err != nil || duration <= 0 || duration > shareLinkDurations[len(shareLinkDurations)-1] {
+		validationErrors["duration"] = "Choose how long the link lasts"
+	}
+	maxViewers, err := strconv.ParseInt(formMaxViewers, 10, 64)
+	if err != nil || maxViewers < 0 {
+		validationErrors["max-viewers"] = "Enter how many guests can watch at once, or 0 for no limit"
+	}
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.ShareLinkForm(shareLinkDurations, validationErrors))
+		return
+	}
+
+	user, _ := middleware.CurrentUser(r.Context())
+	link, err := s.shareLinkStore.AddShareLink(r.Context(), formName, user.ID, time.Now().Add(duration), maxViewers, formAllowLight == "on")
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when adding share link: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("%s shared the feed with %s until %s", user.Username, link.Name, link.ExpiresAt.Local().Format(time.DateTime))
+
+	row := templates.ShareLinkRow{Link: link, URL: s.shareLinkURL(r, link)}
+	renderTemplate(w, r, templates.NewShareLink(row, shareLinkDurations))
+}
+
+// DELETE /shares/{id}
+func (s *server) revokeShareLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid share link id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.shareLinkStore.DeleteShareLink(r.Context(), int64(id)); err != nil {
+		if _, ok := err.(sharelinks.ErrShareLinkNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when revoking share link: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Return nothing so the revoked link is removed from the list
+	w.WriteHeader(http.StatusOK)
+}
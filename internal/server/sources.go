@@ -0,0 +1,149 @@
+package server
+
+import (
+	"catcam_go/internal/states"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Build the camera's frame source from the CAMERA_SOURCE environment variable (and whichever other
+// variable that source needs), so the same binary can run on different hardware
+func frameSourceFromEnv() (states.FrameSource, error) {
+	switch source := os.Getenv("CAMERA_SOURCE"); source {
+	case "":
+		// Fall back to the test pattern so the app still works on machines without a Pi camera
+		if _, err := exec.LookPath("rpicam-vid"); err != nil {
+			log.Printf("rpicam-vid not found and CAMERA_SOURCE not set, using the test pattern")
+			return states.TestPatternSource{}, nil
+		}
+		return states.RpicamSource{}, nil
+
+	case "rpicam":
+		return states.RpicamSource{}, nil
+
+	case "testpattern":
+		return states.TestPatternSource{}, nil
+
+	case "ffmpeg":
+		device := os.Getenv("CAMERA_DEVICE")
+		if device == "" {
+			device = "/dev/video0"
+		}
+		return states.FFmpegSource{Device: device}, nil
+
+	case "command":
+		fields := strings.Fields(os.Getenv("CAMERA_COMMAND"))
+		if len(fields) <= 0 {
+			return nil, fmt.Errorf("CAMERA_COMMAND is required when CAMERA_SOURCE is command")
+		}
+		return states.CommandSource{Name: fields[0], Args: fields[1:]}, nil
+
+	case "http":
+		url := os.Getenv("CAMERA_URL")
+		if url == "" {
+			return nil, fmt.Errorf("CAMERA_URL is required when CAMERA_SOURCE is http")
+		}
+		return states.HTTPSource{URL: url}, nil
+
+	case "directory":
+		dir := os.Getenv("CAMERA_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("CAMERA_DIR is required when CAMERA_SOURCE is directory")
+		}
+		return states.DirectorySource{Dir: dir}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown CAMERA_SOURCE %q, expected one of rpicam, ffmpeg, command, http, directory or testpattern", source)
+	}
+}
+
+// Read the camera's capture settings from the environment, falling back to the given defaults for
+// any that aren't set
+func captureSettingsFromEnv(defaults states.CaptureSettings) (states.CaptureSettings, error) {
+	settings := defaults
+	for name, value := range map[string]*int{
+		"CAMERA_WIDTH":   &settings.Width,
+		"CAMERA_HEIGHT":  &settings.Height,
+		"CAMERA_FPS":     &settings.Fps,
+		"CAMERA_QUALITY": &settings.Quality,
+	} {
+		str := os.Getenv(name)
+		if str == "" {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil || n <= 0 {
+			return settings, fmt.Errorf("%s must be a positive integer, got %q", name, str)
+		}
+		*value = n
+	}
+	return settings, nil
+}
+
+// Build the LED driver from the LED_DRIVER environment variable (and whichever others that driver
+// needs). Also returns how many LEDs there are
+func ledDriverFromEnv() (states.LEDDriver, int, error) {
+	pin := os.Getenv("LED_PIN")
+	if pin == "" {
+		pin = "D14"
+	}
+	count, err := envInt("LED_COUNT", 24)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count <= 0 {
+		return nil, 0, fmt.Errorf("LED_COUNT must be greater than zero")
+	}
+	numPixels := int(count)
+	script := os.Getenv("LED_SCRIPT")
+	if script == "" {
+		script = "./scripts/control_leds.py"
+	}
+	// Use the scripts' virtual environment if there is one
+	python := os.Getenv("LED_PYTHON")
+	if python == "" {
+		python = "./scripts/.venv/bin/python"
+		if _, err := os.Stat(python); err != nil {
+			python = "python3"
+		}
+	}
+
+	helper := func() *states.HelperLEDDriver {
+		fields := strings.Fields(os.Getenv("LED_HELPER_COMMAND"))
+		if len(fields) <= 0 {
+			fields = []string{python, script, pin, strconv.Itoa(numPixels), "serve"}
+		}
+		return &states.HelperLEDDriver{Name: fields[0], Args: fields[1:]}
+	}
+	// The fake driver plays animations with the Go engine, so they can be previewed like the real thing
+	fake := &states.EngineLEDDriver{Sink: &states.FakeLEDDriver{NumPixels: numPixels}, NumPixels: numPixels}
+
+	switch driver := os.Getenv("LED_DRIVER"); driver {
+	case "":
+		// Fall back to the fake driver so the app still works on machines without Python
+		if _, err := exec.LookPath(python); err != nil {
+			log.Printf("%s not found and LED_DRIVER not set, using the fake LED driver", python)
+			return fake, numPixels, nil
+		}
+		return &states.ScriptLEDDriver{Python: python, Script: script, Pin: pin, NumPixels: numPixels}, numPixels, nil
+
+	case "script":
+		return &states.ScriptLEDDriver{Python: python, Script: script, Pin: pin, NumPixels: numPixels}, numPixels, nil
+
+	case "helper":
+		return helper(), numPixels, nil
+
+	case "engine":
+		return &states.EngineLEDDriver{Sink: helper(), NumPixels: numPixels}, numPixels, nil
+
+	case "fake":
+		return fake, numPixels, nil
+
+	default:
+		return nil, 0, fmt.Errorf("unknown LED_DRIVER %q, expected one of script, helper, engine or fake", driver)
+	}
+}
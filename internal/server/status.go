@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"catcam_go/internal/states"
+	"catcam_go/internal/templates"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// How often the status stream checks whether the camera has changed
+const statusCheckInterval = time.Second
+
+// How long the status stream can go quiet before sending a comment, so proxies don't close it
+const statusKeepAliveInterval = 15 * time.Second
+
+// What the camera is doing now, as shown on the home and guest pages
+func (s *server) cameraStatus() templates.CameraStatus {
+	return templates.CameraStatus{
+		Running:   s.camera.IsRunning(),
+		Viewers:   int(s.feedViewers.Load()),
+		FrameRate: s.camera.FrameRate(),
+	}
+}
+
+// Send a server-sent event, with comp rendered as its data
+func sendEvent(ctx context.Context, w io.Writer, name string, comp templ.Component) error {
+	var data bytes.Buffer
+	if err := comp.Render(ctx, &data); err != nil {
+		return err
+	}
+
+	var event strings.Builder
+	fmt.Fprintf(&event, "event: %s\n", name)
+	for _, line := range strings.Split(data.String(), "\n") {
+		fmt.Fprintf(&event, "data: %s\n", line)
+	}
+	event.WriteString("\n")
+	_, err := io.WriteString(w, event.String())
+	return err
+}
+
+// Send the events the pages update the light controls from
+func sendLightEvents(ctx context.Context, w io.Writer, light states.LightState) error {
+	if err := sendEvent(ctx, w, "light", templates.LightStatus(light)); err != nil {
+		return err
+	}
+	if err := sendEvent(ctx, w, "light-button", templates.LightButtonText(light.IsOn)); err != nil {
+		return err
+	}
+	return sendEvent(ctx, w, "light-color", templ.Raw(light.Color.Hex()))
+}
+
+// GET /events/stream
+func (s *server) statusStreamHandler(w http.ResponseWriter, r *http.Request) {
+	s.streamStatus(w, r, s.stillLoggedIn(r))
+}
+
+// Stream the light and camera's status as server-sent events until the client goes away, or
+// stillAllowed (if given) returns false. Each is sent as soon as the stream starts, then whenever it
+// changes. The events are:
+//
+//	light          LightStatus, describing what the light is showing
+//	light-button   The light button's text
+//	light-color    The light's color as #rrggbb
+//	camera         CameraStatusLine
+func (s *server) streamStatus(w http.ResponseWriter, r *http.Request, stillAllowed func() bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	changes := s.light.Subscribe()
+	defer s.light.Unsubscribe(changes)
+	ticker := time.NewTicker(statusCheckInterval)
+	defer ticker.Stop()
+
+	ctx := r.Context()
+	camera := s.cameraStatus()
+	err := sendLightEvents(ctx, w, s.light.State())
+	if err == nil {
+		err = sendEvent(ctx, w, "camera", templates.CameraStatusLine(camera))
+	}
+	lastSent, lastChecked := time.Now(), time.Now()
+	for err == nil {
+		flusher.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case light := <-changes:
+			err = sendLightEvents(ctx, w, light)
+			lastSent = time.Now()
+		case <-ticker.C:
+			if stillAllowed != nil && time.Since(lastChecked) >= feedRecheckInterval {
+				if !stillAllowed() {
+					return
+				}
+				lastChecked = time.Now()
+			}
+
+			if status := s.cameraStatus(); status != camera {
+				camera = status
+				err = sendEvent(ctx, w, "camera", templates.CameraStatusLine(camera))
+				lastSent = time.Now()
+			} else if time.Since(lastSent) >= statusKeepAliveInterval {
+				_, err = io.WriteString(w, ": keep-alive\n\n")
+				lastSent = time.Now()
+			}
+		}
+	}
+	s.logger.Printf("Error when sending status: %v", err)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"catcam_go/internal/store/timelapses"
+	"catcam_go/internal/templates"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// GET /timelapses
+func (s *server) listTimelapsesHandler(w http.ResponseWriter, r *http.Request) {
+	allTimelapses, err := s.timelapseStore.GetTimelapses(r.Context())
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when getting timelapses: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templates.Timelapses(allTimelapses, s.timelapser != nil), "Timelapses")
+}
+
+// GET /timelapses/{id}/download
+func (s *server) downloadTimelapseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid timelapse id: %s", r.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+
+	timelapse, err := s.timelapseStore.GetTimelapse(r.Context(), int64(id))
+	if err != nil {
+		if _, ok := err.(timelapses.ErrTimelapseNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		errMsg := fmt.Sprintf("Error when getting timelapse: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := os.Stat(timelapse.Path); err != nil {
+		s.logger.Printf("Error when opening timelapse %s: %v", timelapse.Path, err)
+		http.Error(w, "Timelapse unavailable", http.StatusNotFound)
+		return
+	}
+
+	// ServeFile takes care of range requests, so players can seek
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catcam-%s.avi"`, timelapse.Day))
+	http.ServeFile(w, r, timelapse.Path)
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/middleware"
+	"catcam_go/internal/store/audit"
+	"catcam_go/internal/store/twofactor"
+	"catcam_go/internal/templates"
+	"catcam_go/internal/totp"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// How many recovery codes each user gets at a time
+const recoveryCodeCount = 10
+
+// Make up a fresh set of recovery codes, formatted like abcd-efgh-ijkl-mnop
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codeBytes := make([]byte, 10)
+		if _, err := rand.Read(codeBytes); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(codeBytes))
+		codes[i] = fmt.Sprintf("%s-%s-%s-%s", code[0:4], code[4:8], code[8:12], code[12:16])
+	}
+	return codes, nil
+}
+
+// Check a code from the user's authenticator app, or one of their recovery codes. Either way the
+// code is used up
+func (s *server) checkSecondFactor(ctx context.Context, userId int64, code string) (bool, error) {
+	userTotp, err := s.twoFactorStore.GetTotp(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := totp.Validate(userTotp.Secret, code, time.Now()); ok {
+		return s.twoFactorStore.UseTotpStep(ctx, userId, step)
+	}
+	return s.twoFactorStore.UseRecoveryCode(ctx, userId, code)
+}
+
+// Check a code given by the user, throttled the same way as passwords so codes can't be guessed
+// either. If the user must wait before trying again, the wait is returned instead
+func (s *server) verifySecondFactor(ctx context.Context, user db.User, ip string, code string) (bool, time.Duration, error) {
+	wait, err := s.loginWait(ctx, user.Username, ip)
+	if err != nil || wait > 0 {
+		return false, wait, err
+	}
+
+	ok, err := s.checkSecondFactor(ctx, user.ID, code)
+	if err != nil {
+		return false, 0, err
+	}
+	if !ok {
+		s.recordLoginFailure(ctx, user.Username, ip)
+	}
+	return ok, 0, nil
+}
+
+// The form to set up two-factor authentication with a brand new secret
+func twoFactorEnrolForm(user db.User) (templ.Component, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	return templates.TwoFactorEnrol(secret, totp.ProvisioningURI(AppName, user.Username, secret), nil), nil
+}
+
+// POST /login/two-factor
+func (s *server) twoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formCode := r.FormValue("code")
+
+	// Start over if the password was given too long ago
+	userId, ok := s.sessionStore.PendingUser(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, templates.LoginForm(map[string]string{"password": "That took too long. Log in again"}))
+		return
+	}
+	user, err := s.userStore.GetUser(r.Context(), userId)
+	if err != nil {
+		s.logger.Printf("Error when getting user: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, templates.LoginForm(map[string]string{"password": "Log in again"}))
+		return
+	}
+
+	validationErrors := make(map[string]string)
+	if formCode == "" {
+		validationErrors["code"] = "Code is required"
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.TwoFactorLoginForm(validationErrors))
+		return
+	}
+
+	ip := clientIP(r)
+	ok, wait, err := s.verifySecondFactor(r.Context(), user, ip, formCode)
+	if err != nil {
+		s.logger.Printf("Error when checking two-factor code: %v", err)
+		validationErrors["code"] = "Internal server error"
+		w.WriteHeader(http.StatusInternalServerError)
+		renderTemplate(w, r, templates.TwoFactorLoginForm(validationErrors))
+		return
+	}
+	if wait > 0 {
+		s.recordAudit(r.Context(), audit.ActionLoginFailed, loginActor(r, user), "Too many attempts")
+		validationErrors["code"] = setRetryAfter(w, wait)
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderTemplate(w, r, templates.TwoFactorLoginForm(validationErrors))
+		return
+	}
+	if !ok {
+		s.recordAudit(r.Context(), audit.ActionLoginFailed, loginActor(r, user), "Wrong two-factor code")
+		validationErrors["code"] = "Code is incorrect"
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, templates.TwoFactorLoginForm(validationErrors))
+		return
+	}
+
+	s.clearLoginFailures(r.Context(), user.Username, ip)
+
+	// The pending session is finished with
+	if pending, err := s.sessionRecords.GetSessionByToken(r.Context(), s.sessionStore.CurrentToken(r)); err == nil {
+		s.sessionRecords.DeleteSession(r.Context(), pending.ID)
+	}
+
+	err = s.sessionStore.WriteNew(w, r, user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when saving session: %v", err)
+		s.logger.Print(errMsg)
+		w.WriteHeader(http.StatusInternalServerError)
+		validationErrors["code"] = "Internal server error"
+		renderTemplate(w, r, templates.TwoFactorLoginForm(validationErrors))
+		return
+	}
+
+	s.userStore.SetUserLastLogin(r.Context(), user.ID)
+	s.recordAudit(r.Context(), audit.ActionLoginSucceeded, loginActor(r, user), "With two-factor")
+	redirectAfterForm(w, r, "/")
+}
+
+// GET /account/two-factor
+func (s *server) twoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	hasTotp, err := s.twoFactorStore.HasTotp(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when checking for two-factor authentication: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	if !hasTotp {
+		enrol, err := twoFactorEnrolForm(user)
+		if err != nil {
+			errMsg := fmt.Sprintf("Error when generating secret: %v", err)
+			s.logger.Print(errMsg)
+			http.Error(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+		renderTemplate(w, r, templates.TwoFactor(enrol), "Two-factor")
+		return
+	}
+
+	recoveryCodesLeft, err := s.twoFactorStore.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when counting recovery codes: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, templates.TwoFactor(templates.TwoFactorEnabled(recoveryCodesLeft, nil)), "Two-factor")
+}
+
+// POST /account/two-factor
+func (s *server) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formSecret := r.FormValue("secret")
+	formCode := r.FormValue("code")
+
+	user, _ := middleware.CurrentUser(r.Context())
+	hasTotp, err := s.twoFactorStore.HasTotp(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when checking for two-factor authentication: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	if hasTotp {
+		http.Error(w, "Two-factor authentication is already on", http.StatusConflict)
+		return
+	}
+
+	// The code proves the authenticator app has the secret
+	step, ok := totp.Validate(formSecret, formCode, time.Now())
+	if !ok {
+		validationErrors := map[string]string{"code": "Code is incorrect. Check the time on your phone is right"}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderTemplate(w, r, templates.TwoFactorEnrol(formSecret, totp.ProvisioningURI(AppName, user.Username, formSecret), validationErrors))
+		return
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when generating recovery codes: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	if err := s.twoFactorStore.EnableTotp(r.Context(), user.ID, formSecret, step, recoveryCodes); err != nil {
+		errMsg := fmt.Sprintf("Error when turning on two-factor authentication: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("Turned on two-factor authentication for %s", user.Username)
+
+	renderTemplate(w, r, templates.RecoveryCodes(recoveryCodes))
+}
+
+// Check the code given to change two-factor settings, rendering the form again with an error if it's
+// no good
+func (s *server) checkTwoFactorForm(w http.ResponseWriter, r *http.Request, user db.User) bool {
+	if err := r.ParseForm(); err != nil {
+		s.logger.Printf("Error when parsing form: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	formCode := r.FormValue("code")
+
+	validationErrors := make(map[string]string)
+	status := http.StatusUnprocessableEntity
+	if formCode == "" {
+		validationErrors["code"] = "Code is required"
+	} else {
+		ok, wait, err := s.verifySecondFactor(r.Context(), user, clientIP(r), formCode)
+		switch {
+		case err != nil:
+			if _, notFound := err.(twofactor.ErrTotpNotFound); notFound {
+				http.Error(w, "Two-factor authentication is off", http.StatusConflict)
+				return false
+			}
+			errMsg := fmt.Sprintf("Error when checking two-factor code: %v", err)
+			s.logger.Print(errMsg)
+			http.Error(w, errMsg, http.StatusInternalServerError)
+			return false
+		case wait > 0:
+			validationErrors["code"] = setRetryAfter(w, wait)
+			status = http.StatusTooManyRequests
+		case !ok:
+			validationErrors["code"] = "Code is incorrect"
+		}
+	}
+	if len(validationErrors) == 0 {
+		return true
+	}
+
+	recoveryCodesLeft, err := s.twoFactorStore.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when counting recovery codes: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return false
+	}
+	w.WriteHeader(status)
+	renderTemplate(w, r, templates.TwoFactorEnabled(recoveryCodesLeft, validationErrors))
+	return false
+}
+
+// POST /account/two-factor/recovery-codes
+func (s *server) newRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	if !s.checkTwoFactorForm(w, r, user) {
+		return
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when generating recovery codes: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	if err := s.twoFactorStore.ReplaceRecoveryCodes(r.Context(), user.ID, recoveryCodes); err != nil {
+		errMsg := fmt.Sprintf("Error when replacing recovery codes: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templates.RecoveryCodes(recoveryCodes))
+}
+
+// DELETE /account/two-factor
+func (s *server) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.CurrentUser(r.Context())
+	if !s.checkTwoFactorForm(w, r, user) {
+		return
+	}
+
+	if err := s.twoFactorStore.DisableTotp(r.Context(), user.ID); err != nil {
+		errMsg := fmt.Sprintf("Error when turning off two-factor authentication: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("Turned off two-factor authentication for %s", user.Username)
+
+	// Offer to set it up again
+	enrol, err := twoFactorEnrolForm(user)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error when generating secret: %v", err)
+		s.logger.Print(errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, enrol)
+}
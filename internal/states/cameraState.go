@@ -1,17 +1,17 @@
 package states
 
 import (
-	"bytes"
+	"catcam_go/internal/mjpeg"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
 	"sync"
 	"time"
 )
 
 type Camera struct {
-	cmd                    *exec.Cmd
+	source                 FrameSource
+	sourceStream           io.ReadCloser
 	width                  int
 	height                 int
 	fps                    int
@@ -19,15 +19,47 @@ type Camera struct {
 	stream                 chan []byte
 	subscribers            map[chan []byte]struct{}
 	mu                     sync.Mutex
+	runMu                  sync.Mutex
 	running                bool
+	runDone                chan struct{} // Closed when the current run stops
 	bufferSize             int
 	timeSinceNoSubscribers time.Time
 	light                  *Light
+	latestFrame            []byte
+	latestFrameTime        time.Time
+	history                *frameHistory
+	onRunningChange        func(running bool, by CameraClient)
+	framesThisSecond       int
+	frameRate              int
 }
 
-// NewCamera initializes the camera with a buffered channel
-func NewCamera(width, height, fps int, quality int, bufferSize int, light *Light) *Camera {
+// CameraClient is who or what starts or stops the camera: someone watching (with their address), or
+// one of CatCam's own jobs, such as the recorder, named without a user
+type CameraClient struct {
+	UserID int64 // 0 if it's not a user
+	Name   string
+	IP     string
+}
+
+// What stops the camera by itself
+var (
+	idleClient  = CameraClient{Name: "idle"}   // Nobody has been watching for a while
+	endedClient = CameraClient{Name: "source"} // The frame source stopped sending
+)
+
+// ErrNoFrame is returned by Snapshot when the camera didn't produce a frame in time
+type ErrNoFrame struct {
+	Timeout time.Duration
+}
+
+func (e ErrNoFrame) Error() string {
+	return fmt.Sprintf("no frame from the camera within %s", e.Timeout)
+}
+
+// NewCamera initializes the camera with a buffered channel, capturing from the given source
+func NewCamera(source FrameSource, width, height, fps int, quality int, bufferSize int, light *Light) *Camera {
 	return &Camera{
+		source:                 source,
 		width:                  width,
 		height:                 height,
 		fps:                    fps,
@@ -37,9 +69,27 @@ func NewCamera(width, height, fps int, quality int, bufferSize int, light *Light
 		subscribers:            make(map[chan []byte]struct{}),
 		timeSinceNoSubscribers: time.Now(),
 		light:                  light,
+		history:                newFrameHistory(0),
 	}
 }
 
+// KeepHistory makes the camera hold on to roughly the last d worth of frames in memory, so
+// subscribers can also get frames from before they subscribed (see SubscribeWithHistory)
+func (c *Camera) KeepHistory(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.history = newFrameHistory(int(d.Seconds() * float64(c.fps)))
+}
+
+// OnRunningChange sets a function to call whenever the camera starts or stops, with who or what
+// started or stopped it. It's called while the camera is starting or stopping, so mustn't start or
+// stop the camera itself
+func (c *Camera) OnRunningChange(f func(running bool, by CameraClient)) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	c.onRunningChange = f
+}
+
 // Subscribe adds a new client stream channel
 func (c *Camera) Subscribe() chan []byte {
 	ch := make(chan []byte, c.fps*c.bufferSize)
@@ -51,6 +101,19 @@ func (c *Camera) Subscribe() chan []byte {
 	return ch
 }
 
+// SubscribeWithHistory adds a new client stream channel and also returns the kept frames produced
+// since the given time. No frame is both in the history and sent to the channel
+func (c *Camera) SubscribeWithHistory(since time.Time) (chan []byte, []TimedFrame) {
+	ch := c.Subscribe()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Anything sent to the channel before we took the lock is also in the history
+	for len(ch) > 0 {
+		<-ch
+	}
+	return ch, c.history.since(since)
+}
+
 // Unsubscribe removes a client stream channel
 func (c *Camera) Unsubscribe(ch chan []byte) {
 	c.mu.Lock()
@@ -64,69 +127,59 @@ func (c *Camera) Unsubscribe(ch chan []byte) {
 	}
 }
 
-// Start launches FFmpeg and streams frames into the channel
-func (c *Camera) Start() error {
+// Start opens the frame source and streams frames into the channel, unless it's already running. The
+// client is who or what wants it running
+func (c *Camera) Start(client CameraClient) error {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+
 	if c.running {
 		return nil
 	}
-	c.running = true
-	c.stream = make(chan []byte, c.fps) // Buffer frames for 1 second
-
-	log.Println("Starting camera")
-
-	// For Raspberry Pi 5
-	c.cmd = exec.Command(
-		"rpicam-vid",
-		"-t", "0",
-		"--codec", "mjpeg",
-		"--width", fmt.Sprintf("%d", c.width),
-		"--height", fmt.Sprintf("%d", c.height),
-		"--framerate", fmt.Sprintf("%d", c.fps),
-		"--quality", fmt.Sprintf("%d", c.quality),
-		"--inline",
-		"-o", "-",
-	)
-
-	// For USB webcam
-	// c.cmd = exec.Command(
-	// 	"ffmpeg",
-	// 	"-f", "video4linux2",
-	// 	"-s", fmt.Sprintf("%dx%d", c.width, c.height),
-	// 	"-i", "/dev/video0",
-	// 	"-f", "mpjpeg",
-	// 	"-q:v", fmt.Sprintf("%d", c.compression),
-	// 	"-vf", fmt.Sprintf("scale=%d:%d", c.width, c.height),
-	// 	"-r", fmt.Sprintf("%d", c.fps),
-	// 	"pipe:1",
-	// )
-
-	stdout, err := c.cmd.StdoutPipe()
+
+	log.Printf("Starting camera from %s", c.source)
+
+	stdout, err := c.source.Open(CaptureSettings{
+		Width:   c.width,
+		Height:  c.height,
+		Fps:     c.fps,
+		Quality: c.quality,
+	})
 	if err != nil {
 		return err
 	}
 
-	if err := c.cmd.Start(); err != nil {
-		return err
+	c.sourceStream = stdout
+	c.running = true
+	// Each run's goroutines only ever stop their own run. By the time they notice it has ended, the
+	// camera may have been started again
+	done := make(chan struct{})
+	c.runDone = done
+	if c.onRunningChange != nil {
+		c.onRunningChange(true, client)
 	}
-
-	// Frame drop detection
-	ticker := time.NewTicker(1 * time.Second)
+	stream := make(chan []byte, c.fps) // Buffer frames for 1 second
+	c.stream = stream
 
 	go func() {
-		defer ticker.Stop()
-		defer close(c.stream)
-		defer c.Stop()
-
-		buf := make([]byte, 4096)
+		defer close(stream)
+		defer c.stop(done, endedClient)
 
 		// Read frames and send them over the channel
-		var frameBuffer []byte
-		const jpegSOI = "\xFF\xD8" // Start of Image marker
-		const jpegEOI = "\xFF\xD9" // End of Image marker
-
-		for c.running {
-			n, err := stdout.Read(buf)
+		splitter := mjpeg.NewSplitter(stdout, mjpeg.DefaultMaxFrameSize)
+		for {
+			frame, err := splitter.Next()
 			if err != nil {
+				if _, ok := err.(mjpeg.ErrCorruptFrame); ok {
+					log.Println("Frame dropped:", err)
+					continue
+				}
+				select {
+				case <-done:
+					// The stream was closed by Stop
+					return
+				default:
+				}
 				if err == io.EOF {
 					log.Println("Camera stream ended")
 				} else {
@@ -135,31 +188,22 @@ func (c *Camera) Start() error {
 				return
 			}
 
-			frameBuffer = append(frameBuffer, buf[:n]...)
-
-			// Look for a complete JPEG frame
-			startIdx := bytes.Index(frameBuffer, []byte(jpegSOI))
-			endIdx := bytes.Index(frameBuffer, []byte(jpegEOI))
-
-			if startIdx != -1 && endIdx != -1 && startIdx < endIdx {
-				// Extract and send the complete frame
-				frame := frameBuffer[startIdx : endIdx+2]
-				select {
-				case c.stream <- frame:
-				default:
-					log.Println("Frame dropped: channel full")
-				}
-
-				// Remove processed frame from buffer
-				frameBuffer = frameBuffer[endIdx+2:]
+			select {
+			case stream <- frame:
+			default:
+				log.Println("Frame dropped: channel full")
 			}
 		}
 	}()
 
 	// Broadcast frames to all subscribers
 	go func() {
-		for frame := range c.stream {
+		for frame := range stream {
 			c.mu.Lock()
+			c.latestFrame = frame
+			c.latestFrameTime = time.Now()
+			c.framesThisSecond++
+			c.history.add(TimedFrame{Time: c.latestFrameTime, Data: frame})
 			for ch := range c.subscribers {
 				select {
 				case ch <- frame:
@@ -171,12 +215,25 @@ func (c *Camera) Start() error {
 		}
 	}()
 
-	// Monitor time since last subscriber left and shut down
+	// Measure the frame rate, and monitor time since last subscriber left and shut down
 	go func() {
-		for range ticker.C {
-			if !c.timeSinceNoSubscribers.IsZero() && time.Since(c.timeSinceNoSubscribers) > 5*time.Second {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			c.mu.Lock()
+			c.frameRate, c.framesThisSecond = c.framesThisSecond, 0
+			timeSinceNoSubscribers := c.timeSinceNoSubscribers
+			c.mu.Unlock()
+
+			if !timeSinceNoSubscribers.IsZero() && time.Since(timeSinceNoSubscribers) > 5*time.Second {
 				log.Println("No subscribers for 5 seconds. Stopping camera.")
-				c.Stop()
+				c.stop(done, idleClient)
 				return
 			}
 		}
@@ -185,27 +242,71 @@ func (c *Camera) Start() error {
 	return nil
 }
 
-// Stop terminates the camera process
-func (c *Camera) Stop() {
-	if !c.running {
+// Stop closes the frame source. The client is who or what stopped it
+func (c *Camera) Stop(client CameraClient) {
+	c.stop(nil, client)
+}
+
+// Stop the run that closes done when it ends, doing nothing if that run has already stopped. A nil
+// done stops whichever run is going
+func (c *Camera) stop(done chan struct{}, client CameraClient) {
+	c.runMu.Lock()
+	if !c.running || (done != nil && done != c.runDone) {
+		c.runMu.Unlock()
 		return
 	}
 	c.running = false
+	close(c.runDone)
+	c.runDone = nil
 
-	if c.cmd != nil && c.cmd.Process != nil {
-		err := c.cmd.Process.Kill()
-		if err != nil {
-			log.Println("Failed to kill camera process:", err)
-		}
+	if c.sourceStream != nil {
+		c.sourceStream.Close()
+		c.sourceStream = nil
 	}
+	onRunningChange := c.onRunningChange
+	c.runMu.Unlock()
+	c.mu.Lock()
+	c.frameRate, c.framesThisSecond = 0, 0
+	c.mu.Unlock()
 	log.Println("Camera stopped")
+	if onRunningChange != nil {
+		onRunningChange(false, client)
+	}
 
 	c.light.TurnOff()
 	c.light.Stop()
 	log.Println("Light stopped too")
 }
 
+// Snapshot returns the most recent frame. If the camera hasn't produced one in the last second it is
+// started (if need be, for the client) and we wait up to timeout for the next frame. Snapshot only subscribes for as
+// long as it waits, so the camera will still stop itself once nobody else is watching
+func (c *Camera) Snapshot(client CameraClient, timeout time.Duration) ([]byte, error) {
+	c.mu.Lock()
+	frame, frameTime := c.latestFrame, c.latestFrameTime
+	c.mu.Unlock()
+	if frame != nil && time.Since(frameTime) < time.Second && c.IsRunning() {
+		return frame, nil
+	}
+
+	ch := c.Subscribe()
+	defer c.Unsubscribe(ch)
+
+	if err := c.Start(client); err != nil {
+		return nil, err
+	}
+
+	select {
+	case frame := <-ch:
+		return frame, nil
+	case <-time.After(timeout):
+		return nil, ErrNoFrame{Timeout: timeout}
+	}
+}
+
 func (c *Camera) IsRunning() bool {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
 	return c.running
 }
 
@@ -216,3 +317,15 @@ func (c *Camera) Width() int {
 func (c *Camera) Height() int {
 	return c.height
 }
+
+func (c *Camera) Fps() int {
+	return c.fps
+}
+
+// FrameRate returns how many frames the camera actually produced in the last second, which is 0 while
+// it's stopped
+func (c *Camera) FrameRate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frameRate
+}
@@ -0,0 +1,122 @@
+package states
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stream that sends nothing, and only ends when told to rather than when it's closed, like a
+// capture process slow to exit
+type stuckStream struct {
+	end chan struct{}
+}
+
+func (s *stuckStream) Read(p []byte) (int, error) {
+	<-s.end
+	return 0, io.EOF
+}
+
+func (s *stuckStream) Close() error {
+	return nil
+}
+
+type stuckSource struct {
+	mu      sync.Mutex
+	streams []*stuckStream
+}
+
+func (s *stuckSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stream := &stuckStream{end: make(chan struct{})}
+	s.streams = append(s.streams, stream)
+	return stream, nil
+}
+
+func (s *stuckSource) String() string {
+	return "stuck"
+}
+
+// A camera that records each time it starts or stops, and who for
+type runChange struct {
+	running bool
+	by      CameraClient
+}
+
+func newTestCamera(source FrameSource) (*Camera, func() []runChange) {
+	camera := NewCamera(source, 64, 48, 10, 80, 2, NewLight(&FakeLEDDriver{NumPixels: 1}))
+	var mu sync.Mutex
+	var changes []runChange
+	camera.OnRunningChange(func(running bool, by CameraClient) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, runChange{running, by})
+	})
+	return camera, func() []runChange {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(changes)
+	}
+}
+
+func TestCameraOldRunDoesNotStopNewOne(t *testing.T) {
+	source := &stuckSource{}
+	camera, changes := newTestCamera(source)
+	// Someone's watching, so the camera doesn't stop itself
+	ch := camera.Subscribe()
+	defer camera.Unsubscribe(ch)
+
+	viewer := CameraClient{UserID: 1, Name: "tabby", IP: "10.0.0.1"}
+	recorder := CameraClient{Name: "recorder"}
+	if err := camera.Start(viewer); err != nil {
+		t.Fatal(err)
+	}
+	camera.Stop(viewer)
+	if err := camera.Start(recorder); err != nil {
+		t.Fatal(err)
+	}
+	defer camera.Stop(recorder)
+
+	// The first run's stream only now ends, long after it was stopped
+	close(source.streams[0].end)
+	time.Sleep(200 * time.Millisecond)
+
+	if !camera.IsRunning() {
+		t.Error("the first run ending stopped the second")
+	}
+	want := []runChange{{true, viewer}, {false, viewer}, {true, recorder}}
+	if got := changes(); !slices.Equal(got, want) {
+		t.Errorf("running changes %v, want %v", got, want)
+	}
+}
+
+func TestCameraStopsWhenStreamEnds(t *testing.T) {
+	source := &stuckSource{}
+	camera, changes := newTestCamera(source)
+	ch := camera.Subscribe()
+	defer camera.Unsubscribe(ch)
+	viewer := CameraClient{UserID: 1, Name: "tabby", IP: "10.0.0.1"}
+	if err := camera.Start(viewer); err != nil {
+		t.Fatal(err)
+	}
+	// Already running, so this doesn't count as starting it
+	if err := camera.Start(CameraClient{Name: "recorder"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(source.streams[0].end)
+	deadline := time.Now().Add(5 * time.Second)
+	for camera.IsRunning() {
+		if time.Now().After(deadline) {
+			t.Fatal("camera still running after its stream ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []runChange{{true, viewer}, {false, endedClient}}
+	if got := changes(); !slices.Equal(got, want) {
+		t.Errorf("running changes %v, want %v", got, want)
+	}
+}
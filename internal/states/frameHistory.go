@@ -0,0 +1,47 @@
+package states
+
+import "time"
+
+// TimedFrame is a frame along with when the camera produced it
+type TimedFrame struct {
+	Time time.Time
+	Data []byte
+}
+
+// frameHistory is a fixed size ring buffer of the most recent frames
+type frameHistory struct {
+	frames []TimedFrame
+	next   int // Index the next frame is written to
+	full   bool
+}
+
+func newFrameHistory(size int) *frameHistory {
+	return &frameHistory{frames: make([]TimedFrame, size)}
+}
+
+func (h *frameHistory) add(frame TimedFrame) {
+	if len(h.frames) <= 0 {
+		return
+	}
+	h.frames[h.next] = frame
+	h.next = (h.next + 1) % len(h.frames)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// since returns the frames produced at or after the given time, oldest first
+func (h *frameHistory) since(t time.Time) []TimedFrame {
+	var ordered []TimedFrame
+	if h.full {
+		ordered = append(ordered, h.frames[h.next:]...)
+	}
+	ordered = append(ordered, h.frames[:h.next]...)
+
+	for i, frame := range ordered {
+		if !frame.Time.Before(t) {
+			return ordered[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package states
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CaptureSettings are the parameters the camera asks a frame source to capture with. Sources are
+// free to ignore any that don't apply to them (e.g. a directory replay has no quality setting)
+type CaptureSettings struct {
+	Width   int
+	Height  int
+	Fps     int
+	Quality int
+}
+
+// FrameSource produces a stream of back-to-back JPEG frames for the camera to split and broadcast
+type FrameSource interface {
+	// Open begins capturing and returns the raw stream. Closing the stream stops the capture
+	Open(settings CaptureSettings) (io.ReadCloser, error)
+	// String describes the source for logging
+	String() string
+}
+
+// commandStream wraps the stdout of a capture process so closing it kills the process
+type commandStream struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (s *commandStream) Close() error {
+	if s.cmd.Process != nil {
+		if err := s.cmd.Process.Kill(); err != nil {
+			log.Println("Failed to kill camera process:", err)
+		}
+	}
+	s.ReadCloser.Close()
+	return s.cmd.Wait()
+}
+
+// startCommand runs the given command and returns its stdout as a frame stream
+func startCommand(name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// RpicamSource captures from the Raspberry Pi camera module using rpicam-vid (Raspberry Pi 5)
+type RpicamSource struct{}
+
+func (s RpicamSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	return startCommand(
+		"rpicam-vid",
+		"-t", "0",
+		"--codec", "mjpeg",
+		"--width", fmt.Sprintf("%d", settings.Width),
+		"--height", fmt.Sprintf("%d", settings.Height),
+		"--framerate", fmt.Sprintf("%d", settings.Fps),
+		"--quality", fmt.Sprintf("%d", settings.Quality),
+		"--inline",
+		"-o", "-",
+	)
+}
+
+func (s RpicamSource) String() string {
+	return "rpicam-vid"
+}
+
+// FFmpegSource captures from a video4linux2 device (e.g. a USB webcam) using ffmpeg
+type FFmpegSource struct {
+	Device string
+}
+
+func (s FFmpegSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	// ffmpeg's -q:v goes from 2 (best) to 31 (worst), so map the 1-100 quality onto that range
+	qscale := 31 - (settings.Quality*29)/100
+	return startCommand(
+		"ffmpeg",
+		"-loglevel", "error",
+		"-f", "video4linux2",
+		"-s", fmt.Sprintf("%dx%d", settings.Width, settings.Height),
+		"-i", s.Device,
+		"-f", "mpjpeg",
+		"-q:v", fmt.Sprintf("%d", qscale),
+		"-vf", fmt.Sprintf("scale=%d:%d", settings.Width, settings.Height),
+		"-r", fmt.Sprintf("%d", settings.Fps),
+		"pipe:1",
+	)
+}
+
+func (s FFmpegSource) String() string {
+	return fmt.Sprintf("ffmpeg (%s)", s.Device)
+}
+
+// CommandSource runs an arbitrary command which must write JPEG frames to its stdout. The command
+// is not run through a shell
+type CommandSource struct {
+	Name string
+	Args []string
+}
+
+func (s CommandSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	return startCommand(s.Name, s.Args...)
+}
+
+func (s CommandSource) String() string {
+	return strings.Join(append([]string{s.Name}, s.Args...), " ")
+}
+
+// HTTPSource pulls an MJPEG stream from another camera or server over HTTP
+type HTTPSource struct {
+	URL string
+	// How long connecting and waiting for the response can take, as the camera can't be started or
+	// stopped in the meantime. Zero means defaultHTTPSourceTimeout. Reading the stream isn't limited
+	Timeout time.Duration
+}
+
+const defaultHTTPSourceTimeout = 10 * time.Second
+
+func (s HTTPSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPSourceTimeout
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			DisableKeepAlives:     true, // The stream is only read once
+		},
+	}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status from %s: %s", s.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s HTTPSource) String() string {
+	return s.URL
+}
+
+// DirectorySource replays the JPEG files in a directory in name order at the capture frame rate,
+// looping back to the start once it reaches the end
+type DirectorySource struct {
+	Dir string
+}
+
+func (s DirectorySource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	files, err := s.jpegFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) <= 0 {
+		return nil, fmt.Errorf("no JPEG files found in %s", s.Dir)
+	}
+
+	fps := settings.Fps
+	if fps <= 0 {
+		fps = 1
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		ticker := time.NewTicker(time.Second / time.Duration(fps))
+		defer ticker.Stop()
+
+		for i := 0; ; i = (i + 1) % len(files) {
+			frame, err := os.ReadFile(files[i])
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			// Fails once the camera closes the read end of the pipe
+			if _, err := pw.Write(frame); err != nil {
+				return
+			}
+			<-ticker.C
+		}
+	}()
+
+	return pr, nil
+}
+
+// The paths of the JPEG files in the directory, in name order. The directory is only read once, as
+// globbing for each way of writing the extension finds the same files more than once on
+// case-insensitive filesystems
+func (s DirectorySource) jpegFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (strings.EqualFold(ext, ".jpg") || strings.EqualFold(ext, ".jpeg")) {
+			files = append(files, filepath.Join(s.Dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s DirectorySource) String() string {
+	return fmt.Sprintf("directory (%s)", s.Dir)
+}
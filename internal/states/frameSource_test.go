@@ -0,0 +1,85 @@
+package states
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDirectorySourceFindsEachJPEGOnce(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.JPEG", "a.jpg", "c.Jpg", "d.jpeg", "notes.txt", "e.jpg.bak"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{0xFF, 0xD8, 0xFF, 0xD9}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "old.jpg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := DirectorySource{Dir: dir}.jpegFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range []string{"a.jpg", "b.JPEG", "c.Jpg", "d.jpeg"} {
+		want = append(want, filepath.Join(dir, name))
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("found %v, want %v", files, want)
+	}
+}
+
+func TestDirectorySourceWithoutJPEGs(t *testing.T) {
+	if _, err := (DirectorySource{Dir: t.TempDir()}).Open(CaptureSettings{Fps: 1}); err == nil {
+		t.Error("opened an empty directory, want an error")
+	}
+	if _, err := (DirectorySource{Dir: filepath.Join(t.TempDir(), "missing")}).Open(CaptureSettings{Fps: 1}); err == nil {
+		t.Error("opened a missing directory, want an error")
+	}
+}
+
+func TestHTTPSourceGivesUpOnSlowServers(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never send the response's headers
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	start := time.Now()
+	stream, err := HTTPSource{URL: server.URL, Timeout: 100 * time.Millisecond}.Open(CaptureSettings{})
+	if err == nil {
+		stream.Close()
+		t.Fatal("opened a stream that never started")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("took %v to give up, want about 100ms", took)
+	}
+}
+
+func TestHTTPSourceStreamsPastTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "frame")
+	}))
+	defer server.Close()
+
+	stream, err := HTTPSource{URL: server.URL, Timeout: 100 * time.Millisecond}.Open(CaptureSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	body, err := io.ReadAll(stream)
+	if err != nil || string(body) != "frame" {
+		t.Errorf("read %q, %v, want the frame sent after the timeout", body, err)
+	}
+}
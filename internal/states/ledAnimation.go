@@ -0,0 +1,108 @@
+package states
+
+import "fmt"
+
+// AnimationMode is what the LEDs show while the light is on: a solid color or an animation. The
+// rainbow animations are control_leds.py's, and the rest need the Go animation engine (see
+// RenderAnimation)
+type AnimationMode string
+
+const (
+	ModeSolid          AnimationMode = "solid"
+	ModeBreathe        AnimationMode = "breathe"  // The color fading in and out
+	ModeGradient       AnimationMode = "gradient" // The color blending into its opposite along the strip
+	ModeChase          AnimationMode = "chase"
+	ModeComet          AnimationMode = "comet"
+	ModeSparkle        AnimationMode = "sparkle"
+	ModeRainbow        AnimationMode = "rainbow"
+	ModeRainbowChase   AnimationMode = "rainbow_chase"
+	ModeRainbowComet   AnimationMode = "rainbow_comet"
+	ModeRainbowSparkle AnimationMode = "rainbow_sparkle"
+	ModeCycle          AnimationMode = "cycle" // Each of the rainbow animations in turn
+)
+
+// All the modes, for pickers
+var AnimationModes = []AnimationMode{ModeSolid, ModeBreathe, ModeGradient, ModeChase, ModeComet, ModeSparkle, ModeRainbow, ModeRainbowChase, ModeRainbowComet, ModeRainbowSparkle, ModeCycle}
+
+// The modes control_leds.py can play itself
+var scriptAnimationModes = []AnimationMode{ModeSolid, ModeRainbow, ModeRainbowChase, ModeRainbowComet, ModeRainbowSparkle, ModeCycle}
+
+// The range animation speeds (as multiples of the normal speed) and brightnesses can be set within
+const (
+	MinAnimationSpeed      = 0.25
+	MaxAnimationSpeed      = 4.0
+	MinAnimationBrightness = 0.05
+	MaxAnimationBrightness = 1.0
+)
+
+// Animation is an animation mode and how to play it
+type Animation struct {
+	Mode       AnimationMode
+	Color      Color   // For modes that UsesColor
+	Speed      float64 // How many times the normal speed to play it at
+	Brightness float64 // From 0 (off) to 1 (full)
+}
+
+func ParseAnimationMode(str string) (AnimationMode, error) {
+	for _, mode := range AnimationModes {
+		if string(mode) == str {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("invalid animation mode %q", str)
+}
+
+// Label describes the mode for people
+func (m AnimationMode) Label() string {
+	switch m {
+	case ModeSolid:
+		return "Solid color"
+	case ModeBreathe:
+		return "Breathe"
+	case ModeGradient:
+		return "Gradient"
+	case ModeChase:
+		return "Chase"
+	case ModeComet:
+		return "Comet"
+	case ModeSparkle:
+		return "Sparkle"
+	case ModeRainbow:
+		return "Rainbow"
+	case ModeRainbowChase:
+		return "Rainbow chase"
+	case ModeRainbowComet:
+		return "Rainbow comet"
+	case ModeRainbowSparkle:
+		return "Rainbow sparkle"
+	case ModeCycle:
+		return "All the rainbows"
+	default:
+		return string(m)
+	}
+}
+
+// UsesColor reports whether the mode shows the light's color, rather than colors of its own
+func (m AnimationMode) UsesColor() bool {
+	switch m {
+	case ModeSolid, ModeBreathe, ModeGradient, ModeChase, ModeComet, ModeSparkle:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks the animation can be played
+func (a Animation) Validate() error {
+	if _, err := ParseAnimationMode(string(a.Mode)); err != nil {
+		return err
+	}
+	// Written so NaN, which compares false with everything, is out of range too
+	if !(a.Speed >= MinAnimationSpeed && a.Speed <= MaxAnimationSpeed) {
+		return fmt.Errorf("speed must be between %g and %g", MinAnimationSpeed, MaxAnimationSpeed)
+	}
+	if !(a.Brightness >= MinAnimationBrightness && a.Brightness <= MaxAnimationBrightness) {
+		return fmt.Errorf("brightness must be between %g and %g", MinAnimationBrightness, MaxAnimationBrightness)
+	}
+	return nil
+}
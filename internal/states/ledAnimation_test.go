@@ -0,0 +1,36 @@
+package states
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnimationValidate(t *testing.T) {
+	valid := Animation{Mode: ModeRainbow, Speed: 1, Brightness: 1}
+	tests := []struct {
+		name    string
+		change  func(a *Animation)
+		wantErr bool
+	}{
+		{"valid", func(a *Animation) {}, false},
+		{"slowest", func(a *Animation) { a.Speed = MinAnimationSpeed }, false},
+		{"fastest", func(a *Animation) { a.Speed = MaxAnimationSpeed }, false},
+		{"dimmest", func(a *Animation) { a.Brightness = MinAnimationBrightness }, false},
+		{"unknown mode", func(a *Animation) { a.Mode = "disco" }, true},
+		{"too slow", func(a *Animation) { a.Speed = MinAnimationSpeed / 2 }, true},
+		{"too fast", func(a *Animation) { a.Speed = MaxAnimationSpeed * 2 }, true},
+		{"NaN speed", func(a *Animation) { a.Speed = math.NaN() }, true},
+		{"infinite speed", func(a *Animation) { a.Speed = math.Inf(1) }, true},
+		{"too dim", func(a *Animation) { a.Brightness = 0 }, true},
+		{"too bright", func(a *Animation) { a.Brightness = 1.5 }, true},
+		{"NaN brightness", func(a *Animation) { a.Brightness = math.NaN() }, true},
+		{"negative infinite brightness", func(a *Animation) { a.Brightness = math.Inf(-1) }, true},
+	}
+	for _, tt := range tests {
+		animation := valid
+		tt.change(&animation)
+		if err := animation.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package states
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Color is the color of an LED
+type Color struct {
+	R, G, B uint8
+}
+
+// Hex formats the color as #rrggbb
+func (c Color) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// ParseHex parses a color formatted as #rrggbb
+func ParseHex(hex string) (Color, error) {
+	var c Color
+	if len(hex) != 7 || hex[0] != '#' {
+		return c, fmt.Errorf("invalid color %q, expected #rrggbb", hex)
+	}
+	value, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return c, fmt.Errorf("invalid color %q, expected #rrggbb", hex)
+	}
+	c.R, c.G, c.B = uint8(value>>16), uint8(value>>8), uint8(value)
+	return c, nil
+}
+
+// LEDDriver shows colors on the LED strip
+type LEDDriver interface {
+	// Fill sets every LED to the color, black being off, stopping any animation
+	Fill(color Color) error
+	// Animate plays the animation (which isn't ModeSolid) until it's replaced by another Fill or
+	// Animate, or the driver is closed
+	Animate(animation Animation) error
+	// Supports reports whether the driver can play the mode
+	Supports(mode AnimationMode) bool
+	// Close releases anything the driver holds on to, such as a helper process. The driver can still
+	// be used afterwards, and picks up again where it needs to
+	Close() error
+	// String describes the driver for logging
+	String() string
+}
+
+// ScriptLEDDriver runs the control_leds.py script once for each change. It's the slowest driver, as
+// the script has to start up every time, but needs nothing left running between changes. Animations
+// keep the script running until they're replaced
+type ScriptLEDDriver struct {
+	Python    string // The interpreter to run the script with, e.g. the one in its virtual environment
+	Script    string
+	Pin       string // The GPIO pin the strip is on, e.g. D14
+	NumPixels int
+
+	mu            sync.Mutex
+	animation     *exec.Cmd     // The script playing the current animation, if any
+	animationDone chan struct{} // Closed once the animation's script has exited
+}
+
+// Run the script with the given mode and options. The arguments are formatted by us and not passed
+// through a shell, so they can't be used to run anything else
+func (d *ScriptLEDDriver) command(mode AnimationMode, options ...string) *exec.Cmd {
+	args := append([]string{d.Script, d.Pin, strconv.Itoa(d.NumPixels), string(mode)}, options...)
+	cmd := exec.Command(d.Python, args...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func (d *ScriptLEDDriver) Fill(color Color) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+
+	output, err := d.command(ModeSolid, "--color", color.Hex()[1:]).Output()
+	if err != nil {
+		return fmt.Errorf("error running %s: %w", d.Script, err)
+	}
+	log.Print(string(output))
+	return nil
+}
+
+func (d *ScriptLEDDriver) Animate(animation Animation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+
+	cmd := d.command(animation.Mode, "--speed", strconv.FormatFloat(animation.Speed, 'f', -1, 64), "--brightness", strconv.FormatFloat(animation.Brightness, 'f', -1, 64))
+	cmd.Stdout = os.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running %s: %w", d.Script, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cmd.Wait(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				log.Printf("Error waiting for %s: %v", d.Script, err)
+			}
+		}
+	}()
+	d.animation, d.animationDone = cmd, done
+	return nil
+}
+
+func (d *ScriptLEDDriver) Supports(mode AnimationMode) bool {
+	return slices.Contains(scriptAnimationModes, mode)
+}
+
+// Kill the script playing the current animation, if any, and wait for it to exit so it can't draw
+// over whatever comes next. d.mu must be held
+func (d *ScriptLEDDriver) stopAnimation() {
+	if d.animation == nil {
+		return
+	}
+	d.animation.Process.Kill()
+	<-d.animationDone
+	d.animation, d.animationDone = nil, nil
+}
+
+// Close stops any animation
+func (d *ScriptLEDDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+	return nil
+}
+
+func (d *ScriptLEDDriver) String() string {
+	return fmt.Sprintf("script (%s)", d.Script)
+}
+
+// HelperLEDDriver keeps a helper process running and sends it one command per line on its stdin. The
+// helper (e.g. control_leds.py in serve mode) only has to start up once, so changes show straight
+// away. The commands are:
+//
+//	fill rrggbb                          Set every LED to the color
+//	animate mode speed brightness        Play one of its animations until the next command
+//	show rrggbbrrggbb...                 Set each LED to its own color, as a PixelSink
+//
+// The helper is started on the first command, and again if it has died since
+type HelperLEDDriver struct {
+	Name string // The helper command, which is not run through a shell
+	Args []string
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines *bufio.Writer
+}
+
+// How long the helper gets to finish up (e.g. turning the LEDs off) once it's asked to stop
+const helperStopTimeout = 2 * time.Second
+
+func (d *HelperLEDDriver) Fill(color Color) error {
+	return d.send(fmt.Sprintf("fill %s", color.Hex()[1:]))
+}
+
+func (d *HelperLEDDriver) Animate(animation Animation) error {
+	return d.send(fmt.Sprintf("animate %s %g %g", animation.Mode, animation.Speed, animation.Brightness))
+}
+
+func (d *HelperLEDDriver) Supports(mode AnimationMode) bool {
+	return slices.Contains(scriptAnimationModes, mode)
+}
+
+func (d *HelperLEDDriver) Show(frame []Color) error {
+	var line strings.Builder
+	line.WriteString("show ")
+	for _, color := range frame {
+		line.WriteString(color.Hex()[1:])
+	}
+	return d.send(line.String())
+}
+
+// Send a command to the helper, starting it (or restarting it once, if it has died) as needed
+func (d *HelperLEDDriver) send(line string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var err error
+	for range 2 {
+		if d.cmd == nil {
+			if err = d.start(); err != nil {
+				return err
+			}
+		}
+		if _, err = d.lines.WriteString(line + "\n"); err == nil {
+			if err = d.lines.Flush(); err == nil {
+				return nil
+			}
+		}
+		log.Printf("LED helper stopped listening (%v), restarting it", err)
+		d.stop()
+	}
+	return fmt.Errorf("error sending to LED helper: %w", err)
+}
+
+func (d *HelperLEDDriver) start() error {
+	cmd := exec.Command(d.Name, d.Args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting LED helper: %w", err)
+	}
+	log.Printf("Started LED helper %s", d.Name)
+
+	d.cmd, d.stdin, d.lines = cmd, stdin, bufio.NewWriter(stdin)
+	return nil
+}
+
+func (d *HelperLEDDriver) stop() error {
+	if d.cmd == nil {
+		return nil
+	}
+	// Closing stdin asks the helper to finish up, but don't wait around long if it doesn't
+	d.stdin.Close()
+	cmd := d.cmd
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(helperStopTimeout):
+		log.Printf("LED helper didn't exit within %v, killing it", helperStopTimeout)
+		cmd.Process.Kill()
+		err = <-done
+	}
+	d.cmd, d.stdin, d.lines = nil, nil, nil
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil
+	}
+	return err
+}
+
+// Close stops the helper
+func (d *HelperLEDDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stop()
+}
+
+func (d *HelperLEDDriver) String() string {
+	return fmt.Sprintf("helper (%s)", d.Name)
+}
+
+// FakeLEDDriver keeps the frames it's asked to show in memory instead of lighting anything, for
+// machines without an LED strip and for tests. Each frame is the color of every LED. It can also be
+// an EngineLEDDriver's PixelSink, to keep the frames of Go-rendered animations
+type FakeLEDDriver struct {
+	NumPixels int
+
+	mu        sync.Mutex
+	frames    [][]Color
+	animation *Animation // The animation playing, if any
+}
+
+// The most frames FakeLEDDriver keeps, oldest forgotten first
+const maxFakeFrames = 1000
+
+func (d *FakeLEDDriver) Fill(color Color) error {
+	frame := make([]Color, d.NumPixels)
+	for i := range frame {
+		frame[i] = color
+	}
+
+	d.mu.Lock()
+	d.animation = nil
+	d.mu.Unlock()
+	return d.Show(frame)
+}
+
+func (d *FakeLEDDriver) Show(frame []Color) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.frames = append(d.frames, append([]Color(nil), frame...))
+	if len(d.frames) > maxFakeFrames {
+		d.frames = d.frames[len(d.frames)-maxFakeFrames:]
+	}
+	return nil
+}
+
+func (d *FakeLEDDriver) Animate(animation Animation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.animation = &animation
+	return nil
+}
+
+func (d *FakeLEDDriver) Supports(mode AnimationMode) bool {
+	return true
+}
+
+// Animation returns the animation playing, if any
+func (d *FakeLEDDriver) Animation() (Animation, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.animation == nil {
+		return Animation{}, false
+	}
+	return *d.animation, true
+}
+
+// Frames returns the frames shown so far, oldest first
+func (d *FakeLEDDriver) Frames() [][]Color {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([][]Color(nil), d.frames...)
+}
+
+// Close stops any animation
+func (d *FakeLEDDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.animation = nil
+	return nil
+}
+
+func (d *FakeLEDDriver) String() string {
+	return "fake"
+}
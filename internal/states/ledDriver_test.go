@@ -0,0 +1,50 @@
+package states
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHelperLEDDriverLetsHelperFinish(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "commands")
+	// Slow to start reading, like control_leds.py setting up the strip, and only writing everything out
+	// once stdin is closed
+	d := &HelperLEDDriver{Name: "sh", Args: []string{"-c", `sleep 0.2; cat > "$0"`, out}}
+	if err := d.Fill(Color{R: 255}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Show([]Color{{G: 255}, {B: 255}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+
+	commands, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fill ff0000\nshow 00ff000000ff\n"; string(commands) != want {
+		t.Errorf("helper got %q, want %q", commands, want)
+	}
+}
+
+func TestHelperLEDDriverKillsStuckHelper(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the helper to time out")
+	}
+	// Ignores stdin altogether
+	d := &HelperLEDDriver{Name: "sh", Args: []string{"-c", "exec sleep 60"}}
+	if err := d.Fill(Color{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := d.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+	if took := time.Since(start); took < helperStopTimeout || took > helperStopTimeout+5*time.Second {
+		t.Errorf("closing took %v, want just over %v", took, helperStopTimeout)
+	}
+}
@@ -0,0 +1,100 @@
+package states
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PixelSink shows frames rendered by the Go animation engine, setting each LED to its own color
+type PixelSink interface {
+	// Show sets each LED to its color in the frame
+	Show(frame []Color) error
+	// Close releases anything the sink holds on to. It can still be used afterwards
+	Close() error
+	// String describes the sink for logging
+	String() string
+}
+
+// EngineLEDDriver renders every mode in Go (see RenderAnimation), handing each frame to a PixelSink at
+// AnimationTickRate. Unlike control_leds.py, it can play the modes that use the light's color
+type EngineLEDDriver struct {
+	Sink      PixelSink
+	NumPixels int
+
+	mu   sync.Mutex
+	stop chan struct{} // Closed to stop the current animation, if any
+	done chan struct{} // Closed once the current animation has stopped
+}
+
+func (d *EngineLEDDriver) Fill(color Color) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+
+	frame := make([]Color, d.NumPixels)
+	fill(frame, color)
+	return d.Sink.Show(frame)
+}
+
+func (d *EngineLEDDriver) Animate(animation Animation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second / AnimationTickRate)
+		defer ticker.Stop()
+
+		failing := false
+		for tick := 0; ; tick++ {
+			err := d.Sink.Show(RenderAnimation(animation, d.NumPixels, tick))
+			// Only log when the sink starts or stops failing, rather than every frame
+			if (err != nil) != failing {
+				failing = err != nil
+				if failing {
+					log.Printf("Error showing animation frame: %v", err)
+				}
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	d.stop, d.done = stop, done
+	return nil
+}
+
+func (d *EngineLEDDriver) Supports(mode AnimationMode) bool {
+	return slices.Contains(AnimationModes, mode)
+}
+
+// Stop the current animation, if any, and wait for it so it can't draw over whatever comes next.
+// d.mu must be held
+func (d *EngineLEDDriver) stopAnimation() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop, d.done = nil, nil
+}
+
+// Close stops any animation and closes the sink
+func (d *EngineLEDDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopAnimation()
+	return d.Sink.Close()
+}
+
+func (d *EngineLEDDriver) String() string {
+	return fmt.Sprintf("engine (showing frames with %s)", d.Sink)
+}
@@ -0,0 +1,181 @@
+package states
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// How many frames a second the Go animation engine renders
+const AnimationTickRate = 50
+
+// How long (in seconds, at normal speed) each part of the animations takes
+const (
+	breathePeriod  = 4.0 // A full breath in and out
+	rainbowPeriod  = 2.0 // The strip going through every hue, matching control_leds.py
+	gradientPeriod = 10.0
+	chaseStep      = 0.1 // Moving along one pixel
+	cyclePeriod    = 5.0 // Each animation in ModeCycle, matching control_leds.py
+)
+
+// The sizes of things in the chase and comet animations, matching control_leds.py
+const (
+	chaseSize    = 5
+	chaseSpacing = 3
+	cometTail    = 7
+)
+
+// The animations ModeCycle goes through in turn
+var cycleModes = []AnimationMode{ModeRainbow, ModeRainbowChase, ModeRainbowComet, ModeRainbowSparkle}
+
+// RenderAnimation renders a frame of the animation for a strip of numPixels LEDs. Frames only
+// depend on their tick (counted at AnimationTickRate from when the animation started), so the same
+// animation always plays the same way, wherever it's rendered
+func RenderAnimation(animation Animation, numPixels int, tick int) []Color {
+	if numPixels <= 0 {
+		return []Color{}
+	}
+	frame := make([]Color, numPixels)
+	// Seconds into the animation, at normal speed
+	t := float64(tick) / AnimationTickRate * animation.Speed
+	renderMode(frame, animation.Mode, animation.Color, t, tick)
+
+	for i := range frame {
+		frame[i] = frame[i].Scale(animation.Brightness)
+	}
+	return frame
+}
+
+func renderMode(frame []Color, mode AnimationMode, color Color, t float64, tick int) {
+	n := len(frame)
+	switch mode {
+	case ModeSolid:
+		fill(frame, color)
+
+	case ModeBreathe:
+		// Ease in and out rather than pulse linearly
+		fill(frame, color.Scale(0.5-0.5*math.Cos(2*math.Pi*t/breathePeriod)))
+
+	case ModeRainbow:
+		fill(frame, Hue(t/rainbowPeriod))
+
+	case ModeGradient:
+		// From the color to its opposite and back, along the strip and sliding slowly
+		opposite := color.Complement()
+		for i := range frame {
+			pos := float64(i)/float64(n) + t/gradientPeriod
+			frame[i] = color.Lerp(opposite, 0.5-0.5*math.Cos(2*math.Pi*pos))
+		}
+
+	case ModeChase, ModeRainbowChase:
+		offset := int(t / chaseStep)
+		for i := range frame {
+			if (i+offset)%(chaseSize+chaseSpacing) < chaseSize {
+				frame[i] = color
+				if mode == ModeRainbowChase {
+					frame[i] = Hue(float64(i)/float64(n) + t/rainbowPeriod)
+				}
+			}
+		}
+
+	case ModeComet, ModeRainbowComet:
+		// The head bounces from one end to the other, its tail fading behind it
+		span := n + cometTail
+		step := int(t/chaseStep) % (2 * span)
+		head, forwards := step, true
+		if step >= span {
+			head, forwards = 2*span-step-1, false
+		}
+		for k := range cometTail {
+			i := head - k
+			if !forwards {
+				i = head - cometTail + 1 + k
+			}
+			if i < 0 || i >= n {
+				continue
+			}
+			c := color
+			if mode == ModeRainbowComet {
+				c = Hue(float64(k) / cometTail)
+			}
+			frame[i] = c.Scale(1 - float64(k)/cometTail)
+		}
+
+	case ModeSparkle, ModeRainbowSparkle:
+		// A dim background with a few pixels lit up each frame, picked the same way every time
+		background := color.Scale(0.1)
+		if mode == ModeRainbowSparkle {
+			background = Hue(t / rainbowPeriod).Scale(0.1)
+		}
+		fill(frame, background)
+		sparkles := max(1, n/8)
+		for k := range sparkles {
+			i := int(sparkleHash(tick, k) % uint32(n))
+			frame[i] = color
+			if mode == ModeRainbowSparkle {
+				frame[i] = Hue(float64(i) / float64(n))
+			}
+		}
+
+	case ModeCycle:
+		part := int(t / cyclePeriod)
+		renderMode(frame, cycleModes[part%len(cycleModes)], color, t, tick)
+	}
+}
+
+func fill(frame []Color, color Color) {
+	for i := range frame {
+		frame[i] = color
+	}
+}
+
+// A pseudo-random number for the kth sparkle of a tick
+func sparkleHash(tick int, k int) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte{byte(tick), byte(tick >> 8), byte(tick >> 16), byte(tick >> 24), byte(k)})
+	return h.Sum32()
+}
+
+// Hue returns the fully saturated color with the hue, going round the color wheel from 0 (red) to 1
+// (red again)
+func Hue(hue float64) Color {
+	hue = hue - math.Floor(hue)
+	h := hue * 6
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return Color{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255)}
+}
+
+// Scale dims (or, above 1, brightens) the color
+func (c Color) Scale(f float64) Color {
+	scale := func(v uint8) uint8 {
+		return uint8(math.Round(math.Min(255, math.Max(0, float64(v)*f))))
+	}
+	return Color{R: scale(c.R), G: scale(c.G), B: scale(c.B)}
+}
+
+// Lerp mixes the color with other, from all c (at 0) to all other (at 1)
+func (c Color) Lerp(other Color, f float64) Color {
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f))
+	}
+	return Color{R: mix(c.R, other.R), G: mix(c.G, other.G), B: mix(c.B, other.B)}
+}
+
+// Complement returns the color opposite on the color wheel
+func (c Color) Complement() Color {
+	return Color{R: 255 - c.R, G: 255 - c.G, B: 255 - c.B}
+}
@@ -0,0 +1,131 @@
+package states
+
+import (
+	"slices"
+	"testing"
+)
+
+var white = Color{R: 255, G: 255, B: 255}
+
+// A tick at which the comet and chase animations have moved along step pixels, at normal speed. It's
+// halfway through the step, as rounding puts the ticks right at the start of one in either step
+func stepTick(step int) int {
+	return int((float64(step) + 0.5) * chaseStep * AnimationTickRate)
+}
+
+func TestRenderAnimationIsDeterministic(t *testing.T) {
+	const numPixels, tick = 24, 1234
+	for _, mode := range AnimationModes {
+		t.Run(string(mode), func(t *testing.T) {
+			animation := Animation{Mode: mode, Color: Color{R: 200, G: 100, B: 50}, Speed: 1.5, Brightness: 0.8}
+			first := RenderAnimation(animation, numPixels, tick)
+			if len(first) != numPixels {
+				t.Fatalf("rendered %d pixels, want %d", len(first), numPixels)
+			}
+			if second := RenderAnimation(animation, numPixels, tick); !slices.Equal(first, second) {
+				t.Errorf("rendering the same tick twice gave\n%v\nthen\n%v", first, second)
+			}
+			if slices.Equal(first, make([]Color, numPixels)) {
+				t.Errorf("tick %d is completely dark", tick)
+			}
+		})
+	}
+}
+
+func TestRenderAnimationWithoutPixels(t *testing.T) {
+	for _, numPixels := range []int{0, -1} {
+		frame := RenderAnimation(Animation{Mode: ModeComet, Color: white, Speed: 1, Brightness: 1}, numPixels, 10)
+		if frame == nil || len(frame) != 0 {
+			t.Errorf("RenderAnimation with %d pixels = %v, want an empty frame", numPixels, frame)
+		}
+	}
+}
+
+func TestRenderAnimationScalesBrightness(t *testing.T) {
+	frame := RenderAnimation(Animation{Mode: ModeSolid, Color: white, Speed: 1, Brightness: 0.5}, 3, 0)
+	want := Color{R: 128, G: 128, B: 128}
+	for i, c := range frame {
+		if c != want {
+			t.Errorf("pixel %d = %v, want %v", i, c, want)
+		}
+	}
+}
+
+func TestCometBouncesOffTheEnds(t *testing.T) {
+	const n = 10
+	const span = n + cometTail
+	animation := Animation{Mode: ModeComet, Color: white, Speed: 1, Brightness: 1}
+
+	tests := []struct {
+		name string
+		step int
+		lit  map[int]int // How far along the comet each lit pixel is, from its head at 0. The rest are dark
+	}{
+		{"setting off", 0, map[int]int{0: 0}},
+		{"head reaching the far end", n - 1, map[int]int{9: 0, 8: 1, 7: 2, 6: 3, 5: 4, 4: 5, 3: 6}},
+		{"tail leaving the far end", span - 1, map[int]int{}},
+		{"turned round, still off the end", span, map[int]int{}},
+		{"coming back", span + 1, map[int]int{9: 0}},
+		{"head reaching the near end", span + n, map[int]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 6: 6}},
+		{"tail leaving the near end", 2*span - 1, map[int]int{0: cometTail - 1}},
+		{"setting off again", 2 * span, map[int]int{0: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := RenderAnimation(animation, n, stepTick(tt.step))
+			for i, c := range frame {
+				var want Color
+				if k, lit := tt.lit[i]; lit {
+					want = white.Scale(1 - float64(k)/cometTail)
+				}
+				if c != want {
+					t.Errorf("pixel %d = %v, want %v", i, c, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCycleHandsOver(t *testing.T) {
+	const numPixels = 16
+	ticksPerPart := int(cyclePeriod * AnimationTickRate)
+	tests := []struct {
+		tick int
+		want AnimationMode
+	}{
+		{0, cycleModes[0]},
+		{ticksPerPart - 1, cycleModes[0]},
+		{ticksPerPart, cycleModes[1]},
+		{2*ticksPerPart - 1, cycleModes[1]},
+		{2 * ticksPerPart, cycleModes[2]},
+		{3 * ticksPerPart, cycleModes[3]},
+		{len(cycleModes) * ticksPerPart, cycleModes[0]},
+	}
+	for _, tt := range tests {
+		cycle := RenderAnimation(Animation{Mode: ModeCycle, Color: white, Speed: 1, Brightness: 1}, numPixels, tt.tick)
+		want := RenderAnimation(Animation{Mode: tt.want, Color: white, Speed: 1, Brightness: 1}, numPixels, tt.tick)
+		if !slices.Equal(cycle, want) {
+			t.Errorf("tick %d: cycle shows\n%v\nwant %s's\n%v", tt.tick, cycle, tt.want, want)
+		}
+	}
+}
+
+func TestHue(t *testing.T) {
+	red := Color{R: 255}
+	tests := []struct {
+		hue  float64
+		want Color
+	}{
+		{0, red},
+		{1, red},
+		{1.0 / 3, Color{G: 255}},
+		{2.0 / 3, Color{B: 255}},
+		{-1, red},
+		{2, red},
+	}
+	for _, tt := range tests {
+		if got := Hue(tt.hue); got != tt.want {
+			t.Errorf("Hue(%v) = %v, want %v", tt.hue, got, tt.want)
+		}
+	}
+}
@@ -3,78 +3,231 @@ package states
 import (
 	"fmt"
 	"log"
-	"os/exec"
-	"strings"
+	"sync"
 )
 
+// How many changes a light subscriber can fall behind by before it starts missing some
+const lightSubscriberBuffer = 16
+
+// LightState is what the light is showing at one moment, as sent to subscribers
+type LightState struct {
+	IsOn      bool
+	Color     Color
+	Animation Animation // Its Color is the light's color
+}
+
+func (ls LightState) String() string {
+	if !ls.IsOn {
+		return "off"
+	}
+	if ls.Animation.Mode != ModeSolid {
+		return fmt.Sprintf("%s at %gx speed, %g%% brightness", ls.Animation.Mode, ls.Animation.Speed, ls.Animation.Brightness*100)
+	}
+	return ls.Color.Hex()
+}
+
+// Light is safe to use from several goroutines. Every change is sent to its subscribers (see
+// Subscribe)
 type Light struct {
-	isOn  bool
-	red   int
-	green int
-	blue  int
-	cmd   *exec.Cmd
+	mu          sync.Mutex
+	isOn        bool
+	color       Color
+	animation   Animation
+	driver      LEDDriver
+	subscribers map[chan LightState]struct{}
 }
 
-func NewLight() *Light {
+func NewLight(driver LEDDriver) *Light {
+	log.Printf("Controlling the LEDs with the %s driver", driver)
 	return &Light{
-		red:   255,
-		green: 255,
-		blue:  255,
+		color:       Color{R: 255, G: 255, B: 255},
+		animation:   Animation{Mode: ModeSolid, Speed: 1, Brightness: 1},
+		driver:      driver,
+		subscribers: make(map[chan LightState]struct{}),
+	}
+}
+
+// State returns what the light is showing now
+func (l *Light) State() LightState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state()
+}
+
+// l.mu must be held
+func (l *Light) state() LightState {
+	animation := l.animation
+	animation.Color = l.color
+	return LightState{IsOn: l.isOn, Color: l.color, Animation: animation}
+}
+
+// Subscribe returns a channel that gets the light's new state whenever it changes. A subscriber that
+// falls too far behind misses the older changes, but always gets the latest one
+func (l *Light) Subscribe() chan LightState {
+	ch := make(chan LightState, lightSubscriberBuffer)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops sending changes to a channel from Subscribe
+func (l *Light) Unsubscribe(ch chan LightState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subscribers, ch)
+}
+
+// Show a change on the LEDs and tell the subscribers about it, if the state is different from
+// before. l.mu must be held
+func (l *Light) changed(before LightState) {
+	after := l.state()
+	if after == before {
+		return
+	}
+	// The LEDs stay dark while the light is off, whatever else changes
+	if before.IsOn || after.IsOn {
+		l.updateLights()
+	}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- after:
+		default:
+			// Make room by dropping the oldest change. Only we send to the channel, so there's room now
+			log.Println("Light change dropped: subscriber channel full")
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- after
+		}
 	}
 }
 
 func (l *Light) Hex() string {
-	return fmt.Sprintf("#%02x%02x%02x", l.red, l.green, l.blue)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.color.Hex()
 }
 
-func (l *Light) FromHex(hex string) {
-	fmt.Sscanf(hex, "#%02x%02x%02x", &l.red, &l.green, &l.blue)
-	if l.isOn {
-		l.updateLights(hex)
+// FromHex sets the color from #rrggbb, switching to ModeSolid to show it unless the animation
+// already uses the color. The change shows straight away if the light is on
+func (l *Light) FromHex(hex string) error {
+	color, err := ParseHex(hex)
+	if err != nil {
+		return err
 	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := l.state()
+	l.color = color
+	if !l.animation.Mode.UsesColor() {
+		l.animation.Mode = ModeSolid
+	}
+	l.changed(before)
+	return nil
+}
+
+// Animation returns the animation the light plays while it's on, with the light's color
+func (l *Light) Animation() Animation {
+	return l.State().Animation
+}
+
+// AnimationModes returns the modes the LED driver can play
+func (l *Light) AnimationModes() []AnimationMode {
+	var modes []AnimationMode
+	for _, mode := range AnimationModes {
+		if l.driver.Supports(mode) {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// SetAnimation switches to the animation, which shows straight away if the light is on. With
+// ModeSolid, the light goes back to its color. The animation's color is ignored, as the light's own
+// color is used
+func (l *Light) SetAnimation(animation Animation) error {
+	if err := animation.Validate(); err != nil {
+		return err
+	}
+	if !l.driver.Supports(animation.Mode) {
+		return fmt.Errorf("the %s LED driver can't play %s", l.driver, animation.Mode)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := l.state()
+	animation.Color = Color{}
+	l.animation = animation
+	l.changed(before)
+	return nil
 }
 
 func (l *Light) IsOn() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.isOn
 }
 
-func (l *Light) Toggle() {
-	if l.isOn {
-		l.TurnOff()
-	} else {
-		l.TurnOn()
-	}
+// Toggle turns the light off if it's on, or on if it's off, returning whether it's now on
+func (l *Light) Toggle() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := l.state()
+	l.isOn = !l.isOn
+	l.changed(before)
+	return l.isOn
 }
 
 func (l *Light) TurnOn() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := l.state()
 	l.isOn = true
-	l.updateLights(l.Hex())
+	l.changed(before)
 }
 
+// TurnOff turns the LEDs off, stopping any animation
 func (l *Light) TurnOff() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := l.state()
 	l.isOn = false
-	l.updateLights("#000000")
+	l.changed(before)
 }
 
-func (l *Light) updateLights(hex string) {
-	command := fmt.Sprintf("./scripts/control_leds.py D14 24 solid --color %s", strings.TrimPrefix(hex, "#"))
-
-	l.cmd = exec.Command("sh", "-c", command)
-	output, err := l.cmd.Output()
+// Show the light's state on the LEDs. Changes are shown in the order they're made, as l.mu must be
+// held
+func (l *Light) updateLights() {
+	var err error
+	switch {
+	case !l.isOn:
+		err = l.driver.Fill(Color{})
+	case l.animation.Mode == ModeSolid:
+		err = l.driver.Fill(l.color)
+	default:
+		err = l.driver.Animate(l.state().Animation)
+	}
 	if err != nil {
 		log.Printf("Error controlling LEDs: %v", err)
-	} else {
-		log.Println(string(output))
 	}
 }
 
+// Stop releases the LED driver, e.g. stopping its helper process. It starts up again when the light
+// next changes
 func (l *Light) Stop() error {
-	if l.cmd != nil && l.cmd.Process != nil {
-		err := l.cmd.Process.Kill()
-		if err != nil {
-			log.Println("Failed to kill light controller process:", err)
-			return err
-		}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.driver.Close(); err != nil {
+		log.Println("Failed to stop the LED driver:", err)
+		return err
 	}
 	return nil
 }
+
+func (l *Light) String() string {
+	return l.State().String()
+}
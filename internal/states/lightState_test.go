@@ -0,0 +1,129 @@
+package states
+
+import (
+	"slices"
+	"testing"
+)
+
+const testPixels = 4
+
+func newTestLight() (*Light, *FakeLEDDriver) {
+	driver := &FakeLEDDriver{NumPixels: testPixels}
+	return NewLight(driver), driver
+}
+
+func filled(color Color) []Color {
+	frame := make([]Color, testPixels)
+	fill(frame, color)
+	return frame
+}
+
+// The frame the driver is showing, failing if it's shown a different number of them than want
+func lastFrame(t *testing.T, driver *FakeLEDDriver, want int) []Color {
+	t.Helper()
+	frames := driver.Frames()
+	if len(frames) != want {
+		t.Fatalf("driver has shown %d frames (%v), want %d", len(frames), frames, want)
+	}
+	if want == 0 {
+		return nil
+	}
+	return frames[len(frames)-1]
+}
+
+// The changes sent to a subscriber so far
+func received(ch chan LightState) []LightState {
+	var states []LightState
+	for {
+		select {
+		case state := <-ch:
+			states = append(states, state)
+		default:
+			return states
+		}
+	}
+}
+
+func TestLightShowsChanges(t *testing.T) {
+	light, driver := newTestLight()
+	red, _ := ParseHex("#ff0000")
+	white := Color{R: 255, G: 255, B: 255}
+
+	light.TurnOn()
+	if frame := lastFrame(t, driver, 1); !slices.Equal(frame, filled(white)) {
+		t.Errorf("turned on: showing %v, want white", frame)
+	}
+	light.FromHex("#ff0000")
+	if frame := lastFrame(t, driver, 2); !slices.Equal(frame, filled(red)) {
+		t.Errorf("made red: showing %v, want red", frame)
+	}
+	// Nothing changes, so nothing new is shown
+	light.FromHex("#ff0000")
+	light.TurnOn()
+	lastFrame(t, driver, 2)
+
+	light.TurnOff()
+	if frame := lastFrame(t, driver, 3); !slices.Equal(frame, filled(Color{})) {
+		t.Errorf("turned off: showing %v, want dark", frame)
+	}
+	// The LEDs stay dark while the light's off
+	light.FromHex("#00ff00")
+	if err := light.SetAnimation(Animation{Mode: ModeBreathe, Speed: 2, Brightness: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	lastFrame(t, driver, 3)
+	if _, playing := driver.Animation(); playing {
+		t.Error("animation started while the light is off")
+	}
+
+	light.TurnOn()
+	want := Animation{Mode: ModeBreathe, Color: Color{G: 255}, Speed: 2, Brightness: 0.5}
+	if animation, playing := driver.Animation(); !playing || animation != want {
+		t.Errorf("turned on: playing %v (%v), want %v", animation, playing, want)
+	}
+	light.TurnOff()
+	if _, playing := driver.Animation(); playing {
+		t.Error("animation still playing after turning off")
+	}
+}
+
+func TestLightSubscribers(t *testing.T) {
+	light, _ := newTestLight()
+	ch := light.Subscribe()
+
+	light.TurnOn()
+	light.TurnOn()
+	light.FromHex("#0000ff")
+	light.FromHex("#0000ff")
+	light.Toggle()
+	want := []LightState{
+		{IsOn: true, Color: Color{R: 255, G: 255, B: 255}, Animation: Animation{Mode: ModeSolid, Color: Color{R: 255, G: 255, B: 255}, Speed: 1, Brightness: 1}},
+		{IsOn: true, Color: Color{B: 255}, Animation: Animation{Mode: ModeSolid, Color: Color{B: 255}, Speed: 1, Brightness: 1}},
+		{IsOn: false, Color: Color{B: 255}, Animation: Animation{Mode: ModeSolid, Color: Color{B: 255}, Speed: 1, Brightness: 1}},
+	}
+	if got := received(ch); !slices.Equal(got, want) {
+		t.Errorf("subscriber got\n%v\nwant only the changes\n%v", got, want)
+	}
+
+	light.Unsubscribe(ch)
+	light.Toggle()
+	if got := received(ch); len(got) != 0 {
+		t.Errorf("unsubscribed channel got %v", got)
+	}
+}
+
+func TestLightSubscriberFallingBehind(t *testing.T) {
+	light, _ := newTestLight()
+	ch := light.Subscribe()
+
+	for range lightSubscriberBuffer + 5 {
+		light.Toggle()
+	}
+	got := received(ch)
+	if len(got) != lightSubscriberBuffer {
+		t.Fatalf("subscriber got %d changes, want the last %d", len(got), lightSubscriberBuffer)
+	}
+	if latest := got[len(got)-1]; latest != light.State() {
+		t.Errorf("subscriber's latest change is %v, want %v", latest, light.State())
+	}
+}
@@ -0,0 +1,183 @@
+package states
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"time"
+)
+
+// TestPatternSource generates frames in Go rather than capturing them, so the camera (and everything
+// built on its frames) can be developed on a machine without any camera hardware. Each frame has
+// scrolling colour bars, a bouncing box and the current time drawn as a seven-segment clock
+type TestPatternSource struct{}
+
+func (s TestPatternSource) Open(settings CaptureSettings) (io.ReadCloser, error) {
+	width, height, fps := settings.Width, settings.Height, settings.Fps
+	if width <= 0 || height <= 0 {
+		width, height = 640, 480
+	}
+	if fps <= 0 {
+		fps = 30
+	}
+	quality := settings.Quality
+	if quality <= 0 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		ticker := time.NewTicker(time.Second / time.Duration(fps))
+		defer ticker.Stop()
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		var buf bytes.Buffer
+		for frameNum := 0; ; frameNum++ {
+			drawTestPattern(img, frameNum, time.Now())
+
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			// Fails once the camera closes the read end of the pipe
+			if _, err := pw.Write(buf.Bytes()); err != nil {
+				return
+			}
+			<-ticker.C
+		}
+	}()
+
+	return pr, nil
+}
+
+func (s TestPatternSource) String() string {
+	return "test pattern"
+}
+
+var testPatternBars = []color.RGBA{
+	{192, 192, 192, 255}, // grey
+	{192, 192, 0, 255},   // yellow
+	{0, 192, 192, 255},   // cyan
+	{0, 192, 0, 255},     // green
+	{192, 0, 192, 255},   // magenta
+	{192, 0, 0, 255},     // red
+	{0, 0, 192, 255},     // blue
+}
+
+// Draw a single frame of the test pattern into img
+func drawTestPattern(img *image.RGBA, frameNum int, now time.Time) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Colour bars scrolling one pixel per frame so motion is obvious
+	barWidth := max(width/len(testPatternBars), 1)
+	for x := 0; x < width; x++ {
+		bar := testPatternBars[((x+frameNum)/barWidth)%len(testPatternBars)]
+		for y := 0; y < height; y++ {
+			img.SetRGBA(x, y, bar)
+		}
+	}
+
+	// A white box bouncing between the edges of the frame
+	boxSize := max(height/8, 4)
+	boxX := bounce(frameNum*4, width-boxSize)
+	boxY := bounce(frameNum*3, height-boxSize)
+	fillRect(img, image.Rect(boxX, boxY, boxX+boxSize, boxY+boxSize), color.RGBA{255, 255, 255, 255})
+
+	// The current time, on a black panel so it stays legible over the bars
+	digitHeight := max(height/6, 14)
+	clock := now.Format("15:04:05")
+	clockWidth := sevenSegmentWidth(clock, digitHeight)
+	panel := image.Rect(0, 0, clockWidth, digitHeight).Inset(-digitHeight / 4).Add(image.Pt(digitHeight/2, digitHeight/2))
+	fillRect(img, panel, color.RGBA{0, 0, 0, 255})
+	drawSevenSegment(img, clock, digitHeight/2, digitHeight/2, digitHeight, color.RGBA{255, 64, 64, 255})
+}
+
+// Reflect pos back and forth between 0 and limit
+func bounce(pos, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	pos %= 2 * limit
+	if pos > limit {
+		return 2*limit - pos
+	}
+	return pos
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// Segments lit for each digit, in the order a (top), b, c, d (bottom), e, f, g (middle)
+var sevenSegmentDigits = map[rune][7]bool{
+	'0': {true, true, true, true, true, true, false},
+	'1': {false, true, true, false, false, false, false},
+	'2': {true, true, false, true, true, false, true},
+	'3': {true, true, true, true, false, false, true},
+	'4': {false, true, true, false, false, true, true},
+	'5': {true, false, true, true, false, true, true},
+	'6': {true, false, true, true, true, true, true},
+	'7': {true, true, true, false, false, false, false},
+	'8': {true, true, true, true, true, true, true},
+	'9': {true, true, true, true, false, true, true},
+}
+
+// The width in pixels of text drawn by drawSevenSegment with the given digit height
+func sevenSegmentWidth(text string, digitHeight int) int {
+	digitWidth := digitHeight / 2
+	gap := max(digitHeight/8, 1)
+	width := 0
+	for _, r := range text {
+		if r == ':' {
+			width += gap * 3
+		} else {
+			width += digitWidth + gap
+		}
+	}
+	return width
+}
+
+// Draw digits and colons as seven-segment characters with their top left corner at (x, y)
+func drawSevenSegment(img *image.RGBA, text string, x, y, digitHeight int, c color.RGBA) {
+	digitWidth := digitHeight / 2
+	thickness := max(digitHeight/10, 1)
+	gap := max(digitHeight/8, 1)
+	half := digitHeight / 2
+
+	for _, r := range text {
+		if r == ':' {
+			fillRect(img, image.Rect(x+gap, y+half/2, x+gap+thickness, y+half/2+thickness), c)
+			fillRect(img, image.Rect(x+gap, y+half+half/2, x+gap+thickness, y+half+half/2+thickness), c)
+			x += gap * 3
+			continue
+		}
+
+		segments, ok := sevenSegmentDigits[r]
+		if ok {
+			rects := [7]image.Rectangle{
+				image.Rect(x, y, x+digitWidth, y+thickness),                                   // a
+				image.Rect(x+digitWidth-thickness, y, x+digitWidth, y+half),                   // b
+				image.Rect(x+digitWidth-thickness, y+half, x+digitWidth, y+digitHeight),       // c
+				image.Rect(x, y+digitHeight-thickness, x+digitWidth, y+digitHeight),           // d
+				image.Rect(x, y+half, x+thickness, y+digitHeight),                             // e
+				image.Rect(x, y, x+thickness, y+half),                                         // f
+				image.Rect(x, y+half-thickness/2, x+digitWidth, y+half-thickness/2+thickness), // g
+			}
+			for i, lit := range segments {
+				if lit {
+					fillRect(img, rects[i], c)
+				}
+			}
+		}
+		x += digitWidth + gap
+	}
+}
@@ -0,0 +1,22 @@
+package apitokens
+
+import "fmt"
+
+type ErrAPITokenNotFound struct {
+	ID int64
+}
+
+func (e ErrAPITokenNotFound) Error() string {
+	if e.ID == 0 {
+		return "API token not found"
+	}
+	return fmt.Sprintf("API token with id %d not found", e.ID)
+}
+
+type ErrInvalidScope struct {
+	Scope string
+}
+
+func (e ErrInvalidScope) Error() string {
+	return fmt.Sprintf("invalid scope: %q", e.Scope)
+}
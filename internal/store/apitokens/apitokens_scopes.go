@@ -0,0 +1,28 @@
+package apitokens
+
+// Scope limits what an API token can be used for. A token can never do more than its user's role
+// allows, whatever its scope
+type Scope string
+
+const (
+	ScopeAll   Scope = "all"   // Anything the user can do
+	ScopeFeed  Scope = "feed"  // Only watch the feed and take snapshots
+	ScopeLight Scope = "light" // Only control the light
+)
+
+// All the scopes, for pickers
+var Scopes = []Scope{ScopeFeed, ScopeLight, ScopeAll}
+
+func ParseScope(str string) (Scope, error) {
+	for _, scope := range Scopes {
+		if string(scope) == str {
+			return scope, nil
+		}
+	}
+	return "", ErrInvalidScope{Scope: str}
+}
+
+// Allows reports whether a token with this scope may be used for something needing the other scope
+func (s Scope) Allows(other Scope) bool {
+	return s == ScopeAll || s == other
+}
@@ -0,0 +1,108 @@
+package apitokens
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/tokens"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type APITokenStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewAPITokenStore(queries *db.Queries, logger *log.Logger) *APITokenStore {
+	return &APITokenStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// AddToken stores a new token for the user. A zero expiresAt means the token never expires
+func (ts *APITokenStore) AddToken(ctx context.Context, token string, userId int64, name string, scope Scope, expiresAt time.Time) (db.ApiToken, error) {
+	if _, err := ParseScope(string(scope)); err != nil {
+		return db.ApiToken{}, err
+	}
+
+	apiToken, err := ts.queries.AddApiToken(ctx, db.AddApiTokenParams{
+		UserID:    userId,
+		Name:      name,
+		TokenHash: tokens.Hash(token),
+		Scope:     string(scope),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: sql.NullTime{Time: expiresAt.UTC(), Valid: !expiresAt.IsZero()},
+	})
+	if err != nil {
+		ts.logger.Printf("error adding API token: %v", err)
+		return db.ApiToken{}, err
+	}
+	return apiToken, nil
+}
+
+// GetTokenByToken finds the unexpired API token
+func (ts *APITokenStore) GetTokenByToken(ctx context.Context, token string) (db.ApiToken, error) {
+	apiToken, err := ts.queries.GetApiTokenByTokenHash(ctx, tokens.Hash(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.ApiToken{}, ErrAPITokenNotFound{}
+		}
+		ts.logger.Printf("error getting API token: %v", err)
+		return db.ApiToken{}, err
+	}
+	if apiToken.ExpiresAt.Valid && !apiToken.ExpiresAt.Time.After(time.Now()) {
+		return db.ApiToken{}, ErrAPITokenNotFound{ID: apiToken.ID}
+	}
+	return apiToken, nil
+}
+
+// GetTokensForUser gets all the user's API tokens, including expired ones, newest first
+func (ts *APITokenStore) GetTokensForUser(ctx context.Context, userId int64) ([]db.ApiToken, error) {
+	apiTokens, err := ts.queries.GetApiTokensForUser(ctx, userId)
+	if err != nil {
+		ts.logger.Printf("error getting API tokens for user: %v", err)
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+// TouchToken records that the token has just been used
+func (ts *APITokenStore) TouchToken(ctx context.Context, id int64) error {
+	err := ts.queries.TouchApiToken(ctx, db.TouchApiTokenParams{
+		LastUsed: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		ID:       id,
+	})
+	if err != nil {
+		ts.logger.Printf("error touching API token: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteTokenForUser revokes one of the user's tokens, returning ErrAPITokenNotFound if the token
+// doesn't exist or belongs to someone else
+func (ts *APITokenStore) DeleteTokenForUser(ctx context.Context, id int64, userId int64) error {
+	deleted, err := ts.queries.DeleteApiTokenForUser(ctx, db.DeleteApiTokenForUserParams{
+		ID:     id,
+		UserID: userId,
+	})
+	if err != nil {
+		ts.logger.Printf("error deleting API token for user: %v", err)
+		return err
+	}
+	if deleted == 0 {
+		return ErrAPITokenNotFound{ID: id}
+	}
+	return nil
+}
+
+// DeleteTokensForUser revokes all the user's tokens
+func (ts *APITokenStore) DeleteTokensForUser(ctx context.Context, userId int64) error {
+	if err := ts.queries.DeleteApiTokensForUser(ctx, userId); err != nil {
+		ts.logger.Printf("error deleting API tokens for user: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package audit
+
+// Action is what an audit log entry records being done
+type Action string
+
+const (
+	ActionLoginSucceeded  Action = "login_succeeded"
+	ActionLoginFailed     Action = "login_failed"
+	ActionLogout          Action = "logout"
+	ActionUserAdded       Action = "user_added"
+	ActionUserDeleted     Action = "user_deleted"
+	ActionPasswordChanged Action = "password_changed"
+	ActionPasswordReset   Action = "password_reset"
+	ActionLightToggled    Action = "light_toggled"
+	ActionColorChanged    Action = "color_changed"
+	ActionAnimationSet    Action = "animation_set"
+	ActionCameraStarted   Action = "camera_started"
+	ActionCameraStopped   Action = "camera_stopped"
+)
+
+// All the actions, for filters
+var Actions = []Action{
+	ActionLoginSucceeded,
+	ActionLoginFailed,
+	ActionLogout,
+	ActionUserAdded,
+	ActionUserDeleted,
+	ActionPasswordChanged,
+	ActionPasswordReset,
+	ActionLightToggled,
+	ActionColorChanged,
+	ActionAnimationSet,
+	ActionCameraStarted,
+	ActionCameraStopped,
+}
+
+func ParseAction(str string) (Action, error) {
+	for _, action := range Actions {
+		if string(action) == str {
+			return action, nil
+		}
+	}
+	return "", ErrInvalidAction{Action: str}
+}
+
+// Label describes the action for people
+func (a Action) Label() string {
+	switch a {
+	case ActionLoginSucceeded:
+		return "Logged in"
+	case ActionLoginFailed:
+		return "Failed to log in"
+	case ActionLogout:
+		return "Logged out"
+	case ActionUserAdded:
+		return "Added a user"
+	case ActionUserDeleted:
+		return "Deleted a user"
+	case ActionPasswordChanged:
+		return "Changed their password"
+	case ActionPasswordReset:
+		return "Reset a password"
+	case ActionLightToggled:
+		return "Toggled the light"
+	case ActionColorChanged:
+		return "Changed the light color"
+	case ActionAnimationSet:
+		return "Changed the light animation"
+	case ActionCameraStarted:
+		return "Camera started"
+	case ActionCameraStopped:
+		return "Camera stopped"
+	default:
+		return string(a)
+	}
+}
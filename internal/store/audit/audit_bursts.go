@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"catcam_go/internal/db"
+	"time"
+)
+
+// How close together the same person's color changes must be to be shown as one entry, as dragging
+// the color picker sends a change every 50ms
+const burstWindow = 10 * time.Second
+
+// Entry is an entry as shown in the log, which can stand for a burst of color changes
+type Entry struct {
+	db.AuditLog
+	Count int // How many entries it stands for, the newest of which it shows
+
+	oldest time.Time // When the oldest entry it stands for was made
+}
+
+// Collapse folds each burst of color changes by the same person (from the same address) into its
+// newest change. The log itself keeps every change. The entries must be newest first, as GetEntries
+// returns them
+func Collapse(entries []db.AuditLog) []Entry {
+	var collapsed []Entry
+	for _, entry := range entries {
+		if n := len(collapsed); n > 0 && collapsed[n-1].continuedBy(entry) {
+			collapsed[n-1].Count++
+			collapsed[n-1].oldest = entry.CreatedAt
+			continue
+		}
+		collapsed = append(collapsed, Entry{AuditLog: entry, Count: 1, oldest: entry.CreatedAt})
+	}
+	return collapsed
+}
+
+// Whether an older entry belongs to the same burst
+func (e Entry) continuedBy(older db.AuditLog) bool {
+	return e.Action == string(ActionColorChanged) && older.Action == e.Action &&
+		older.UserID == e.UserID && older.Username == e.Username && older.Ip == e.Ip &&
+		e.oldest.Sub(older.CreatedAt) < burstWindow
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"catcam_go/internal/db"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestCollapse(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tabby := sql.NullInt64{Int64: 1, Valid: true}
+	entry := func(seconds int, action Action, userID sql.NullInt64, username, ip, detail string) db.AuditLog {
+		return db.AuditLog{CreatedAt: start.Add(time.Duration(seconds) * time.Second), Action: string(action), UserID: userID, Username: username, Ip: ip, Detail: detail}
+	}
+
+	// Newest first
+	entries := []db.AuditLog{
+		entry(60, ActionColorChanged, tabby, "tabby", "10.0.0.1", "#0000ff"),
+		entry(52, ActionColorChanged, tabby, "tabby", "10.0.0.1", "#00ff00"),
+		entry(45, ActionColorChanged, tabby, "tabby", "10.0.0.1", "#ff0000"), // 15s after the burst's newest, but each step is under 10s
+		entry(40, ActionColorChanged, sql.NullInt64{}, "Grandma (guest)", "10.0.0.2", "#ffffff"),
+		entry(39, ActionColorChanged, sql.NullInt64{}, "Grandpa (guest)", "10.0.0.3", "#000000"),
+		entry(38, ActionColorChanged, sql.NullInt64{}, "Grandpa (guest)", "10.0.0.4", "#111111"),
+		entry(30, ActionLightToggled, tabby, "tabby", "10.0.0.1", "On"),
+		entry(29, ActionLightToggled, tabby, "tabby", "10.0.0.1", "Off"),
+		entry(10, ActionColorChanged, tabby, "tabby", "10.0.0.1", "#ff00ff"),
+		entry(0, ActionColorChanged, tabby, "tabby", "10.0.0.1", "#ffff00"), // 10s apart is too far
+	}
+	want := []struct {
+		detail string
+		count  int
+	}{
+		{"#0000ff", 3},
+		{"#ffffff", 1},
+		{"#000000", 1},
+		{"#111111", 1},
+		{"On", 1},
+		{"Off", 1},
+		{"#ff00ff", 1},
+		{"#ffff00", 1},
+	}
+
+	got := Collapse(entries)
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Detail != want[i].detail || got[i].Count != want[i].count {
+			t.Errorf("entry %d: %s x%d, want %s x%d", i, got[i].Detail, got[i].Count, want[i].detail, want[i].count)
+		}
+	}
+	if !got[0].CreatedAt.Equal(entries[0].CreatedAt) {
+		t.Errorf("burst shown at %v, want its newest change at %v", got[0].CreatedAt, entries[0].CreatedAt)
+	}
+}
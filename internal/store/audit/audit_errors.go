@@ -0,0 +1,11 @@
+package audit
+
+import "fmt"
+
+type ErrInvalidAction struct {
+	Action string
+}
+
+func (e ErrInvalidAction) Error() string {
+	return fmt.Sprintf("invalid audit action: %q", e.Action)
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Actor is who did something: a user, a guest or someone trying to log in (with no UserID), or
+// CatCam itself (with neither a UserID nor a Username)
+type Actor struct {
+	UserID   sql.NullInt64
+	Username string
+	IP       string
+}
+
+// Filter narrows down the entries GetEntries returns. Empty fields match everything
+type Filter struct {
+	Action   Action
+	Username string
+	Start    time.Time
+	End      time.Time
+}
+
+// The most entries GetEntries returns, so a busy log doesn't make an enormous page
+const MaxEntries = 500
+
+type AuditStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewAuditStore(queries *db.Queries, logger *log.Logger) *AuditStore {
+	return &AuditStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+func (as *AuditStore) AddEntry(ctx context.Context, action Action, actor Actor, detail string) (db.AuditLog, error) {
+	if _, err := ParseAction(string(action)); err != nil {
+		return db.AuditLog{}, err
+	}
+
+	entry, err := as.queries.AddAuditEntry(ctx, db.AddAuditEntryParams{
+		CreatedAt: time.Now().UTC(),
+		Action:    string(action),
+		UserID:    actor.UserID,
+		Username:  actor.Username,
+		Ip:        actor.IP,
+		Detail:    detail,
+	})
+	if err != nil {
+		as.logger.Printf("error adding audit entry: %v", err)
+		return db.AuditLog{}, err
+	}
+	return entry, nil
+}
+
+// GetEntries gets up to MaxEntries entries matching the filter, newest first
+func (as *AuditStore) GetEntries(ctx context.Context, filter Filter) ([]db.AuditLog, error) {
+	end := filter.End
+	if end.IsZero() {
+		end = time.Now().Add(time.Hour)
+	}
+	entries, err := as.queries.GetAuditEntries(ctx, db.GetAuditEntriesParams{
+		Action:     string(filter.Action),
+		Username:   filter.Username,
+		StartTime:  filter.Start.UTC(),
+		EndTime:    end.UTC(),
+		MaxEntries: MaxEntries,
+	})
+	if err != nil {
+		as.logger.Printf("error getting audit entries: %v", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetUsernames gets everyone who appears in the log, for filters
+func (as *AuditStore) GetUsernames(ctx context.Context) ([]string, error) {
+	usernames, err := as.queries.GetAuditUsernames(ctx)
+	if err != nil {
+		as.logger.Printf("error getting audit usernames: %v", err)
+		return nil, err
+	}
+	return usernames, nil
+}
+
+// DeleteEntriesBefore forgets entries older than the given time
+func (as *AuditStore) DeleteEntriesBefore(ctx context.Context, before time.Time) error {
+	if err := as.queries.DeleteAuditEntriesBefore(ctx, before.UTC()); err != nil {
+		as.logger.Printf("error deleting old audit entries: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package events
+
+import "fmt"
+
+type ErrEventNotFound struct {
+	ID int64
+}
+
+func (e ErrEventNotFound) Error() string {
+	return fmt.Sprintf("motion event with id %d not found", e.ID)
+}
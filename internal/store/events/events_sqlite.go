@@ -0,0 +1,86 @@
+package events
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type EventStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewEventStore(queries *db.Queries, logger *log.Logger) *EventStore {
+	return &EventStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// AddEvent records the start of a motion event whose clip (starting with the pre-roll, so before the
+// motion itself) is being written to clipPath
+func (es *EventStore) AddEvent(ctx context.Context, startedAt time.Time, clipPath string, clipStartedAt time.Time) (db.MotionEvent, error) {
+	event, err := es.queries.AddMotionEvent(ctx, db.AddMotionEventParams{
+		StartedAt:     startedAt.UTC(),
+		ClipPath:      clipPath,
+		ClipStartedAt: clipStartedAt.UTC(),
+	})
+	if err != nil {
+		es.logger.Printf("error adding motion event: %v", err)
+		return db.MotionEvent{}, err
+	}
+	return event, nil
+}
+
+func (es *EventStore) FinishEvent(ctx context.Context, id int64, endedAt time.Time, peakScore float64, frameCount int64, sizeBytes int64, thumbnail []byte) error {
+	err := es.queries.FinishMotionEvent(ctx, db.FinishMotionEventParams{
+		ID:         id,
+		EndedAt:    sql.NullTime{Time: endedAt.UTC(), Valid: true},
+		PeakScore:  peakScore,
+		FrameCount: frameCount,
+		SizeBytes:  sizeBytes,
+		Thumbnail:  thumbnail,
+	})
+	if err != nil {
+		es.logger.Printf("error finishing motion event: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (es *EventStore) GetEvent(ctx context.Context, id int64) (db.MotionEvent, error) {
+	event, err := es.queries.GetMotionEventById(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.MotionEvent{}, ErrEventNotFound{ID: id}
+		}
+		es.logger.Printf("error getting motion event: %v", err)
+		return db.MotionEvent{}, err
+	}
+	return event, nil
+}
+
+func (es *EventStore) GetEvents(ctx context.Context) ([]db.MotionEvent, error) {
+	events, err := es.queries.GetMotionEvents(ctx)
+	if err != nil {
+		es.logger.Printf("error getting motion events: %v", err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetEventsBetween returns the motion events that started in [start, end), newest first
+func (es *EventStore) GetEventsBetween(ctx context.Context, start time.Time, end time.Time) ([]db.MotionEvent, error) {
+	events, err := es.queries.GetMotionEventsBetween(ctx, db.GetMotionEventsBetweenParams{
+		StartTime: start.UTC(),
+		EndTime:   end.UTC(),
+	})
+	if err != nil {
+		es.logger.Printf("error getting motion events between %v and %v: %v", start, end, err)
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,11 @@
+package lockouts
+
+import "fmt"
+
+type ErrLockoutNotFound struct {
+	ID int64
+}
+
+func (e ErrLockoutNotFound) Error() string {
+	return fmt.Sprintf("lockout with id %d not found", e.ID)
+}
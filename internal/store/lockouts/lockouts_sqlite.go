@@ -0,0 +1,121 @@
+package lockouts
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Kind is what failed logins are being counted against
+type Kind string
+
+const (
+	KindUsername Kind = "username"
+	KindIP       Kind = "ip"
+)
+
+type LockoutStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewLockoutStore(queries *db.Queries, logger *log.Logger) *LockoutStore {
+	return &LockoutStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// GetLockout gets the failed logins counted against the subject. A subject with none gets a zero
+// db.Lockout rather than an error
+func (ls *LockoutStore) GetLockout(ctx context.Context, kind Kind, subject string) (db.Lockout, error) {
+	lockout, err := ls.queries.GetLockout(ctx, db.GetLockoutParams{
+		Kind:    string(kind),
+		Subject: subject,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Lockout{Kind: string(kind), Subject: subject}, nil
+		}
+		ls.logger.Printf("error getting lockout: %v", err)
+		return db.Lockout{}, err
+	}
+	return lockout, nil
+}
+
+func (ls *LockoutStore) GetLockouts(ctx context.Context) ([]db.Lockout, error) {
+	lockouts, err := ls.queries.GetLockouts(ctx)
+	if err != nil {
+		ls.logger.Printf("error getting lockouts: %v", err)
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// RecordFailure counts another failed login against the subject, locking it out for lockFor once
+// there have been threshold failures in a row. Failures are forgotten once lockFor has passed
+// without another
+func (ls *LockoutStore) RecordFailure(ctx context.Context, kind Kind, subject string, threshold int64, lockFor time.Duration) (db.Lockout, error) {
+	now := time.Now()
+
+	// Forget about the failures nobody has followed up on, including everyone else's
+	if err := ls.queries.DeleteLockoutsLastFailedBefore(ctx, now.Add(-lockFor).UTC()); err != nil {
+		ls.logger.Printf("error deleting old lockouts: %v", err)
+		return db.Lockout{}, err
+	}
+
+	lockout, err := ls.GetLockout(ctx, kind, subject)
+	if err != nil {
+		return db.Lockout{}, err
+	}
+
+	failures := lockout.Failures + 1
+	var lockedUntil sql.NullTime
+	if failures >= threshold {
+		lockedUntil = sql.NullTime{Time: now.Add(lockFor).UTC(), Valid: true}
+	}
+
+	lockout, err = ls.queries.SetLockout(ctx, db.SetLockoutParams{
+		Kind:        string(kind),
+		Subject:     subject,
+		Failures:    failures,
+		LastFailure: now.UTC(),
+		LockedUntil: lockedUntil,
+	})
+	if err != nil {
+		ls.logger.Printf("error recording failed login: %v", err)
+		return db.Lockout{}, err
+	}
+	if lockedUntil.Valid && failures == threshold {
+		ls.logger.Printf("locked out %s %s after %d failed logins", kind, subject, failures)
+	}
+	return lockout, nil
+}
+
+// ClearLockout forgets the failed logins counted against the subject, e.g. once it logs in
+func (ls *LockoutStore) ClearLockout(ctx context.Context, kind Kind, subject string) error {
+	err := ls.queries.ClearLockout(ctx, db.ClearLockoutParams{
+		Kind:    string(kind),
+		Subject: subject,
+	})
+	if err != nil {
+		ls.logger.Printf("error clearing lockout: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteLockout unlocks a subject, forgetting its failed logins
+func (ls *LockoutStore) DeleteLockout(ctx context.Context, id int64) error {
+	deleted, err := ls.queries.DeleteLockout(ctx, id)
+	if err != nil {
+		ls.logger.Printf("error deleting lockout: %v", err)
+		return err
+	}
+	if deleted <= 0 {
+		return ErrLockoutNotFound{ID: id}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package recordings
+
+import "fmt"
+
+type ErrRecordingNotFound struct {
+	ID int64
+}
+
+func (e ErrRecordingNotFound) Error() string {
+	return fmt.Sprintf("recording with id %d not found", e.ID)
+}
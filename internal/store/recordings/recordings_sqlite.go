@@ -0,0 +1,98 @@
+package recordings
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type RecordingStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewRecordingStore(queries *db.Queries, logger *log.Logger) *RecordingStore {
+	return &RecordingStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+func (rs *RecordingStore) AddRecording(ctx context.Context, path string, startedAt time.Time) (db.Recording, error) {
+	recording, err := rs.queries.AddRecording(ctx, db.AddRecordingParams{
+		Path:      path,
+		StartedAt: startedAt.UTC(),
+	})
+	if err != nil {
+		rs.logger.Printf("error adding recording: %v", err)
+		return db.Recording{}, err
+	}
+	return recording, nil
+}
+
+func (rs *RecordingStore) FinishRecording(ctx context.Context, id int64, endedAt time.Time, frameCount int64, sizeBytes int64) error {
+	err := rs.queries.FinishRecording(ctx, db.FinishRecordingParams{
+		ID:         id,
+		EndedAt:    sql.NullTime{Time: endedAt.UTC(), Valid: true},
+		FrameCount: frameCount,
+		SizeBytes:  sizeBytes,
+	})
+	if err != nil {
+		rs.logger.Printf("error finishing recording: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (rs *RecordingStore) GetRecordings(ctx context.Context) ([]db.Recording, error) {
+	recordings, err := rs.queries.GetRecordings(ctx)
+	if err != nil {
+		rs.logger.Printf("error getting recordings: %v", err)
+		return nil, err
+	}
+	return recordings, nil
+}
+
+// GetFinishedRecordingsOldestFirst returns every recording that is no longer being written to
+func (rs *RecordingStore) GetFinishedRecordingsOldestFirst(ctx context.Context) ([]db.Recording, error) {
+	recordings, err := rs.queries.GetRecordingsOldestFirst(ctx)
+	if err != nil {
+		rs.logger.Printf("error getting recordings oldest first: %v", err)
+		return nil, err
+	}
+	return recordings, nil
+}
+
+// GetFinishedRecordingsStartedBefore returns every recording that is no longer being written to and
+// started before the given time
+func (rs *RecordingStore) GetFinishedRecordingsStartedBefore(ctx context.Context, t time.Time) ([]db.Recording, error) {
+	recordings, err := rs.queries.GetRecordingsStartedBefore(ctx, t.UTC())
+	if err != nil {
+		rs.logger.Printf("error getting recordings started before %v: %v", t, err)
+		return nil, err
+	}
+	return recordings, nil
+}
+
+func (rs *RecordingStore) TotalSize(ctx context.Context) (int64, error) {
+	size, err := rs.queries.GetRecordingsTotalSize(ctx)
+	if err != nil {
+		rs.logger.Printf("error getting total size of recordings: %v", err)
+		return 0, err
+	}
+	return size, nil
+}
+
+func (rs *RecordingStore) DeleteRecording(ctx context.Context, id int64) (db.Recording, error) {
+	recording, err := rs.queries.DeleteRecording(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Recording{}, ErrRecordingNotFound{ID: id}
+		}
+		rs.logger.Printf("error deleting recording: %v", err)
+		return db.Recording{}, err
+	}
+	return recording, nil
+}
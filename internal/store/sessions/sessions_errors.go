@@ -0,0 +1,14 @@
+package sessions
+
+import "fmt"
+
+type ErrSessionNotFound struct {
+	ID int64
+}
+
+func (e ErrSessionNotFound) Error() string {
+	if e.ID == 0 {
+		return "session not found"
+	}
+	return fmt.Sprintf("session with id %d not found", e.ID)
+}
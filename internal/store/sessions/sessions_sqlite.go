@@ -0,0 +1,152 @@
+package sessions
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/tokens"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type SessionStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewSessionStore(queries *db.Queries, logger *log.Logger) *SessionStore {
+	return &SessionStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+func nullUserId(userId int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: userId, Valid: userId != 0}
+}
+
+// AddSession stores a new session for the token. The user ID is zero if nobody is logged in
+func (ss *SessionStore) AddSession(ctx context.Context, token string, userId int64, data []byte, ip string, userAgent string, expiresAt time.Time) (db.Session, error) {
+	now := time.Now().UTC()
+	session, err := ss.queries.AddSession(ctx, db.AddSessionParams{
+		TokenHash: tokens.Hash(token),
+		UserID:    nullUserId(userId),
+		Data:      data,
+		Ip:        ip,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		ss.logger.Printf("error adding session: %v", err)
+		return db.Session{}, err
+	}
+	return session, nil
+}
+
+func (ss *SessionStore) UpdateSession(ctx context.Context, id int64, userId int64, data []byte, ip string, userAgent string, expiresAt time.Time) error {
+	err := ss.queries.UpdateSession(ctx, db.UpdateSessionParams{
+		ID:        id,
+		UserID:    nullUserId(userId),
+		Data:      data,
+		Ip:        ip,
+		UserAgent: userAgent,
+		LastSeen:  time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		ss.logger.Printf("error updating session: %v", err)
+		return err
+	}
+	return nil
+}
+
+// TouchSession records that the session has just been used, and from where
+func (ss *SessionStore) TouchSession(ctx context.Context, id int64, ip string, userAgent string) error {
+	err := ss.queries.TouchSession(ctx, db.TouchSessionParams{
+		ID:        id,
+		Ip:        ip,
+		UserAgent: userAgent,
+		LastSeen:  time.Now().UTC(),
+	})
+	if err != nil {
+		ss.logger.Printf("error touching session: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetSessionByToken finds the unexpired session for the token
+func (ss *SessionStore) GetSessionByToken(ctx context.Context, token string) (db.Session, error) {
+	session, err := ss.queries.GetSessionByTokenHash(ctx, tokens.Hash(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Session{}, ErrSessionNotFound{}
+		}
+		ss.logger.Printf("error getting session: %v", err)
+		return db.Session{}, err
+	}
+	if !session.ExpiresAt.After(time.Now()) {
+		return db.Session{}, ErrSessionNotFound{ID: session.ID}
+	}
+	return session, nil
+}
+
+// GetSessionsForUser gets the user's unexpired sessions, most recently used first
+func (ss *SessionStore) GetSessionsForUser(ctx context.Context, userId int64) ([]db.Session, error) {
+	sessions, err := ss.queries.GetSessionsForUser(ctx, db.GetSessionsForUserParams{
+		UserID:    nullUserId(userId),
+		ExpiresAt: time.Now().UTC(),
+	})
+	if err != nil {
+		ss.logger.Printf("error getting sessions for user: %v", err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (ss *SessionStore) DeleteSession(ctx context.Context, id int64) error {
+	err := ss.queries.DeleteSession(ctx, id)
+	if err != nil {
+		ss.logger.Printf("error deleting session: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteSessionForUser deletes one of the user's sessions, returning ErrSessionNotFound if the
+// session doesn't exist or belongs to someone else
+func (ss *SessionStore) DeleteSessionForUser(ctx context.Context, id int64, userId int64) error {
+	deleted, err := ss.queries.DeleteSessionForUser(ctx, db.DeleteSessionForUserParams{
+		ID:     id,
+		UserID: nullUserId(userId),
+	})
+	if err != nil {
+		ss.logger.Printf("error deleting session for user: %v", err)
+		return err
+	}
+	if deleted <= 0 {
+		return ErrSessionNotFound{ID: id}
+	}
+	return nil
+}
+
+// DeleteSessionsForUser signs the user out everywhere
+func (ss *SessionStore) DeleteSessionsForUser(ctx context.Context, userId int64) error {
+	err := ss.queries.DeleteSessionsForUser(ctx, nullUserId(userId))
+	if err != nil {
+		ss.logger.Printf("error deleting sessions for user: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (ss *SessionStore) DeleteExpiredSessions(ctx context.Context) error {
+	err := ss.queries.DeleteExpiredSessions(ctx, time.Now().UTC())
+	if err != nil {
+		ss.logger.Printf("error deleting expired sessions: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package sharelinks
+
+import "fmt"
+
+type ErrShareLinkNotFound struct {
+	ID int64
+}
+
+func (e ErrShareLinkNotFound) Error() string {
+	return fmt.Sprintf("share link with id %d not found", e.ID)
+}
@@ -0,0 +1,84 @@
+package sharelinks
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type ShareLinkStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewShareLinkStore(queries *db.Queries, logger *log.Logger) *ShareLinkStore {
+	return &ShareLinkStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// AddShareLink stores the settings for a new share link. maxViewers is how many guests can watch at
+// once with it, or zero for no limit
+func (ss *ShareLinkStore) AddShareLink(ctx context.Context, name string, createdBy int64, expiresAt time.Time, maxViewers int64, allowLight bool) (db.ShareLink, error) {
+	// Tidy up while we're making a new one
+	if err := ss.queries.DeleteShareLinksExpiredBefore(ctx, time.Now().UTC()); err != nil {
+		ss.logger.Printf("error deleting expired share links: %v", err)
+	}
+
+	link, err := ss.queries.AddShareLink(ctx, db.AddShareLinkParams{
+		Name:       name,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now().UTC(),
+		ExpiresAt:  expiresAt.UTC(),
+		MaxViewers: maxViewers,
+		AllowLight: allowLight,
+	})
+	if err != nil {
+		ss.logger.Printf("error adding share link: %v", err)
+		return db.ShareLink{}, err
+	}
+	return link, nil
+}
+
+// GetShareLink gets the share link, returning ErrShareLinkNotFound if it has been revoked or has
+// expired
+func (ss *ShareLinkStore) GetShareLink(ctx context.Context, id int64) (db.ShareLink, error) {
+	link, err := ss.queries.GetShareLink(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.ShareLink{}, ErrShareLinkNotFound{ID: id}
+		}
+		ss.logger.Printf("error getting share link: %v", err)
+		return db.ShareLink{}, err
+	}
+	if !link.ExpiresAt.After(time.Now()) {
+		return db.ShareLink{}, ErrShareLinkNotFound{ID: id}
+	}
+	return link, nil
+}
+
+// GetShareLinks gets all the share links, including expired ones that haven't been tidied up yet,
+// newest first
+func (ss *ShareLinkStore) GetShareLinks(ctx context.Context) ([]db.ShareLink, error) {
+	links, err := ss.queries.GetShareLinks(ctx)
+	if err != nil {
+		ss.logger.Printf("error getting share links: %v", err)
+		return nil, err
+	}
+	return links, nil
+}
+
+func (ss *ShareLinkStore) DeleteShareLink(ctx context.Context, id int64) error {
+	deleted, err := ss.queries.DeleteShareLink(ctx, id)
+	if err != nil {
+		ss.logger.Printf("error deleting share link: %v", err)
+		return err
+	}
+	if deleted == 0 {
+		return ErrShareLinkNotFound{ID: id}
+	}
+	return nil
+}
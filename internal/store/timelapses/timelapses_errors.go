@@ -0,0 +1,11 @@
+package timelapses
+
+import "fmt"
+
+type ErrTimelapseNotFound struct {
+	ID int64
+}
+
+func (e ErrTimelapseNotFound) Error() string {
+	return fmt.Sprintf("timelapse with id %d not found", e.ID)
+}
@@ -0,0 +1,59 @@
+package timelapses
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+type TimelapseStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewTimelapseStore(queries *db.Queries, logger *log.Logger) *TimelapseStore {
+	return &TimelapseStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// AddTimelapse records the video assembled for a day (YYYY-MM-DD), replacing any previous one for
+// the same day
+func (ts *TimelapseStore) AddTimelapse(ctx context.Context, day string, path string, frameCount int64, sizeBytes int64) (db.Timelapse, error) {
+	timelapse, err := ts.queries.AddTimelapse(ctx, db.AddTimelapseParams{
+		Day:        day,
+		Path:       path,
+		FrameCount: frameCount,
+		SizeBytes:  sizeBytes,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		ts.logger.Printf("error adding timelapse: %v", err)
+		return db.Timelapse{}, err
+	}
+	return timelapse, nil
+}
+
+func (ts *TimelapseStore) GetTimelapse(ctx context.Context, id int64) (db.Timelapse, error) {
+	timelapse, err := ts.queries.GetTimelapseById(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Timelapse{}, ErrTimelapseNotFound{ID: id}
+		}
+		ts.logger.Printf("error getting timelapse: %v", err)
+		return db.Timelapse{}, err
+	}
+	return timelapse, nil
+}
+
+func (ts *TimelapseStore) GetTimelapses(ctx context.Context) ([]db.Timelapse, error) {
+	timelapses, err := ts.queries.GetTimelapses(ctx)
+	if err != nil {
+		ts.logger.Printf("error getting timelapses: %v", err)
+		return nil, err
+	}
+	return timelapses, nil
+}
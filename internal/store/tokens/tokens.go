@@ -0,0 +1,14 @@
+// Package tokens hashes the secrets CatCam hands out (session tokens, API tokens and recovery codes),
+// as only their hashes are stored, so they can't be lifted from the database
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hash is what's stored in place of a token
+func Hash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
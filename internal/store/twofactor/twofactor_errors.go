@@ -0,0 +1,11 @@
+package twofactor
+
+import "fmt"
+
+type ErrTotpNotFound struct {
+	UserID int64
+}
+
+func (e ErrTotpNotFound) Error() string {
+	return fmt.Sprintf("user with id %d has not set up two-factor authentication", e.UserID)
+}
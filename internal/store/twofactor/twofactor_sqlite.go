@@ -0,0 +1,141 @@
+package twofactor
+
+import (
+	"catcam_go/internal/db"
+	"catcam_go/internal/store/tokens"
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+type TwoFactorStore struct {
+	queries *db.Queries
+	logger  *log.Logger
+}
+
+func NewTwoFactorStore(queries *db.Queries, logger *log.Logger) *TwoFactorStore {
+	return &TwoFactorStore{
+		logger:  logger,
+		queries: queries,
+	}
+}
+
+// HashRecoveryCode is what's stored in place of a recovery code. Dashes, spaces and case are ignored
+// so the code can be typed however it was written down
+func HashRecoveryCode(code string) string {
+	return tokens.Hash(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+}
+
+// GetTotp gets the user's TOTP secret, returning ErrTotpNotFound if they haven't set it up
+func (ts *TwoFactorStore) GetTotp(ctx context.Context, userId int64) (db.Totp, error) {
+	totp, err := ts.queries.GetTotp(ctx, userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return db.Totp{}, ErrTotpNotFound{UserID: userId}
+		}
+		ts.logger.Printf("error getting totp: %v", err)
+		return db.Totp{}, err
+	}
+	return totp, nil
+}
+
+// HasTotp reports whether the user has set up two-factor authentication
+func (ts *TwoFactorStore) HasTotp(ctx context.Context, userId int64) (bool, error) {
+	_, err := ts.GetTotp(ctx, userId)
+	if err != nil {
+		if _, ok := err.(ErrTotpNotFound); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// EnableTotp turns on two-factor authentication for the user with the secret, replacing any
+// recovery codes they had with the new ones. step is the time step of the code that confirmed the
+// secret, so that code can't be used again to log in
+func (ts *TwoFactorStore) EnableTotp(ctx context.Context, userId int64, secret string, step int64, recoveryCodes []string) error {
+	err := ts.queries.SetTotp(ctx, db.SetTotpParams{
+		UserID:    userId,
+		Secret:    secret,
+		LastStep:  step,
+		EnabledAt: time.Now().UTC(),
+	})
+	if err != nil {
+		ts.logger.Printf("error setting totp: %v", err)
+		return err
+	}
+	return ts.ReplaceRecoveryCodes(ctx, userId, recoveryCodes)
+}
+
+// DisableTotp turns off two-factor authentication for the user and throws away their recovery codes
+func (ts *TwoFactorStore) DisableTotp(ctx context.Context, userId int64) error {
+	if err := ts.queries.DeleteTotp(ctx, userId); err != nil {
+		ts.logger.Printf("error deleting totp: %v", err)
+		return err
+	}
+	if err := ts.queries.DeleteRecoveryCodes(ctx, userId); err != nil {
+		ts.logger.Printf("error deleting recovery codes: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UseTotpStep marks the time step as used for the user. It returns false if that step, or a later
+// one, has already been used, i.e. the code is being replayed
+func (ts *TwoFactorStore) UseTotpStep(ctx context.Context, userId int64, step int64) (bool, error) {
+	updated, err := ts.queries.SetTotpLastStep(ctx, db.SetTotpLastStepParams{
+		LastStep:   step,
+		UserID:     userId,
+		LastStep_2: step,
+	})
+	if err != nil {
+		ts.logger.Printf("error setting totp last step: %v", err)
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+// ReplaceRecoveryCodes throws away the user's recovery codes and stores hashes of the new ones
+func (ts *TwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userId int64, codes []string) error {
+	if err := ts.queries.DeleteRecoveryCodes(ctx, userId); err != nil {
+		ts.logger.Printf("error deleting recovery codes: %v", err)
+		return err
+	}
+	for _, code := range codes {
+		err := ts.queries.AddRecoveryCode(ctx, db.AddRecoveryCodeParams{
+			UserID:   userId,
+			CodeHash: HashRecoveryCode(code),
+		})
+		if err != nil {
+			ts.logger.Printf("error adding recovery code: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. It returns false if the code isn't one of
+// theirs, or has already been used
+func (ts *TwoFactorStore) UseRecoveryCode(ctx context.Context, userId int64, code string) (bool, error) {
+	deleted, err := ts.queries.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+		UserID:   userId,
+		CodeHash: HashRecoveryCode(code),
+	})
+	if err != nil {
+		ts.logger.Printf("error using recovery code: %v", err)
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (ts *TwoFactorStore) CountRecoveryCodes(ctx context.Context, userId int64) (int64, error) {
+	count, err := ts.queries.CountRecoveryCodes(ctx, userId)
+	if err != nil {
+		ts.logger.Printf("error counting recovery codes: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
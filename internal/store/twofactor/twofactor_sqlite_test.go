@@ -0,0 +1,96 @@
+package twofactor
+
+import (
+	"catcam_go/internal/db"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// Make a store backed by a fresh database with one user in it, returning the user's ID
+func newTestStore(t *testing.T) (*TwoFactorStore, int64) {
+	t.Helper()
+	dbPool, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { dbPool.Close() })
+
+	logger := log.New(io.Discard, "", 0)
+	if err := db.Migrate(context.Background(), dbPool, logger); err != nil {
+		t.Fatalf("migrating database: %v", err)
+	}
+	queries := db.New(dbPool)
+	user, err := queries.AddUser(context.Background(), db.AddUserParams{Username: "tabby", PasswordHash: "x", Role: "viewer"})
+	if err != nil {
+		t.Fatalf("adding user: %v", err)
+	}
+	return NewTwoFactorStore(queries, logger), user.ID
+}
+
+func TestUseTotpStepRejectsReplays(t *testing.T) {
+	store, userId := newTestStore(t)
+	ctx := context.Background()
+	const enabledStep = 1000
+	if err := store.EnableTotp(ctx, userId, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", enabledStep, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"the code that turned it on", enabledStep, false},
+		{"an earlier code", enabledStep - 1, false},
+		{"the next code", enabledStep + 1, true},
+		{"the same code again", enabledStep + 1, false},
+		{"a code a while later", enabledStep + 10, true},
+		{"a code from before that", enabledStep + 5, false},
+	}
+	for _, tt := range steps {
+		ok, err := store.UseTotpStep(ctx, userId, tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.wantOK {
+			t.Errorf("%s: UseTotpStep(%d) = %v, want %v", tt.name, tt.step, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestRecoveryCodeWorksOnce(t *testing.T) {
+	store, userId := newTestStore(t)
+	ctx := context.Background()
+	if err := store.EnableTotp(ctx, userId, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 0, []string{"abcde-fghij", "klmno-pqrst"}); err != nil {
+		t.Fatal(err)
+	}
+
+	uses := []struct {
+		code   string
+		wantOK bool
+	}{
+		{"ABCDE FGHIJ", true}, // However it was written down
+		{"abcde-fghij", false},
+		{"not-a-code", false},
+		{"klmnopqrst", true},
+	}
+	for _, use := range uses {
+		ok, err := store.UseRecoveryCode(ctx, userId, use.code)
+		if err != nil {
+			t.Fatalf("UseRecoveryCode(%q): %v", use.code, err)
+		}
+		if ok != use.wantOK {
+			t.Errorf("UseRecoveryCode(%q) = %v, want %v", use.code, ok, use.wantOK)
+		}
+	}
+
+	if count, err := store.CountRecoveryCodes(ctx, userId); err != nil || count != 0 {
+		t.Errorf("CountRecoveryCodes = %d, %v, want 0 left", count, err)
+	}
+}
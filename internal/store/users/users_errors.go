@@ -34,3 +34,19 @@ type ErrMissingField struct {
 func (e ErrMissingField) Error() string {
 	return fmt.Sprintf("missing field: %s", e.Field)
 }
+
+type ErrInvalidRole struct {
+	Role string
+}
+
+func (e ErrInvalidRole) Error() string {
+	return fmt.Sprintf("invalid role: %q", e.Role)
+}
+
+type ErrLastAdmin struct {
+	ID int64
+}
+
+func (e ErrLastAdmin) Error() string {
+	return fmt.Sprintf("user with id %d is the last admin", e.ID)
+}
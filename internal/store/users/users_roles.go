@@ -0,0 +1,36 @@
+package users
+
+// Role decides what a user is allowed to do. Each role can do everything the roles below it can
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // Can watch the feed and look through events and timelapses
+	RoleOperator Role = "operator" // Can also control the light
+	RoleAdmin    Role = "admin"    // Can also manage users
+)
+
+// All the roles, from least to most privileged
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+func ParseRole(str string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == str {
+			return role, nil
+		}
+	}
+	return "", ErrInvalidRole{Role: str}
+}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Includes reports whether someone with this role may do what the other role can
+func (r Role) Includes(other Role) bool {
+	return r.rank() >= 0 && r.rank() >= other.rank()
+}
@@ -29,6 +29,9 @@ func (us *UserStore) AddUser(ctx context.Context, params db.AddUserParams) (db.U
 	if params.Username == "" {
 		return zero, ErrMissingField{Field: "username"}
 	}
+	if _, err := ParseRole(params.Role); err != nil {
+		return zero, err
+	}
 
 	// Normalize username
 	params.Username = strings.ToLower(params.Username)
@@ -104,6 +107,11 @@ func (us *UserStore) GetUserByUsername(ctx context.Context, username string) (db
 func (us *UserStore) DeleteUser(ctx context.Context, id int64) (db.User, error) {
 	zero := db.User{}
 
+	// Someone has to be left to manage the users
+	if err := us.checkNotLastAdmin(ctx, id); err != nil {
+		return zero, err
+	}
+
 	user, err := us.queries.DeleteUser(ctx, id)
 	if err != nil {
 		if sqlErr, ok := err.(*sqlite.Error); ok {
@@ -136,3 +144,63 @@ func (us *UserStore) SetUserLastLogin(ctx context.Context, id int64) error {
 	}
 	return nil
 }
+
+func (us *UserStore) SetUserRole(ctx context.Context, id int64, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	if role != RoleAdmin {
+		if err := us.checkNotLastAdmin(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	err := us.queries.SetUserRole(ctx, db.SetUserRoleParams{
+		Role: string(role),
+		ID:   id,
+	})
+	if err != nil {
+		us.logger.Printf("error setting user role: %v", err)
+		return err
+	}
+	return nil
+}
+
+// SetUserPassword replaces the user's password hash. mustChange makes them choose another password
+// as soon as they next log in
+func (us *UserStore) SetUserPassword(ctx context.Context, id int64, passwordHash string, mustChange bool) error {
+	updated, err := us.queries.SetUserPassword(ctx, db.SetUserPasswordParams{
+		PasswordHash:       passwordHash,
+		MustChangePassword: mustChange,
+		ID:                 id,
+	})
+	if err != nil {
+		us.logger.Printf("error setting user password: %v", err)
+		return err
+	}
+	if updated == 0 {
+		return ErrUserNotFound{ID: id}
+	}
+	return nil
+}
+
+// Returns ErrLastAdmin if the user is the only admin left
+func (us *UserStore) checkNotLastAdmin(ctx context.Context, id int64) error {
+	user, err := us.GetUserById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if Role(user.Role) != RoleAdmin {
+		return nil
+	}
+
+	numAdmins, err := us.queries.CountUsersWithRole(ctx, string(RoleAdmin))
+	if err != nil {
+		us.logger.Printf("error counting admins: %v", err)
+		return err
+	}
+	if numAdmins <= 1 {
+		return ErrLastAdmin{ID: id}
+	}
+	return nil
+}
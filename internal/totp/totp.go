@@ -0,0 +1,120 @@
+// Package totp implements RFC 6238 time-based one-time passwords, as used by authenticator apps, with
+// the defaults those apps expect: HMAC-SHA1, 6 digits and a 30 second period
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	// How many periods either side of now a code is still accepted, to allow for clock drift and
+	// slow typing
+	Skew = 1
+)
+
+// Secrets are shared with authenticator apps as unpadded base32
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RFC 4226 requires secrets of at least 128 bits
+const minSecretBytes = 16
+
+// GenerateSecret makes up a new random 160 bit secret, base32 encoded
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// Step returns the number of the period that t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for the secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t)), nil
+}
+
+// Validate checks the code against the secret at time t, allowing for Skew. It returns the step the
+// code matched, so callers can refuse to accept the same code twice
+func Validate(secret string, given string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	given = strings.ReplaceAll(given, " ", "")
+	if len(given) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(code(key, step))) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth:// URI that authenticator apps scan (as a QR code) or open to
+// add the account
+func ProvisioningURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", Digits))
+	query.Set("period", fmt.Sprintf("%d", int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	if len(key) < minSecretBytes {
+		return nil, fmt.Errorf("invalid TOTP secret: only %d bits long", len(key)*8)
+	}
+	return key, nil
+}
+
+// The HOTP value (RFC 4226) for the counter
+func code(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range Digits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo)
+}
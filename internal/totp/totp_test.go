@@ -0,0 +1,102 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 secret from RFC 6238's test vectors, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B gives 8 digit codes. With 6 digits, the codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+		if step, ok := Validate(rfcSecret, tt.want, time.Unix(tt.unix, 0)); !ok || step != Step(time.Unix(tt.unix, 0)) {
+			t.Errorf("Validate(%s) at %d = %d, %v, want step %d", tt.want, tt.unix, step, ok, Step(time.Unix(tt.unix, 0)))
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		offset time.Duration // When the code was made, relative to now
+		wantOK bool
+	}{
+		{"two steps early", -2 * Period, false},
+		{"one step early", -Period, true},
+		{"now", 0, true},
+		{"one step late", Period, true},
+		{"two steps late", 2 * Period, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			madeAt := now.Add(tt.offset)
+			code, err := Code(rfcSecret, madeAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("Validate = %v, want %v", ok, tt.wantOK)
+			}
+			// The step returned is the code's own, so it can't be used again later in the window
+			if ok && step != Step(madeAt) {
+				t.Errorf("Validate matched step %d, want %d", step, Step(madeAt))
+			}
+		})
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfcSecret, "123456"},
+		{"too short", rfcSecret, "00592"},
+		{"8 digits", rfcSecret, "89005924"},
+		{"secret too short", "GEZDGNBV", "005924"},
+		{"secret not base32", "not base32!", "005924"},
+	}
+	for _, tt := range tests {
+		if _, ok := Validate(tt.secret, tt.code, now); ok {
+			t.Errorf("%s: Validate(%q, %q) accepted it", tt.name, tt.secret, tt.code)
+		}
+	}
+	// Codes can be typed with a space in the middle
+	if _, ok := Validate(rfcSecret, "005 924", now); !ok {
+		t.Error("Validate rejected a code with a space in it")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Code with a generated secret: %v", err)
+	}
+}
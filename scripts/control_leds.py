@@ -1,6 +1,9 @@
-#!/home/t/catcam_go/scripts/.venv/bin/python
+#!/usr/bin/env python3
+import sys
 import time
+import queue
 import argparse
+import threading
 import adafruit_pixelbuf
 import board
 from adafruit_led_animation.animation.rainbow import Rainbow
@@ -18,54 +21,116 @@ class Pi5Pixelbuf(adafruit_pixelbuf.PixelBuf):
     def _transmit(self, buf):
         neopixel_write(self._pin, buf)
 
+def hex_to_rgb(hex_color):
+    return tuple(int(hex_color[i:i+2], 16) for i in (0, 2, 4))
+
+def make_animation(pixels, name, speed=1.0):
+    """Build the named animation, played at speed times its normal speed"""
+    frame_time = 0.02 / speed
+    if name == "rainbow":
+        return Rainbow(pixels, speed=frame_time, period=2 / speed)
+    if name == "rainbow_chase":
+        return RainbowChase(pixels, speed=frame_time, size=5, spacing=3)
+    if name == "rainbow_comet":
+        return RainbowComet(pixels, speed=frame_time, tail_length=7, bounce=True)
+    if name == "rainbow_sparkle":
+        return RainbowSparkle(pixels, speed=frame_time, num_sparkles=15)
+    if name == "cycle":
+        return AnimationSequence(
+            make_animation(pixels, "rainbow", speed),
+            make_animation(pixels, "rainbow_chase", speed),
+            make_animation(pixels, "rainbow_comet", speed),
+            make_animation(pixels, "rainbow_sparkle", speed),
+            advance_interval=5,
+            auto_clear=True,
+        )
+    raise ValueError(f"unknown animation {name}")
+
+def read_lines(lines):
+    for line in sys.stdin:
+        lines.put(line)
+    lines.put(None)
+
+def serve(pixels):
+    """Take commands, one per line, from stdin until it's closed, playing the latest animation in
+    between. See HelperLEDDriver for the commands"""
+    lines = queue.Queue()
+    threading.Thread(target=read_lines, args=(lines,), daemon=True).start()
+    print("Listening for commands...", flush=True)
+
+    animation = None
+    while True:
+        try:
+            # Only wait for the next command if there's no animation to keep playing
+            line = lines.get(timeout=0.005) if animation else lines.get()
+        except queue.Empty:
+            animation.animate()
+            continue
+        if line is None:
+            break
+
+        parts = line.split()
+        if not parts:
+            continue
+        try:
+            if parts[0] == "fill" and len(parts) == 2:
+                animation = None
+                pixels.brightness = 1.0
+                pixels.fill(hex_to_rgb(parts[1]))
+                pixels.show()
+            elif parts[0] == "show" and len(parts) == 2:
+                # A frame rendered by CatCam's own animation engine, 6 hex digits per pixel
+                animation = None
+                pixels.brightness = 1.0
+                frame = parts[1]
+                # Write the whole frame out at once rather than pixel by pixel
+                pixels.auto_write = False
+                for i in range(min(len(pixels), len(frame) // 6)):
+                    pixels[i] = hex_to_rgb(frame[i * 6:i * 6 + 6])
+                pixels.show()
+                pixels.auto_write = True
+            elif parts[0] == "animate" and len(parts) == 4:
+                pixels.fill(0)
+                pixels.brightness = float(parts[3])
+                animation = make_animation(pixels, parts[1], float(parts[2]))
+            else:
+                print(f"Unknown command: {line.strip()}", file=sys.stderr, flush=True)
+        except ValueError as e:
+            print(f"Bad command {line.strip()}: {e}", file=sys.stderr, flush=True)
+
 def main():
     parser = argparse.ArgumentParser(description="Control NeoPixels on a Raspberry Pi 5.")
     parser.add_argument("pin", type=str, help="GPIO pin for NeoPixels (e.g., D14)")
     parser.add_argument("num_pixels", type=int, help="Number of pixels in the strip")
-    parser.add_argument("animation", type=str, choices=["rainbow", "rainbow_chase", "rainbow_comet", "rainbow_sparkle", "cycle", "solid"], help="Animation to run")
+    parser.add_argument("animation", type=str, choices=["rainbow", "rainbow_chase", "rainbow_comet", "rainbow_sparkle", "cycle", "solid", "serve"], help="Animation to run")
     parser.add_argument("--color", type=str, default="FFFFFF", help="Hex color for solid mode (default: white)")
+    parser.add_argument("--speed", type=float, default=1.0, help="How many times the normal speed to animate at (default: 1)")
+    parser.add_argument("--brightness", type=float, default=1.0, help="Brightness of animations from 0 to 1 (default: 1)")
     args = parser.parse_args()
 
     try:
         pin = getattr(board, args.pin)  # Convert string to board pin
         pixels = Pi5Pixelbuf(pin, args.num_pixels, auto_write=True, byteorder="GRB")
 
-        rainbow = Rainbow(pixels, speed=0.02, period=2)
-        rainbow_chase = RainbowChase(pixels, speed=0.02, size=5, spacing=3)
-        rainbow_comet = RainbowComet(pixels, speed=0.02, tail_length=7, bounce=True)
-        rainbow_sparkle = RainbowSparkle(pixels, speed=0.02, num_sparkles=15)
-
-        animations = AnimationSequence(
-            rainbow,
-            rainbow_chase,
-            rainbow_comet,
-            rainbow_sparkle,
-            advance_interval=5,
-            auto_clear=True,
-        )
-
-        animation_map = {
-            "rainbow": rainbow,
-            "rainbow_chase": rainbow_chase,
-            "rainbow_comet": rainbow_comet,
-            "rainbow_sparkle": rainbow_sparkle,
-            "cycle": animations,
-        }
-
-        if args.animation == "solid":
-            color = tuple(int(args.color[i:i+2], 16) for i in (0, 2, 4))
+        if args.animation == "serve":
+            serve(pixels)
+        elif args.animation == "solid":
+            color = hex_to_rgb(args.color)
             print(f"Displaying solid color #{args.color}")
             pixels.fill(color)
             pixels.show()
         else:
-            selected_animation = animation_map[args.animation]
-            print(f"Running {args.animation} animation...")
-            selected_animation.animate()
-    
+            # Keep animating until killed
+            pixels.brightness = args.brightness
+            selected_animation = make_animation(pixels, args.animation, args.speed)
+            print(f"Running {args.animation} animation...", flush=True)
+            while True:
+                selected_animation.animate()
+
     except KeyboardInterrupt:
         print("\nStopping animation...")
         pixels.fill(0)
         pixels.show()
 
 if __name__ == "__main__":
-    main()
\ No newline at end of file
+    main()